@@ -0,0 +1,351 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package bench opens the Prologix controllers and instruments of a bench
+described in a YAML or TOML config file, instead of each program parsing flags
+for the serial port, serial number, and GPIB address.
+
+An example config file:
+
+	adapters:
+	  - name: usb1
+	    serial: PX8X3YR6          # or port: /dev/ttyUSB0, or lan: 192.168.1.20:1234
+	    read_timeout: 500ms
+	    save_config: disable      # enable, disable, or unchanged
+	    reconnect: true
+	instruments:
+	  - name: psu
+	    adapter: usb1
+	    address: 5
+	    clear: true
+	    init: ["*RST", "*CLS"]
+	    pacing:
+	      after_reset: 1s
+	  - name: scanner
+	    adapter: usb1
+	    address: 9
+	    secondary_address: 96
+	    termination: lf           # crlf, cr, lf, or none
+	    eoi: false
+	    read_timeout: 3s
+
+Load the config and open the bench:
+
+	cfg, err := bench.Load("bench.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := bench.Open(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer b.Close()
+	psu, err := b.Instrument("psu")
+
+Each instrument is a *prologix.Device, which can be passed to the instrument
+drivers. The instruments of an adapter share its Controller, which switches
+the address, termination, EOI, and read timeout as needed.
+*/
+package bench
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/driver/reconnect"
+	"github.com/gotmc/prologix/driver/vcp"
+	"go.uber.org/multierr"
+)
+
+// DefaultLANPort is the TCP port of a GPIB-ETHERNET controller, which is used
+// if the lan address of an adapter doesn't include a port.
+const DefaultLANPort = "1234"
+
+// dialTimeout is the timeout connecting to a GPIB-ETHERNET controller.
+const dialTimeout = 5 * time.Second
+
+// DefaultReadTimeout is the transport read timeout used by the bench, which is
+// longer than the 3 s maximum of the Prologix read timeout.
+const DefaultReadTimeout = 5 * time.Second
+
+// OpenFunc opens the transport of an adapter.
+type OpenFunc func(a AdapterConfig) (io.ReadWriteCloser, error)
+
+// Bench holds the opened Controllers and instruments of a bench.
+type Bench struct {
+	controllers map[string]*prologix.Controller
+	instruments map[string]*prologix.Device
+	closers     []io.Closer
+}
+
+type options struct {
+	open     OpenFunc
+	ctrlOpts []prologix.ControllerOption
+}
+
+// Option applies an option to Open.
+type Option func(*options)
+
+// WithOpenFunc sets the function that opens the transport of each adapter,
+// such as to use a custom transport or an emulator. By default the serial
+// port or network connection given in the config is opened.
+func WithOpenFunc(open OpenFunc) Option {
+	return func(o *options) { o.open = open }
+}
+
+// WithControllerOptions adds options, such as prologix.WithLogger or
+// prologix.WithMetrics, to every Controller. The settings in the config take
+// precedence.
+func WithControllerOptions(opts ...prologix.ControllerOption) Option {
+	return func(o *options) { o.ctrlOpts = append(o.ctrlOpts, opts...) }
+}
+
+// Open validates the config, opens the adapters, and configures the
+// instruments, sending the Selected Device Clear (SDC) message and the init
+// commands as given in the config. If any step fails, the opened adapters are
+// closed again.
+func Open(cfg *Config, opts ...Option) (*Bench, error) {
+	o := options{open: open}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	b := Bench{
+		controllers: make(map[string]*prologix.Controller),
+		instruments: make(map[string]*prologix.Device),
+	}
+	for _, a := range cfg.Adapters {
+		if err := b.openAdapter(cfg, a, o); err != nil {
+			return nil, multierr.Append(fmt.Errorf("adapter %s: %w", a.Name, err), b.Close())
+		}
+	}
+	for _, inst := range cfg.Instruments {
+		if err := b.openInstrument(inst); err != nil {
+			return nil, multierr.Append(fmt.Errorf("instrument %s: %w", inst.Name, err), b.Close())
+		}
+	}
+	return &b, nil
+}
+
+// openAdapter opens the transport of the adapter and creates its Controller,
+// addressing the first instrument of the adapter.
+func (b *Bench) openAdapter(cfg *Config, a AdapterConfig, o options) error {
+	rwc, err := o.open(a)
+	if err != nil {
+		return err
+	}
+	addr := 0
+	opts := append([]prologix.ControllerOption{}, o.ctrlOpts...)
+	first := true
+	for _, inst := range cfg.Instruments {
+		if inst.Adapter != a.Name {
+			continue
+		}
+		if first {
+			addr = inst.Address
+			first = false
+		}
+		if pacing := inst.Pacing.pacing(); pacing != nil {
+			opts = append(opts, prologix.WithAddressPacing(inst.Address, *pacing))
+		}
+	}
+	if a.ReadTimeout.Duration > 0 {
+		opts = append(opts, prologix.WithReadTimeout(int(a.ReadTimeout.Milliseconds())))
+	}
+	if a.Handshake.Duration > 0 {
+		opts = append(opts, prologix.WithHandshake(a.Handshake.Duration))
+	}
+	if a.SaveConfig != "" {
+		opts = append(opts, prologix.WithSaveConfig(saveConfigs[a.SaveConfig]))
+	}
+	if a.AR488 {
+		opts = append(opts, prologix.WithAR488())
+	}
+	c, err := prologix.NewController(rwc, addr, false, opts...)
+	if err != nil {
+		return multierr.Append(err, rwc.Close())
+	}
+	b.controllers[a.Name] = c
+	b.closers = append(b.closers, rwc)
+	return nil
+}
+
+// openInstrument creates the Device of the instrument and sends the Selected
+// Device Clear (SDC) message and the init commands.
+func (b *Bench) openInstrument(inst InstrumentConfig) error {
+	var opts []prologix.DeviceOption
+	if inst.SecondaryAddress != 0 {
+		opts = append(opts, prologix.WithDeviceSecondaryAddress(inst.SecondaryAddress))
+	}
+	if inst.Termination != "" {
+		opts = append(opts, prologix.WithDeviceGPIBTermination(terminations[inst.Termination]))
+	}
+	if inst.EOI != nil {
+		opts = append(opts, prologix.WithDeviceAssertEOI(*inst.EOI))
+	}
+	if inst.ReadTimeout.Duration > 0 {
+		opts = append(opts, prologix.WithDeviceReadTimeout(int(inst.ReadTimeout.Milliseconds())))
+	}
+	dev, err := b.controllers[inst.Adapter].Device(inst.Address, opts...)
+	if err != nil {
+		return err
+	}
+	if inst.Clear {
+		if err := dev.Do(func(c *prologix.Controller) error { return c.ClearDevice() }); err != nil {
+			return err
+		}
+	}
+	for _, cmd := range inst.Init {
+		if err := dev.Command(cmd); err != nil {
+			return fmt.Errorf("init command %q: %w", cmd, err)
+		}
+	}
+	b.instruments[inst.Name] = dev
+	return nil
+}
+
+// Instrument returns the instrument with the given name.
+func (b *Bench) Instrument(name string) (*prologix.Device, error) {
+	dev, ok := b.instruments[name]
+	if !ok {
+		return nil, fmt.Errorf("bench: unknown instrument %q", name)
+	}
+	return dev, nil
+}
+
+// Controller returns the Controller of the adapter with the given name, such
+// as to check the SRQ line. While the instruments are in use, the Controller
+// must only be used through Controller.Do.
+func (b *Bench) Controller(name string) (*prologix.Controller, error) {
+	c, ok := b.controllers[name]
+	if !ok {
+		return nil, fmt.Errorf("bench: unknown adapter %q", name)
+	}
+	return c, nil
+}
+
+// Instruments returns the sorted names of the instruments.
+func (b *Bench) Instruments() []string {
+	names := make([]string, 0, len(b.instruments))
+	for name := range b.instruments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close closes the transports of all the adapters.
+func (b *Bench) Close() error {
+	var err error
+	for _, c := range b.closers {
+		err = multierr.Append(err, c.Close())
+	}
+	b.closers = nil
+	return err
+}
+
+// pacing returns the prologix.Pacing, or nil if no pacing is configured.
+func (p PacingConfig) pacing() *prologix.Pacing {
+	if p.MinGap.Duration == 0 && p.AfterClear.Duration == 0 && p.AfterReset.Duration == 0 && len(p.Rules) == 0 {
+		return nil
+	}
+	pacing := prologix.Pacing{
+		MinGap:     p.MinGap.Duration,
+		AfterClear: p.AfterClear.Duration,
+		AfterReset: p.AfterReset.Duration,
+	}
+	for _, rule := range p.Rules {
+		pacing.Rules = append(pacing.Rules, prologix.PacingRule{Prefix: rule.Prefix, Delay: rule.Delay.Duration})
+	}
+	return &pacing
+}
+
+// open opens the serial port or network connection of the adapter, which is
+// reopened after I/O errors if reconnect is enabled.
+func open(a AdapterConfig) (io.ReadWriteCloser, error) {
+	if a.Reconnect {
+		return reconnect.New(a.Transport(DefaultReadTimeout))
+	}
+	return a.Transport(DefaultReadTimeout)()
+}
+
+// Transport returns the function opening the network connection, serial
+// port, or GPIB-USB controller with the serial number of the adapter, which
+// can be passed to reconnect.New. The reconnect setting isn't used.
+//
+// Unless a read deadline is set, every read from the transport waits at most
+// readTimeout, which must be positive. The Prologix controller sends nothing
+// when its own read timeout expires, so without it a query of an instrument
+// that doesn't answer would block forever while holding the Controller. Use
+// DefaultReadTimeout unless the Prologix read timeout is set above 3 s.
+func (a AdapterConfig) Transport(readTimeout time.Duration) reconnect.OpenFunc {
+	if readTimeout <= 0 {
+		return func() (io.ReadWriteCloser, error) {
+			return nil, fmt.Errorf("invalid transport read timeout %s (must be positive)", readTimeout)
+		}
+	}
+	switch {
+	case a.LAN != "":
+		address := a.LAN
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, DefaultLANPort)
+		}
+		return func() (io.ReadWriteCloser, error) {
+			conn, err := net.DialTimeout("tcp", address, dialTimeout)
+			if err != nil {
+				return nil, err
+			}
+			return &timeoutConn{Conn: conn, timeout: readTimeout}, nil
+		}
+	case a.Port != "":
+		return reconnect.VCP(a.Port, vcp.WithReadTimeout(readTimeout))
+	default:
+		// Find the port on each attempt, since it may change when the
+		// GPIB-USB controller is plugged back in.
+		return func() (io.ReadWriteCloser, error) {
+			port, err := vcp.FindPort(a.Serial)
+			if err != nil {
+				return nil, err
+			}
+			return vcp.NewVCP(port, vcp.WithReadTimeout(readTimeout))
+		}
+	}
+}
+
+// timeoutConn is a network connection whose reads time out after the given
+// timeout, like a VCP opened using vcp.WithReadTimeout, unless a read deadline
+// is set.
+type timeoutConn struct {
+	net.Conn
+	timeout  time.Duration
+	deadline time.Time
+}
+
+// Read reads from the connection, waiting at most the timeout unless a read
+// deadline is set.
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	deadline := c.deadline
+	if deadline.IsZero() {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if err := c.Conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+// SetReadDeadline sets the deadline for future Read calls. A zero value for t
+// restores the timeout.
+func (c *timeoutConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
@@ -0,0 +1,291 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package bench
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/emulator"
+)
+
+const benchYAML = `adapters:
+  - name: usb1
+    serial: PX8X3YR6
+    read_timeout: 1s
+    save_config: disable
+instruments:
+  - name: psu
+    adapter: usb1
+    address: 5
+    clear: true
+    init: ["*RST", "*CLS"]
+    pacing:
+      after_reset: 1ms
+  - name: scanner
+    adapter: usb1
+    address: 9
+    secondary_address: 96
+    termination: lf
+    eoi: false
+    read_timeout: 3s
+`
+
+const benchTOML = `[[adapters]]
+name = "lan1"
+lan = "192.168.1.20"
+
+[[instruments]]
+name = "dmm"
+adapter = "lan1"
+address = 22
+`
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr []string
+	}{
+		{"yaml", "bench.yaml", benchYAML, nil},
+		{"toml", "bench.toml", benchTOML, nil},
+		{
+			name: "invalid",
+			file: "bench.yaml",
+			content: `adapters:
+  - name: usb1
+    serial: PX8X3YR6
+    lan: 192.168.1.20
+    read_timeout: 5s
+instruments:
+  - name: psu
+    adapter: usb2
+    address: 31
+    secondary_address: 95
+    termination: crcr
+  - name: psu
+    adapter: usb1
+`,
+			wantErr: []string{
+				"exactly one of serial, port, or lan",
+				"read timeout 5s",
+				`unknown adapter "usb2"`,
+				"primary address 31",
+				"secondary address 95",
+				`invalid termination "crcr"`,
+				"instrument psu: duplicate name",
+			},
+		},
+		{
+			name: "shared pacing",
+			file: "bench.yaml",
+			content: `adapters:
+  - name: usb1
+    port: /dev/ttyUSB0
+instruments:
+  - name: ch1
+    adapter: usb1
+    address: 9
+    secondary_address: 96
+    pacing:
+      min_gap: 10ms
+  - name: ch2
+    adapter: usb1
+    address: 9
+    secondary_address: 97
+    pacing:
+      min_gap: 20ms
+`,
+			wantErr: []string{"instrument ch2: pacing for primary address 9 already set by instrument ch1"},
+		},
+		{"unknown key", "bench.yaml", "adapters:\n  - name: usb1\n    serail: PX8X3YR6\n", []string{"serail"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.file)
+			if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load(path)
+			if len(tc.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("Load error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Load error = nil")
+			}
+			for _, want := range tc.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Load error = %q; want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+type psu struct {
+	mu       sync.Mutex
+	commands []string
+	cleared  bool
+}
+
+func (p *psu) Handle(msg []byte) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if string(msg) == "*IDN?" {
+		return "ACME,PSU,1,1.0\n"
+	}
+	p.commands = append(p.commands, string(msg))
+	return ""
+}
+
+func (p *psu) Clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cleared = true
+}
+
+type nopCloser struct {
+	io.ReadWriter
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bench.yaml")
+	if err := os.WriteFile(path, []byte(benchYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %s", err)
+	}
+	adapter := emulator.New()
+	supply := &psu{}
+	adapter.Attach(5, supply)
+	adapter.Attach(9, emulator.InstrumentFunc(func(msg string) string {
+		if msg == "*IDN?" {
+			return "ACME,SCANNER,1,1.0\n"
+		}
+		return ""
+	}))
+	var opened []string
+	b, err := Open(cfg, WithOpenFunc(func(a AdapterConfig) (io.ReadWriteCloser, error) {
+		opened = append(opened, a.Serial)
+		return nopCloser{adapter}, nil
+	}))
+	if err != nil {
+		t.Fatalf("Open error: %s", err)
+	}
+	defer b.Close()
+	if len(opened) != 1 || opened[0] != "PX8X3YR6" {
+		t.Errorf("opened adapters = %q; want PX8X3YR6", opened)
+	}
+	if got := strings.Join(b.Instruments(), ","); got != "psu,scanner" {
+		t.Errorf("Instruments = %s; want psu,scanner", got)
+	}
+
+	supply.mu.Lock()
+	if !supply.cleared || strings.Join(supply.commands, "|") != "*RST|*CLS" {
+		t.Errorf("psu cleared = %t, commands = %q; want cleared and init commands", supply.cleared, supply.commands)
+	}
+	supply.mu.Unlock()
+	if got := adapter.Setting("read_tmo_ms"); got != "1000" {
+		t.Errorf("read_tmo_ms = %s; want 1000", got)
+	}
+
+	scanner, err := b.Instrument("scanner")
+	if err != nil {
+		t.Fatalf("Instrument error: %s", err)
+	}
+	if _, err := scanner.Query("*IDN?"); err != nil {
+		t.Fatalf("Query error: %s", err)
+	}
+	want := map[string]string{"addr": "9 96", "eos": "2", "eoi": "0", "read_tmo_ms": "3000"}
+	for name, value := range want {
+		if got := adapter.Setting(name); got != value {
+			t.Errorf("%s = %q; want %q", name, got, value)
+		}
+	}
+
+	if _, err := b.Instrument("dmm"); err == nil {
+		t.Error("Instrument(dmm) error = nil")
+	}
+	if _, err := b.Controller("usb1"); err != nil {
+		t.Errorf("Controller error: %s", err)
+	}
+}
+
+func TestOpenError(t *testing.T) {
+	cfg := &Config{
+		Adapters:    []AdapterConfig{{Name: "usb1", Port: "/dev/ttyUSB0"}},
+		Instruments: []InstrumentConfig{{Name: "psu", Adapter: "usb1", Address: 5}},
+	}
+	errOpen := errors.New("no such port")
+	_, err := Open(cfg, WithOpenFunc(func(AdapterConfig) (io.ReadWriteCloser, error) {
+		return nil, errOpen
+	}))
+	if !errors.Is(err, errOpen) {
+		t.Errorf("Open error = %v; want %v", err, errOpen)
+	}
+	cfg.Instruments[0].Address = 40
+	if _, err := Open(cfg); !errors.Is(err, prologix.ErrInvalidAddress) {
+		t.Errorf("Open error = %v; want ErrInvalidAddress", err)
+	}
+}
+
+func TestTransportReadTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %s", err)
+	}
+	defer l.Close()
+	// The Prologix sends nothing when its read timeout expires.
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+	rw, err := AdapterConfig{LAN: l.Addr().String()}.Transport(50 * time.Millisecond)()
+	if err != nil {
+		t.Fatalf("Transport error: %s", err)
+	}
+	defer rw.Close()
+	c, err := prologix.NewController(rw, 5, false)
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Query("*IDN?")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, prologix.ErrTimeout) {
+			t.Errorf("Query error %v; want ErrTimeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Query of a silent instrument didn't time out")
+	}
+
+	if _, err := (AdapterConfig{LAN: l.Addr().String()}).Transport(0)(); err == nil {
+		t.Error("Transport succeeded with a zero read timeout")
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gotmc/prologix"
+	"go.uber.org/multierr"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes the Prologix controllers of a bench and the instruments
+// connected to them.
+type Config struct {
+	Adapters    []AdapterConfig    `yaml:"adapters" toml:"adapters"`
+	Instruments []InstrumentConfig `yaml:"instruments" toml:"instruments"`
+}
+
+// AdapterConfig describes a Prologix controller, which is found using exactly
+// one of the USB serial number, the serial port, or the network address.
+type AdapterConfig struct {
+	Name        string   `yaml:"name" toml:"name"`
+	Serial      string   `yaml:"serial" toml:"serial"` // USB serial number of a GPIB-USB controller.
+	Port        string   `yaml:"port" toml:"port"`     // Serial port of a GPIB-USB controller.
+	LAN         string   `yaml:"lan" toml:"lan"`       // Address of a GPIB-ETHERNET controller.
+	ReadTimeout Duration `yaml:"read_timeout" toml:"read_timeout"`
+	Handshake   Duration `yaml:"handshake" toml:"handshake"`     // Timeout verifying the adapter, if set.
+	SaveConfig  string   `yaml:"save_config" toml:"save_config"` // enable, disable, or unchanged.
+	Reconnect   bool     `yaml:"reconnect" toml:"reconnect"`     // Reopen the transport after I/O errors.
+	AR488       bool     `yaml:"ar488" toml:"ar488"`
+}
+
+// InstrumentConfig describes an instrument connected to an adapter. The
+// termination, EOI, and read timeout default to the settings of the adapter.
+type InstrumentConfig struct {
+	Name             string       `yaml:"name" toml:"name"`
+	Adapter          string       `yaml:"adapter" toml:"adapter"`
+	Address          int          `yaml:"address" toml:"address"`
+	SecondaryAddress int          `yaml:"secondary_address" toml:"secondary_address"` // 96-126, or 0 for none.
+	Termination      string       `yaml:"termination" toml:"termination"`             // crlf, cr, lf, or none.
+	EOI              *bool        `yaml:"eoi" toml:"eoi"`
+	ReadTimeout      Duration     `yaml:"read_timeout" toml:"read_timeout"`
+	Pacing           PacingConfig `yaml:"pacing" toml:"pacing"`
+	Clear            bool         `yaml:"clear" toml:"clear"` // Send the Selected Device Clear (SDC) message when opened.
+	Init             []string     `yaml:"init" toml:"init"`   // Commands sent when opened.
+}
+
+// PacingConfig describes the prologix.Pacing of an instrument. Pacing applies
+// to the primary address of the instrument, so only one of the instruments
+// sharing a primary address using secondary addresses can set it.
+type PacingConfig struct {
+	MinGap     Duration           `yaml:"min_gap" toml:"min_gap"`
+	AfterClear Duration           `yaml:"after_clear" toml:"after_clear"`
+	AfterReset Duration           `yaml:"after_reset" toml:"after_reset"`
+	Rules      []PacingRuleConfig `yaml:"rules" toml:"rules"`
+}
+
+// PacingRuleConfig describes a prologix.PacingRule.
+type PacingRuleConfig struct {
+	Prefix string   `yaml:"prefix" toml:"prefix"`
+	Delay  Duration `yaml:"delay" toml:"delay"`
+}
+
+// Duration is a time.Duration written like "500ms" in the config file.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+var terminations = map[string]prologix.GpibTerm{
+	"crlf": prologix.AppendCRLF,
+	"cr":   prologix.AppendCR,
+	"lf":   prologix.AppendLF,
+	"none": prologix.AppendNothing,
+}
+
+var saveConfigs = map[string]prologix.SaveConfig{
+	"enable":    prologix.SaveConfigEnable,
+	"disable":   prologix.SaveConfigDisable,
+	"unchanged": prologix.SaveConfigUnchanged,
+}
+
+// Load reads and validates the config file, which is TOML if its extension is
+// .toml and YAML otherwise. Unknown keys are errors, to catch misspellings.
+func Load(path string) (*Config, error) {
+	var cfg Config
+	if err := LoadFile(path, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// LoadFile decodes the config file into v, which is TOML if its extension is
+// .toml and YAML otherwise, like Load does, for programs with their own config
+// format. Unknown keys are errors.
+func LoadFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		md, err := toml.Decode(string(data), v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%s: unknown key %q", path, undecoded[0].String())
+		}
+		return nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate returns all the errors in the config combined using multierr.
+func (cfg *Config) Validate() error {
+	var err error
+	adapters := make(map[string]bool)
+	for i, a := range cfg.Adapters {
+		name := a.Name
+		if name == "" {
+			name = fmt.Sprintf("adapter %d", i+1)
+			err = multierr.Append(err, fmt.Errorf("%s: missing name", name))
+		} else if adapters[name] {
+			err = multierr.Append(err, fmt.Errorf("adapter %s: duplicate name", name))
+		}
+		adapters[name] = true
+		n := 0
+		for _, s := range []string{a.Serial, a.Port, a.LAN} {
+			if s != "" {
+				n++
+			}
+		}
+		if n != 1 {
+			err = multierr.Append(err, fmt.Errorf("adapter %s: exactly one of serial, port, or lan is required", name))
+		}
+		if a.ReadTimeout.Duration != 0 {
+			err = multierr.Append(err, validateReadTimeout("adapter "+name, a.ReadTimeout))
+		}
+		if a.Handshake.Duration < 0 {
+			err = multierr.Append(err, fmt.Errorf("adapter %s: negative handshake timeout", name))
+		}
+		if _, ok := saveConfigs[a.SaveConfig]; !ok && a.SaveConfig != "" {
+			err = multierr.Append(err, fmt.Errorf("adapter %s: invalid save_config %q (must be enable, disable, or unchanged)", name, a.SaveConfig))
+		}
+	}
+
+	instruments := make(map[string]bool)
+	type location struct {
+		adapter            string
+		primary, secondary int
+	}
+	locations := make(map[location]string)
+	// Pacing is kept per primary address, so instruments sharing a primary
+	// address can't have their own pacing.
+	paced := make(map[location]string)
+	for i, inst := range cfg.Instruments {
+		name := inst.Name
+		if name == "" {
+			name = fmt.Sprintf("instrument %d", i+1)
+			err = multierr.Append(err, fmt.Errorf("%s: missing name", name))
+		} else if instruments[name] {
+			err = multierr.Append(err, fmt.Errorf("instrument %s: duplicate name", name))
+		}
+		instruments[name] = true
+		if !adapters[inst.Adapter] {
+			err = multierr.Append(err, fmt.Errorf("instrument %s: unknown adapter %q", name, inst.Adapter))
+		}
+		if inst.Address < 0 || inst.Address > 30 {
+			err = multierr.Append(err, fmt.Errorf("instrument %s: %w: primary address %d (must be 0-30)", name, prologix.ErrInvalidAddress, inst.Address))
+		}
+		if inst.SecondaryAddress != 0 && (inst.SecondaryAddress < 96 || inst.SecondaryAddress > 126) {
+			err = multierr.Append(err, fmt.Errorf("instrument %s: %w: secondary address %d (must be 96-126)", name, prologix.ErrInvalidAddress, inst.SecondaryAddress))
+		}
+		loc := location{inst.Adapter, inst.Address, inst.SecondaryAddress}
+		if other, ok := locations[loc]; ok {
+			err = multierr.Append(err, fmt.Errorf("instrument %s: same address as instrument %s", name, other))
+		} else {
+			locations[loc] = name
+		}
+		if inst.Pacing.pacing() != nil {
+			primary := location{inst.Adapter, inst.Address, 0}
+			if other, ok := paced[primary]; ok {
+				err = multierr.Append(err, fmt.Errorf("instrument %s: pacing for primary address %d already set by instrument %s", name, inst.Address, other))
+			} else {
+				paced[primary] = name
+			}
+		}
+		if _, ok := terminations[inst.Termination]; !ok && inst.Termination != "" {
+			err = multierr.Append(err, fmt.Errorf("instrument %s: invalid termination %q (must be crlf, cr, lf, or none)", name, inst.Termination))
+		}
+		if inst.ReadTimeout.Duration != 0 {
+			err = multierr.Append(err, validateReadTimeout("instrument "+name, inst.ReadTimeout))
+		}
+		p := inst.Pacing
+		if p.MinGap.Duration < 0 || p.AfterClear.Duration < 0 || p.AfterReset.Duration < 0 {
+			err = multierr.Append(err, fmt.Errorf("instrument %s: negative pacing delay", name))
+		}
+		for _, rule := range p.Rules {
+			if rule.Prefix == "" || rule.Delay.Duration < 0 {
+				err = multierr.Append(err, fmt.Errorf("instrument %s: pacing rule needs a prefix and a non-negative delay", name))
+			}
+		}
+	}
+	return err
+}
+
+// validateReadTimeout checks that the read timeout is a whole number of
+// milliseconds between 1 and 3000 ms, as required by the Prologix controller.
+func validateReadTimeout(name string, d Duration) error {
+	if d.Duration < time.Millisecond || d.Duration > 3*time.Second || d.Duration%time.Millisecond != 0 {
+		return fmt.Errorf("%s: read timeout %s (must be whole ms from 1ms to 3s)", name, d.Duration)
+	}
+	return nil
+}
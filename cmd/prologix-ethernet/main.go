@@ -0,0 +1,88 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+// Command prologix-ethernet shares a Prologix GPIB-USB controller over the
+// network by serving the Prologix GPIB-ETHERNET protocol on TCP port 1234.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/gotmc/prologix/driver/vcp"
+	"github.com/gotmc/prologix/server/ethernet"
+)
+
+var (
+	serialPort   string
+	serialNumber string
+	listen       string
+	debug        bool
+)
+
+func init() {
+	flag.StringVar(
+		&serialPort,
+		"port",
+		"",
+		"Serial port for Prologix VCP GPIB controller (overrides -serial)",
+	)
+	flag.StringVar(
+		&serialNumber,
+		"serial",
+		"",
+		"USB serial number of the Prologix VCP GPIB controller",
+	)
+	flag.StringVar(&listen, "listen", fmt.Sprintf(":%d", ethernet.Port), "TCP address to listen on")
+	flag.BoolVar(&debug, "debug", false, "Log client connections")
+}
+
+func main() {
+	flag.Parse()
+	if serialPort == "" {
+		if serialNumber == "" {
+			log.Fatal("one of -port or -serial is required")
+		}
+		port, err := vcp.FindPort(serialNumber)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serialPort = port
+	}
+	usb, err := vcp.NewVCP(serialPort)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer usb.Close()
+
+	level := slog.LevelWarn
+	if debug {
+		level = slog.LevelInfo
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	srv, err := ethernet.New(usb, ethernet.WithLogger(logger))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- srv.ListenAndServe(listen) }()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	select {
+	case <-sig:
+	case err := <-errs:
+		if err != nil {
+			log.Printf("server error: %s", err)
+		}
+	}
+	if err := srv.Close(); err != nil {
+		log.Printf("error closing server: %s", err)
+	}
+}
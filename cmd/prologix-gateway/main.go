@@ -0,0 +1,116 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+// Command prologix-gateway serves an HTTP/JSON API for the GPIB instruments
+// connected to a Prologix controller. For example, to query the instrument at
+// GPIB address 5:
+//
+//	curl -d '{"query": "*IDN?"}' http://localhost:8080/gpib/5/query
+//
+// The Prometheus metrics of the controller are served at /metrics.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/bench"
+	"github.com/gotmc/prologix/metrics/prometheus"
+	"github.com/gotmc/prologix/server/gateway"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	serialPort   string
+	serialNumber string
+	lanAddress   string
+	gpibAddr     int
+	listen       string
+	debug        bool
+)
+
+func init() {
+	flag.StringVar(
+		&serialPort,
+		"port",
+		"",
+		"Serial port for Prologix VCP GPIB controller (overrides -serial)",
+	)
+	flag.StringVar(
+		&serialNumber,
+		"serial",
+		"",
+		"USB serial number of the Prologix VCP GPIB controller",
+	)
+	flag.StringVar(
+		&lanAddress,
+		"lan",
+		"",
+		"Address of a Prologix GPIB-ETHERNET controller, such as 192.168.1.20:1234",
+	)
+	flag.IntVar(&gpibAddr, "gpib", 1, "GPIB address selected when starting")
+	flag.StringVar(&listen, "listen", ":8080", "HTTP address to listen on")
+	flag.BoolVar(&debug, "debug", false, "Log GPIB traffic")
+}
+
+func main() {
+	flag.Parse()
+	adapter := bench.AdapterConfig{Port: serialPort, Serial: serialNumber, LAN: lanAddress}
+	if adapter.Port == "" && adapter.Serial == "" && adapter.LAN == "" {
+		log.Fatal("one of -port, -serial, or -lan is required")
+	}
+	rw, err := adapter.Transport(bench.DefaultReadTimeout)()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rw.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	metrics := prometheus.New()
+	registry := prom.NewRegistry()
+	registry.MustRegister(metrics)
+	opts := []prologix.ControllerOption{prologix.WithLogger(logger), prologix.WithMetrics(metrics)}
+	if debug {
+		opts = append(opts, prologix.WithLogLevel(slog.LevelInfo))
+	}
+	gpib, err := prologix.NewController(rw, gpibAddr, false, opts...)
+	if err != nil {
+		log.Fatalf("NewController error: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", gateway.New(gpib, gateway.WithLogger(logger)))
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	srv := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.ListenAndServe() }()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	select {
+	case <-sig:
+	case err := <-errs:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("server error: %s", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("error closing server: %s", err)
+	}
+}
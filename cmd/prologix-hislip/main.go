@@ -0,0 +1,104 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+// Command prologix-hislip exposes the GPIB instruments connected to a Prologix
+// controller as HiSLIP instruments, so VISA software can open the instrument at
+// GPIB address N as TCPIP::host::hislipN::INSTR. The VISA default sub-address,
+// hislip0, as in TCPIP::host::INSTR, selects the GPIB address given by -gpib,
+// since GPIB address 0 is normally the Prologix controller itself.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/bench"
+	"github.com/gotmc/prologix/server/hislip"
+)
+
+var (
+	serialPort   string
+	serialNumber string
+	lanAddress   string
+	gpibAddr     int
+	listen       string
+	srqPoll      time.Duration
+	debug        bool
+)
+
+func init() {
+	flag.StringVar(
+		&serialPort,
+		"port",
+		"",
+		"Serial port for Prologix VCP GPIB controller (overrides -serial)",
+	)
+	flag.StringVar(
+		&serialNumber,
+		"serial",
+		"",
+		"USB serial number of the Prologix VCP GPIB controller",
+	)
+	flag.StringVar(
+		&lanAddress,
+		"lan",
+		"",
+		"Address of a Prologix GPIB-ETHERNET controller, such as 192.168.1.20:1234",
+	)
+	flag.IntVar(&gpibAddr, "gpib", 1, "GPIB address selected when starting and by the hislip0 sub-address")
+	flag.StringVar(&listen, "listen", fmt.Sprintf(":%d", hislip.Port), "TCP address to listen on")
+	flag.DurationVar(
+		&srqPoll,
+		"srq",
+		hislip.DefaultSRQPollInterval,
+		"Interval at which the GPIB SRQ line is checked, or 0 to disable",
+	)
+	flag.BoolVar(&debug, "debug", false, "Log GPIB traffic")
+}
+
+func main() {
+	flag.Parse()
+	adapter := bench.AdapterConfig{Port: serialPort, Serial: serialNumber, LAN: lanAddress}
+	if adapter.Port == "" && adapter.Serial == "" && adapter.LAN == "" {
+		log.Fatal("one of -port, -serial, or -lan is required")
+	}
+	rw, err := adapter.Transport(bench.DefaultReadTimeout)()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rw.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	opts := []prologix.ControllerOption{prologix.WithLogger(logger)}
+	if debug {
+		opts = append(opts, prologix.WithLogLevel(slog.LevelInfo))
+	}
+	gpib, err := prologix.NewController(rw, gpibAddr, false, opts...)
+	if err != nil {
+		log.Fatalf("NewController error: %s", err)
+	}
+
+	srv := hislip.New(gpib, hislip.WithLogger(logger), hislip.WithSRQPollInterval(srqPoll))
+	errs := make(chan error, 1)
+	go func() { errs <- srv.ListenAndServe(listen) }()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	select {
+	case <-sig:
+	case err := <-errs:
+		if err != nil {
+			log.Printf("server error: %s", err)
+		}
+	}
+	if err := srv.Close(); err != nil {
+		log.Printf("error closing server: %s", err)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gotmc/prologix/bench"
+	"go.uber.org/multierr"
+)
+
+// config is the configuration of the data logger.
+type config struct {
+	Output      outputConfig       `yaml:"output" toml:"output"`
+	Instruments []instrumentConfig `yaml:"instruments" toml:"instruments"`
+}
+
+// outputConfig configures the log file. The file is rotated when it would
+// exceed MaxBytes or when a new period of RotateEvery begins, such as every
+// 24h at midnight UTC. Zero values disable rotation.
+type outputConfig struct {
+	Path        string         `yaml:"path" toml:"path"`
+	Format      string         `yaml:"format" toml:"format"` // csv or jsonl
+	MaxBytes    int64          `yaml:"max_bytes" toml:"max_bytes"`
+	RotateEvery bench.Duration `yaml:"rotate_every" toml:"rotate_every"`
+}
+
+// instrumentConfig configures an instrument to be read periodically.
+// Instruments with the same transport share one Prologix controller.
+type instrumentConfig struct {
+	Name      string         `yaml:"name" toml:"name"`
+	Transport string         `yaml:"transport" toml:"transport"`
+	Address   int            `yaml:"address" toml:"address"`
+	Init      []string       `yaml:"init" toml:"init"`
+	Query     string         `yaml:"query" toml:"query"`
+	Parse     string         `yaml:"parse" toml:"parse"` // float, floats, int, bool, or string
+	Interval  bench.Duration `yaml:"interval" toml:"interval"`
+}
+
+// Output formats.
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
+// Parse types of the query responses.
+const (
+	parseFloat  = "float"
+	parseFloats = "floats"
+	parseInt    = "int"
+	parseBool   = "bool"
+	parseString = "string"
+)
+
+// loadConfig reads the config file, which is TOML if its extension is .toml
+// and YAML otherwise, and validates it.
+func loadConfig(path string) (config, error) {
+	var cfg config
+	if err := bench.LoadFile(path, &cfg); err != nil {
+		return cfg, err
+	}
+	cfg.setDefaults()
+	return cfg, cfg.validate()
+}
+
+// setDefaults sets the output format from the file extension and the parse
+// type to float, unless given.
+func (cfg *config) setDefaults() {
+	if cfg.Output.Format == "" {
+		cfg.Output.Format = formatCSV
+		switch strings.ToLower(filepath.Ext(cfg.Output.Path)) {
+		case ".jsonl", ".ndjson":
+			cfg.Output.Format = formatJSONL
+		}
+	}
+	for i := range cfg.Instruments {
+		if cfg.Instruments[i].Parse == "" {
+			cfg.Instruments[i].Parse = parseFloat
+		}
+	}
+}
+
+// validate returns all the errors in the config combined using multierr.
+func (cfg *config) validate() error {
+	var err error
+	if cfg.Output.Path == "" {
+		err = multierr.Append(err, fmt.Errorf("output: missing path"))
+	}
+	switch cfg.Output.Format {
+	case formatCSV, formatJSONL:
+	default:
+		err = multierr.Append(err, fmt.Errorf("output: invalid format %q (must be csv or jsonl)", cfg.Output.Format))
+	}
+	if cfg.Output.MaxBytes < 0 || cfg.Output.RotateEvery.Duration < 0 {
+		err = multierr.Append(err, fmt.Errorf("output: negative rotation limit"))
+	}
+	if len(cfg.Instruments) == 0 {
+		err = multierr.Append(err, fmt.Errorf("no instruments"))
+	}
+	names := make(map[string]bool)
+	for i, inst := range cfg.Instruments {
+		name := inst.Name
+		if name == "" {
+			name = fmt.Sprintf("instrument %d", i+1)
+			err = multierr.Append(err, fmt.Errorf("%s: missing name", name))
+		} else if names[name] {
+			err = multierr.Append(err, fmt.Errorf("%s: duplicate name", name))
+		}
+		names[name] = true
+		if _, terr := parseTransport(inst.Transport); terr != nil {
+			err = multierr.Append(err, fmt.Errorf("%s: %w", name, terr))
+		}
+		if inst.Address < 0 || inst.Address > 30 {
+			err = multierr.Append(err, fmt.Errorf("%s: GPIB address %d (must be 0-30)", name, inst.Address))
+		}
+		if inst.Query == "" {
+			err = multierr.Append(err, fmt.Errorf("%s: missing query", name))
+		}
+		switch inst.Parse {
+		case parseFloat, parseFloats, parseInt, parseBool, parseString:
+		default:
+			err = multierr.Append(err, fmt.Errorf("%s: invalid parse type %q", name, inst.Parse))
+		}
+		if inst.Interval.Duration <= 0 {
+			err = multierr.Append(err, fmt.Errorf("%s: interval must be positive", name))
+		}
+	}
+	return err
+}
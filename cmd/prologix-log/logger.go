@@ -0,0 +1,225 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/bench"
+	"github.com/gotmc/prologix/driver/reconnect"
+)
+
+// parseTransport parses the transport of an instrument, which is one of
+// "port:/dev/ttyUSB0" for a serial port, "serial:PX8ABCDE" for the USB serial
+// number of a GPIB-USB controller, or "lan:192.168.1.20:1234" for a
+// GPIB-ETHERNET controller, and returns the OpenFunc for the transport.
+func parseTransport(transport string) (reconnect.OpenFunc, error) {
+	kind, arg, ok := strings.Cut(transport, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("invalid transport %q (must be port:, serial:, or lan:)", transport)
+	}
+	switch kind {
+	case "port":
+		return bench.AdapterConfig{Port: arg}.Transport(bench.DefaultReadTimeout), nil
+	case "serial":
+		return bench.AdapterConfig{Serial: arg}.Transport(bench.DefaultReadTimeout), nil
+	case "lan":
+		return bench.AdapterConfig{LAN: arg}.Transport(bench.DefaultReadTimeout), nil
+	default:
+		return nil, fmt.Errorf("invalid transport %q (must be port:, serial:, or lan:)", transport)
+	}
+}
+
+// dataLogger reads the instruments periodically and writes the readings to
+// the output.
+type dataLogger struct {
+	cfg    config
+	out    *output
+	logger *slog.Logger
+	opts   []prologix.ControllerOption
+	open   func(transport string) (io.ReadWriteCloser, error)
+}
+
+// adapter is a Prologix controller shared by the instruments with the same
+// transport.
+type adapter struct {
+	transport   string
+	rwc         io.Closer
+	instruments []*instrument
+}
+
+// instrument is an instrument being read.
+type instrument struct {
+	cfg instrumentConfig
+	dev *prologix.Device
+
+	mu        sync.Mutex
+	needsInit bool
+}
+
+// run connects to the adapters and reads the instruments until ctx is done.
+func (l *dataLogger) run(ctx context.Context) {
+	var adapters []*adapter
+	byTransport := make(map[string]*adapter)
+	for _, cfg := range l.cfg.Instruments {
+		a, ok := byTransport[cfg.Transport]
+		if !ok {
+			a = &adapter{transport: cfg.Transport}
+			byTransport[cfg.Transport] = a
+			adapters = append(adapters, a)
+		}
+		a.instruments = append(a.instruments, &instrument{cfg: cfg, needsInit: true})
+	}
+
+	var wg sync.WaitGroup
+	for _, a := range adapters {
+		a := a
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.runAdapter(ctx, a)
+		}()
+	}
+	wg.Wait()
+}
+
+// runAdapter connects to the adapter, retrying until ctx is done, and then
+// reads its instruments.
+func (l *dataLogger) runAdapter(ctx context.Context, a *adapter) {
+	for {
+		err := l.connect(a)
+		if err == nil {
+			break
+		}
+		l.logger.Error("connecting to Prologix controller", "transport", a.transport, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+	defer a.rwc.Close()
+
+	var wg sync.WaitGroup
+	for _, inst := range a.instruments {
+		inst := inst
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.runInstrument(ctx, a, inst)
+		}()
+	}
+	wg.Wait()
+}
+
+// connect opens the transport of the adapter and creates its Controller and
+// the Devices of its instruments.
+func (l *dataLogger) connect(a *adapter) error {
+	rwc, err := l.open(a.transport)
+	if err != nil {
+		return err
+	}
+	c, err := prologix.NewController(rwc, a.instruments[0].cfg.Address, false, l.opts...)
+	if err != nil {
+		rwc.Close()
+		return err
+	}
+	for _, inst := range a.instruments {
+		dev, err := c.Device(inst.cfg.Address)
+		if err != nil {
+			rwc.Close()
+			return err
+		}
+		inst.dev = dev
+	}
+	a.rwc = rwc
+	return nil
+}
+
+// runInstrument reads the instrument at its interval until ctx is done.
+func (l *dataLogger) runInstrument(ctx context.Context, a *adapter, inst *instrument) {
+	ticker := time.NewTicker(inst.cfg.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		l.sample(a, inst)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sample reads the instrument and writes the reading to the output. If the
+// transport was reconnected, the init commands of all the instruments of the
+// adapter are sent again, since the instruments may have been power cycled
+// too, and the reading is retried once.
+func (l *dataLogger) sample(a *adapter, inst *instrument) {
+	r, err := l.read(inst)
+	if errors.Is(err, prologix.ErrReconnected) {
+		l.logger.Warn("reconnected, reinitializing instruments", "transport", a.transport)
+		for _, other := range a.instruments {
+			other.mu.Lock()
+			other.needsInit = true
+			other.mu.Unlock()
+		}
+		r, err = l.read(inst)
+	}
+	if err != nil {
+		l.logger.Error("reading instrument", "instrument", inst.cfg.Name, "error", err)
+		return
+	}
+	if err := l.out.Write(r); err != nil {
+		l.logger.Error("writing reading", "instrument", inst.cfg.Name, "error", err)
+	}
+}
+
+// read sends the init commands if needed and queries the instrument.
+func (l *dataLogger) read(inst *instrument) (reading, error) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if inst.needsInit {
+		for _, cmd := range inst.cfg.Init {
+			if err := inst.dev.Command(cmd); err != nil {
+				return reading{}, fmt.Errorf("init command %q: %w", cmd, err)
+			}
+		}
+		inst.needsInit = false
+	}
+	var v any
+	err := inst.dev.Do(func(c *prologix.Controller) (err error) {
+		switch inst.cfg.Parse {
+		case parseFloats:
+			v, err = c.QueryFloats(inst.cfg.Query)
+		case parseInt:
+			v, err = c.QueryInt(inst.cfg.Query)
+		case parseBool:
+			v, err = c.QueryBool(inst.cfg.Query)
+		case parseString:
+			v, err = c.QueryString(inst.cfg.Query)
+		default:
+			v, err = c.QueryFloat(inst.cfg.Query)
+		}
+		return err
+	})
+	if err != nil {
+		return reading{}, err
+	}
+	return reading{
+		Time:       time.Now(),
+		Instrument: inst.cfg.Name,
+		Address:    inst.cfg.Address,
+		Value:      v,
+	}, nil
+}
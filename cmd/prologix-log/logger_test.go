@@ -0,0 +1,261 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotmc/prologix/bench"
+	"github.com/gotmc/prologix/internal/emulator"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "yaml",
+			file: "log.yaml",
+			content: `output:
+  path: readings.jsonl
+instruments:
+  - name: dmm
+    transport: lan:192.168.1.20:1234
+    address: 22
+    query: "READ?"
+    interval: 10s
+`,
+		},
+		{
+			name: "toml",
+			file: "log.toml",
+			content: `[output]
+path = "readings.csv"
+rotate_every = "24h"
+
+[[instruments]]
+name = "psu"
+transport = "serial:PX8ABCDE"
+address = 5
+init = ["*RST"]
+query = "MEAS:CURR?"
+interval = "1s"
+`,
+		},
+		{
+			name: "invalid",
+			file: "log.yaml",
+			content: `output:
+  path: readings.txt
+  format: xml
+instruments:
+  - name: dmm
+    transport: usb:0
+    address: 31
+    parse: hex
+`,
+			wantErr: `output: invalid format "xml"`,
+		},
+		{
+			name:    "unknown key",
+			file:    "log.toml",
+			content: "[output]\npath = \"readings.csv\"\nrotate = \"1h\"\n",
+			wantErr: "unknown key",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.file)
+			if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadConfig(path)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("loadConfig error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("loadConfig error = %v; want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestOutputRotation(t *testing.T) {
+	dir := t.TempDir()
+	cfg := outputConfig{
+		Path:     filepath.Join(dir, "readings.csv"),
+		Format:   formatCSV,
+		MaxBytes: 100,
+	}
+	r := reading{
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Instrument: "dmm",
+		Address:    22,
+		Value:      []float64{1.5, 2},
+	}
+	out, err := openOutput(cfg)
+	if err != nil {
+		t.Fatalf("openOutput error: %s", err)
+	}
+	if err := out.Write(r); err != nil {
+		t.Fatalf("Write error: %s", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("Close error: %s", err)
+	}
+
+	// Reopening appends to the existing file without another header, until the
+	// file would exceed MaxBytes.
+	out, err = openOutput(cfg)
+	if err != nil {
+		t.Fatalf("openOutput error: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := out.Write(r); err != nil {
+			t.Fatalf("Write error: %s", err)
+		}
+	}
+	out.Close()
+
+	const line = "2024-01-02T03:04:05Z,dmm,22,1.5;2\n"
+	files, _ := filepath.Glob(filepath.Join(dir, "readings-*.csv"))
+	if len(files) != 1 {
+		t.Fatalf("rotated files = %v; want 1", files)
+	}
+	rotated, _ := os.ReadFile(files[0])
+	if want := "time,instrument,address,value\n" + line + line; string(rotated) != want {
+		t.Errorf("rotated file = %q; want %q", rotated, want)
+	}
+	current, _ := os.ReadFile(cfg.Path)
+	if want := "time,instrument,address,value\n" + line; string(current) != want {
+		t.Errorf("current file = %q; want %q", current, want)
+	}
+}
+
+func TestOutputReopensAfterFailedRotation(t *testing.T) {
+	cfg := outputConfig{
+		Path:     filepath.Join(t.TempDir(), "readings.csv"),
+		Format:   formatCSV,
+		MaxBytes: 60,
+	}
+	r := reading{Time: time.Now(), Instrument: "dmm", Address: 22, Value: 1.5}
+	out, err := openOutput(cfg)
+	if err != nil {
+		t.Fatalf("openOutput error: %s", err)
+	}
+	defer out.Close()
+	if err := out.Write(r); err != nil {
+		t.Fatalf("Write error: %s", err)
+	}
+
+	// Opening the new log file fails once after rotating the full one.
+	defer func(f func(string, int, os.FileMode) (*os.File, error)) { openFile = f }(openFile)
+	openFile = func(string, int, os.FileMode) (*os.File, error) {
+		openFile = os.OpenFile
+		return nil, os.ErrPermission
+	}
+	if err := out.Write(r); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("Write error = %v; want ErrPermission", err)
+	}
+	if err := out.Write(r); err != nil {
+		t.Fatalf("Write error after failed rotation: %s", err)
+	}
+	current, _ := os.ReadFile(cfg.Path)
+	if !strings.HasPrefix(string(current), "time,instrument,address,value\n") ||
+		!strings.HasSuffix(string(current), ",dmm,22,1.5\n") {
+		t.Errorf("current file = %q; want the header and a reading", current)
+	}
+}
+
+type nopCloser struct {
+	io.ReadWriter
+}
+
+func (nopCloser) Close() error { return nil }
+
+type meter struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (m *meter) Handle(msg []byte) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch s := string(msg); s {
+	case "READ?":
+		return "9.9E37\n"
+	default:
+		m.commands = append(m.commands, s)
+		return ""
+	}
+}
+
+func TestDataLogger(t *testing.T) {
+	adapter := emulator.New()
+	dmm := &meter{}
+	adapter.Attach(22, dmm)
+	dir := t.TempDir()
+	cfg := config{
+		Output: outputConfig{Path: filepath.Join(dir, "readings.jsonl"), Format: formatJSONL},
+		Instruments: []instrumentConfig{{
+			Name:      "dmm",
+			Transport: "lan:emulator:1234",
+			Address:   22,
+			Init:      []string{"*RST", "CONF:VOLT:DC 10"},
+			Query:     "READ?",
+			Parse:     parseFloat,
+			Interval:  bench.Duration{Duration: 10 * time.Millisecond},
+		}},
+	}
+	out, err := openOutput(cfg.Output)
+	if err != nil {
+		t.Fatalf("openOutput error: %s", err)
+	}
+	l := dataLogger{
+		cfg:    cfg,
+		out:    out,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		open: func(string) (io.ReadWriteCloser, error) {
+			return nopCloser{adapter}, nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 55*time.Millisecond)
+	defer cancel()
+	l.run(ctx)
+	out.Close()
+
+	dmm.mu.Lock()
+	if got := strings.Join(dmm.commands, "|"); got != "*RST|CONF:VOLT:DC 10" {
+		t.Errorf("init commands = %q; want sent once", got)
+	}
+	dmm.mu.Unlock()
+	data, err := os.ReadFile(cfg.Output.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("got %d readings; want several", len(lines))
+	}
+	if want := `"instrument":"dmm","address":22,"value":"+Inf"}`; !strings.HasSuffix(lines[0], want) {
+		t.Errorf("reading = %s; want suffix %s", lines[0], want)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Command prologix-log periodically reads instruments connected to one or more
+Prologix controllers and logs the timestamped readings to a CSV or JSON Lines
+file.
+
+The instruments are listed in a YAML or TOML config file:
+
+	output:
+	  path: readings.csv    # .jsonl or .ndjson for JSON Lines
+	  max_bytes: 10000000   # rotate when the file would exceed 10 MB
+	  rotate_every: 24h     # rotate daily at midnight UTC
+	instruments:
+	  - name: dmm
+	    transport: serial:PX8ABCDE   # or port:/dev/ttyUSB0 or lan:192.168.1.20:1234
+	    address: 22
+	    init: ["*RST", "CONF:VOLT:DC 10"]
+	    query: "READ?"
+	    parse: float                 # floats, int, bool, or string
+	    interval: 10s
+
+Rotated files are renamed to include the time of the rotation, such as
+readings-20240102T000000Z.csv. When restarted, the readings are appended to
+the existing log file. When a transport is reconnected after an I/O error,
+the init commands of its instruments are sent again.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/driver/reconnect"
+)
+
+var (
+	configPath string
+	debug      bool
+)
+
+func init() {
+	flag.StringVar(&configPath, "config", "prologix-log.yaml", "YAML or TOML config file")
+	flag.BoolVar(&debug, "debug", false, "Log GPIB traffic")
+}
+
+func main() {
+	flag.Parse()
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := openOutput(cfg.Output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	opts := []prologix.ControllerOption{prologix.WithLogger(logger)}
+	if debug {
+		opts = append(opts, prologix.WithLogLevel(slog.LevelInfo))
+	}
+	l := dataLogger{
+		cfg:    cfg,
+		out:    out,
+		logger: logger,
+		opts:   opts,
+		open: func(transport string) (io.ReadWriteCloser, error) {
+			open, err := parseTransport(transport)
+			if err != nil {
+				return nil, err
+			}
+			return reconnect.New(open, reconnect.WithEventHandler(func(e reconnect.Event) {
+				logger.Info("connection "+e.Kind.String(), "transport", transport, "attempt", e.Attempt, "error", e.Err)
+			}))
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	l.run(ctx)
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reading is a timestamped value read from an instrument.
+type reading struct {
+	Time       time.Time
+	Instrument string
+	Address    int
+	Value      any
+}
+
+// output appends readings to the log file, rotating it as configured. If the
+// log file already exists, for example after a restart, the readings are
+// appended to it. It is safe for concurrent use.
+type output struct {
+	cfg outputConfig
+
+	mu     sync.Mutex
+	f      *os.File // nil if opening the log file failed after rotating it
+	size   int64
+	period time.Time
+	closed bool
+}
+
+// openFile opens the log file, replaced by the tests to simulate failures.
+var openFile = os.OpenFile
+
+// openOutput opens the log file given in the config.
+func openOutput(cfg outputConfig) (*output, error) {
+	o := output{cfg: cfg}
+	if err := o.open(); err != nil {
+		return nil, err
+	}
+	if o.size > 0 {
+		fi, err := o.f.Stat()
+		if err != nil {
+			o.f.Close()
+			return nil, err
+		}
+		o.period = o.truncate(fi.ModTime())
+	} else {
+		o.period = o.truncate(time.Now())
+	}
+	return &o, nil
+}
+
+// open opens the log file for appending and writes the CSV header if the file
+// is empty.
+func (o *output) open() error {
+	f, err := openFile(o.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	o.f = f
+	o.size = fi.Size()
+	if o.size == 0 && o.cfg.Format == formatCSV {
+		return o.write([]byte("time,instrument,address,value\n"))
+	}
+	return nil
+}
+
+// Write appends the reading to the log file.
+func (o *output) Write(r reading) error {
+	line, err := o.format(r)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return os.ErrClosed
+	}
+	// Retry opening the log file if it failed after the last rotation.
+	if o.f == nil {
+		if err := o.open(); err != nil {
+			return err
+		}
+	}
+	if o.needsRotation(r.Time, len(line)) {
+		if err := o.rotate(); err != nil {
+			return err
+		}
+	}
+	o.period = o.truncate(r.Time)
+	return o.write(line)
+}
+
+// Close closes the log file.
+func (o *output) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	if o.f == nil {
+		return nil
+	}
+	err := o.f.Close()
+	o.f = nil
+	return err
+}
+
+func (o *output) write(p []byte) error {
+	n, err := o.f.Write(p)
+	o.size += int64(n)
+	return err
+}
+
+// needsRotation reports whether the log file has to be rotated before
+// writing a line of n bytes at time t. A file holding only the CSV header
+// isn't rotated.
+func (o *output) needsRotation(t time.Time, n int) bool {
+	if o.cfg.RotateEvery.Duration > 0 && !o.truncate(t).Equal(o.period) {
+		return true
+	}
+	if o.cfg.MaxBytes > 0 && o.size+int64(n) > o.cfg.MaxBytes {
+		return o.size > o.headerSize()
+	}
+	return false
+}
+
+func (o *output) headerSize() int64 {
+	if o.cfg.Format == formatCSV {
+		return int64(len("time,instrument,address,value\n"))
+	}
+	return 0
+}
+
+// truncate returns the start of the rotation period containing t.
+func (o *output) truncate(t time.Time) time.Time {
+	if o.cfg.RotateEvery.Duration <= 0 {
+		return time.Time{}
+	}
+	return t.UTC().Truncate(o.cfg.RotateEvery.Duration)
+}
+
+// rotate renames the log file to include the current time, such as
+// readings-20240102T150405Z.csv, and starts a new log file.
+func (o *output) rotate() error {
+	if err := o.f.Close(); err != nil {
+		return err
+	}
+	o.f = nil
+	ext := filepath.Ext(o.cfg.Path)
+	base := strings.TrimSuffix(o.cfg.Path, ext) + "-" + time.Now().UTC().Format("20060102T150405Z")
+	name := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	if err := os.Rename(o.cfg.Path, name); err != nil {
+		return err
+	}
+	return o.open()
+}
+
+// format formats the reading as a line of the log file.
+func (o *output) format(r reading) ([]byte, error) {
+	ts := r.Time.UTC().Format(time.RFC3339Nano)
+	if o.cfg.Format == formatJSONL {
+		line, err := json.Marshal(struct {
+			Time       string `json:"time"`
+			Instrument string `json:"instrument"`
+			Address    int    `json:"address"`
+			Value      any    `json:"value"`
+		}{ts, r.Instrument, r.Address, jsonValue(r.Value)})
+		return append(line, '\n'), err
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	err := w.Write([]string{ts, r.Instrument, strconv.Itoa(r.Address), csvValue(r.Value)})
+	w.Flush()
+	return buf.Bytes(), err
+}
+
+// csvValue formats the value as a CSV field. Several values, such as the
+// readings of a scanner, are separated by semicolons.
+func csvValue(v any) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []float64:
+		s := make([]string, len(v))
+		for i, f := range v {
+			s[i] = strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		return strings.Join(s, ";")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// jsonValue replaces the infinity and NaN values, which JSON can't represent,
+// with the strings "+Inf", "-Inf", and "NaN".
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case float64:
+		return jsonFloat(v)
+	case []float64:
+		vals := make([]any, len(v))
+		for i, f := range v {
+			vals[i] = jsonFloat(f)
+		}
+		return vals
+	default:
+		return v
+	}
+}
+
+func jsonFloat(f float64) any {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return f
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+// Command prologix-scpi-raw exposes each GPIB instrument connected to a
+// Prologix controller as a SCPI-RAW TCP instrument. The instrument at GPIB
+// address N is served on TCP port base+N, so with the default base of 5000 the
+// instrument at GPIB address 25 is served on port 5025.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/bench"
+	"github.com/gotmc/prologix/server/scpiraw"
+)
+
+var (
+	serialPort   string
+	serialNumber string
+	lanAddress   string
+	gpibAddrs    string
+	listenHost   string
+	basePort     int
+	debug        bool
+)
+
+func init() {
+	flag.StringVar(
+		&serialPort,
+		"port",
+		"",
+		"Serial port for Prologix VCP GPIB controller (overrides -serial)",
+	)
+	flag.StringVar(
+		&serialNumber,
+		"serial",
+		"",
+		"USB serial number of the Prologix VCP GPIB controller",
+	)
+	flag.StringVar(
+		&lanAddress,
+		"lan",
+		"",
+		"Address of a Prologix GPIB-ETHERNET controller, such as 192.168.1.20:1234",
+	)
+	flag.StringVar(&gpibAddrs, "gpib", "", "Comma separated GPIB addresses to serve, such as 5,10,19")
+	flag.StringVar(&listenHost, "listen", "", "Host or IP address to listen on (default all)")
+	flag.IntVar(&basePort, "base", 5000, "TCP port of GPIB address 0")
+	flag.BoolVar(&debug, "debug", false, "Log GPIB traffic")
+}
+
+func main() {
+	flag.Parse()
+	addrs, err := parseAddrs(gpibAddrs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	adapter := bench.AdapterConfig{Port: serialPort, Serial: serialNumber, LAN: lanAddress}
+	if adapter.Port == "" && adapter.Serial == "" && adapter.LAN == "" {
+		log.Fatal("one of -port, -serial, or -lan is required")
+	}
+	rw, err := adapter.Transport(bench.DefaultReadTimeout)()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rw.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	opts := []prologix.ControllerOption{prologix.WithLogger(logger)}
+	if debug {
+		opts = append(opts, prologix.WithLogLevel(slog.LevelInfo))
+	}
+	gpib, err := prologix.NewController(rw, addrs[0], false, opts...)
+	if err != nil {
+		log.Fatalf("NewController error: %s", err)
+	}
+
+	srv := scpiraw.New(gpib, scpiraw.WithLogger(logger))
+	errs := make(chan error, len(addrs))
+	for _, addr := range addrs {
+		listen := net.JoinHostPort(listenHost, strconv.Itoa(basePort+addr))
+		go func(addr int) { errs <- srv.ListenAndServe(addr, listen) }(addr)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	select {
+	case <-sig:
+	case err := <-errs:
+		if err != nil {
+			log.Printf("server error: %s", err)
+		}
+	}
+	if err := srv.Close(); err != nil {
+		log.Printf("error closing server: %s", err)
+	}
+	for _, addr := range addrs {
+		err := gpib.SetInstrumentAddress(addr)
+		if err == nil {
+			err = gpib.FrontPanel(true)
+		}
+		if err != nil {
+			log.Printf("error returning GPIB address %d to local control: %s", addr, err)
+		}
+	}
+}
+
+// parseAddrs parses the comma separated GPIB addresses.
+func parseAddrs(s string) ([]int, error) {
+	if s == "" {
+		return nil, fmt.Errorf("-gpib is required")
+	}
+	var addrs []int
+	for _, field := range strings.Split(s, ",") {
+		addr, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid GPIB address %q", field)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+// Command prologix-vxi11 exposes the GPIB instruments connected to a Prologix
+// controller as VXI-11 instruments, so VISA software can open the instrument
+// at GPIB address N as TCPIP::host::gpib0,N::INSTR. The portmapper is served
+// on port 111, which usually requires root and must not already be used by
+// rpcbind; use -portmapper "" to disable it when rpcbind registers the core
+// channel instead.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/bench"
+	"github.com/gotmc/prologix/server/vxi11"
+)
+
+var (
+	serialPort     string
+	serialNumber   string
+	lanAddress     string
+	gpibAddr       int
+	listen         string
+	portmapperAddr string
+	debug          bool
+)
+
+func init() {
+	flag.StringVar(
+		&serialPort,
+		"port",
+		"",
+		"Serial port for Prologix VCP GPIB controller (overrides -serial)",
+	)
+	flag.StringVar(
+		&serialNumber,
+		"serial",
+		"",
+		"USB serial number of the Prologix VCP GPIB controller",
+	)
+	flag.StringVar(
+		&lanAddress,
+		"lan",
+		"",
+		"Address of a Prologix GPIB-ETHERNET controller, such as 192.168.1.20:1234",
+	)
+	flag.IntVar(&gpibAddr, "gpib", 1, "GPIB address selected when starting")
+	flag.StringVar(&listen, "listen", ":1024", "TCP address of the VXI-11 core channel")
+	flag.StringVar(
+		&portmapperAddr,
+		"portmapper",
+		fmt.Sprintf(":%d", vxi11.PortmapperPort),
+		"TCP and UDP address of the portmapper, or empty to disable",
+	)
+	flag.BoolVar(&debug, "debug", false, "Log GPIB traffic")
+}
+
+func main() {
+	flag.Parse()
+	adapter := bench.AdapterConfig{Port: serialPort, Serial: serialNumber, LAN: lanAddress}
+	if adapter.Port == "" && adapter.Serial == "" && adapter.LAN == "" {
+		log.Fatal("one of -port, -serial, or -lan is required")
+	}
+	rw, err := adapter.Transport(bench.DefaultReadTimeout)()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rw.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	opts := []prologix.ControllerOption{prologix.WithLogger(logger)}
+	if debug {
+		opts = append(opts, prologix.WithLogLevel(slog.LevelInfo))
+	}
+	gpib, err := prologix.NewController(rw, gpibAddr, false, opts...)
+	if err != nil {
+		log.Fatalf("NewController error: %s", err)
+	}
+
+	srv := vxi11.New(gpib, vxi11.WithLogger(logger))
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	errs := make(chan error, 3)
+	go func() { errs <- srv.Serve(l) }()
+	if portmapperAddr != "" {
+		corePort := l.Addr().(*net.TCPAddr).Port
+		pl, err := net.Listen("tcp", portmapperAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pc, err := net.ListenPacket("udp", portmapperAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() { errs <- srv.ServePortmapper(pl, corePort) }()
+		go func() { errs <- srv.ServePortmapperUDP(pc, corePort) }()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	select {
+	case <-sig:
+	case err := <-errs:
+		if err != nil {
+			log.Printf("server error: %s", err)
+		}
+	}
+	if err := srv.Close(); err != nil {
+		log.Printf("error closing server: %s", err)
+	}
+}
@@ -8,8 +8,6 @@ package prologix
 import (
 	"fmt"
 	"strconv"
-	"strings"
-	"unicode"
 
 	"go.uber.org/multierr"
 )
@@ -17,21 +15,12 @@ import (
 // AssertEOI determines if the Prologix controller is configured to assert the
 // EOI signal at the end of any command sent over the GPIB port.
 func (c *Controller) AssertEOI() (bool, error) {
-	s, err := c.QueryController("eoi")
+	eoi, err := c.queryBool("eoi")
 	if err != nil {
 		return false, err
 	}
-	var eoi bool
-	if strings.TrimSpace(s) == "1" {
-		eoi = true
-	} else if strings.TrimSpace(s) == "0" {
-		eoi = false
-	} else {
-		return false, fmt.Errorf("eoi mode not determinable; received %s", s)
-	}
-	if eoi != c.eoi {
-		c.eoi = eoi
-		return false, fmt.Errorf("internal state mismatch, eoi is now %t", eoi)
+	if err := reconcile("eoi", &c.eoi, eoi); err != nil {
+		return false, err
 	}
 	return eoi, nil
 }
@@ -65,11 +54,7 @@ func (c *Controller) FrontPanel(enable bool) error {
 // GPIBTermination uses the Prologix `eos` command to query the GPIB
 // terminator.
 func (c *Controller) GPIBTermination() (GpibTerm, error) {
-	s, err := c.QueryController("eos")
-	if err != nil {
-		return 0, err
-	}
-	term, err := strconv.Atoi(strings.TrimSpace(s))
+	term, err := c.queryInt("eos")
 	if err != nil {
 		return 0, err
 	}
@@ -82,68 +67,27 @@ func (c *Controller) InstrumentAddress() (int, int, error) {
 	if err != nil {
 		return 0, 0, err
 	}
-	s = strings.TrimSpace(s)
-	idx := 0
-	for i, c := range s {
-		if !unicode.IsNumber(c) {
-			idx = i
-			break
-		}
-	}
-	if idx == 0 {
-		idx = len(s)
-	}
-	pri, err := strconv.ParseInt(s[:idx], 10, 8)
+	pri, sec, err := parseAddress(s)
 	if err != nil {
 		return 0, 0, err
 	}
-
-	var errs []error
-
-	if int(pri) != c.primaryAddr {
-		errs = append(errs,
-			fmt.Errorf("internal state mismatch, primary address was %d now %d", c.primaryAddr, pri))
-	}
-	c.primaryAddr = int(pri)
-
-	// secondary address?
-	remain := strings.TrimLeft(s[idx:], " :,\t")
-	var sec int64
-	if len(remain) > 0 {
-		sec, err = strconv.ParseInt(remain, 10, 8)
-		if err != nil {
-			errs = append(errs, fmt.Errorf("parsing secondary address in %s: %w", s, err))
-			return 0, 0, multierr.Combine(errs...)
-		}
-	}
-	if c.secondaryAddr != int(sec) {
-		errs = append(errs,
-			fmt.Errorf("internal state mismatch, secondary address was %d now %d", c.secondaryAddr, sec))
-	}
-	c.secondaryAddr = int(sec)
-
-	merr := multierr.Combine(errs...) // result is nil if errs is empty
+	merr := multierr.Combine(
+		reconcile("primary address", &c.primaryAddr, pri),
+		reconcile("secondary address", &c.secondaryAddr, sec),
+	) // result is nil if there are no mismatches
+	c.hasSecondaryAddr = sec != 0
 	return c.primaryAddr, c.secondaryAddr, merr
 }
 
 // ReadAfterWrite determines if the Prologix controller is configured to
 // automatically read after a write.
 func (c *Controller) ReadAfterWrite() (bool, error) {
-	s, err := c.QueryController("auto")
+	auto, err := c.queryBool("auto")
 	if err != nil {
 		return false, err
 	}
-	var auto bool
-	if strings.TrimSpace(s) == "1" {
-		auto = true
-	} else if strings.TrimSpace(s) == "0" {
-		auto = false
-	} else {
-		return false, fmt.Errorf("auto mode not determinable; received %s", s)
-	}
-	if auto != c.auto {
-		c.auto = auto
-		return false, fmt.Errorf("internal state mismatch, auto is now %t", auto)
+	if err := reconcile("auto", &c.auto, auto); err != nil {
+		return false, err
 	}
 	return auto, nil
 }
@@ -151,19 +95,15 @@ func (c *Controller) ReadAfterWrite() (bool, error) {
 // ReadTimeout queries the read timeout value in milliseconds from the Prologix
 // GPIB controller.
 func (c *Controller) ReadTimeout() (int, error) {
-	s, err := c.QueryController("read_tmo_ms")
+	readTimeout, err := c.queryInt("read_tmo_ms")
 	if err != nil {
 		return 0, err
 	}
-	i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
-	if err != nil {
-		return 0, err
-	}
-	readTimeout := int(i)
-	if readTimeout < 1 || readTimeout > 3000 {
-		return 0, fmt.Errorf(
-			"read timeout must be between 1 and 3000 ms was set to %d",
-			readTimeout,
+	if !isReadTimeoutValid(readTimeout) {
+		return 0, unexpectedResponse(
+			"++read_tmo_ms",
+			strconv.Itoa(readTimeout),
+			fmt.Errorf("read timeout must be between 1 and 3000 ms was set to %d", readTimeout),
 		)
 	}
 	return readTimeout, nil
@@ -175,22 +115,27 @@ func (c *Controller) Reset() error {
 	return c.CommandController("rst")
 }
 
+// SerialPoll uses the Prologix `spoll` command to serial poll the instrument
+// at the current GPIB address and returns its status byte.
+func (c *Controller) SerialPoll() (byte, error) {
+	stb, err := c.queryInt("spoll")
+	if err != nil {
+		return 0, err
+	}
+	if stb < 0 || stb > 255 {
+		return 0, unexpectedResponse("++spoll", strconv.Itoa(stb), nil)
+	}
+	return byte(stb), nil
+}
+
 // ServiceRequest sends the `srq` command to the Prologix controller to
 // determine if the GPIB SRQ signal is asserted or not.
 func (c *Controller) ServiceRequest() (bool, error) {
-	s, err := c.QueryController("srq")
-	if err != nil {
-		return false, err
-	}
-	var srq bool
-	if strings.TrimSpace(s) == "1" {
-		srq = true
-	} else if strings.TrimSpace(s) == "0" {
-		srq = false
-	} else {
-		return false, fmt.Errorf("srq not determinable; received %s", s)
+	asserted, err := c.queryBool("srq")
+	if asserted {
+		c.metrics.ServiceRequest()
 	}
-	return srq, nil
+	return asserted, err
 }
 
 // SetAssertEOI sets the Prologix controller to assert the EOI signal after the
@@ -214,11 +159,19 @@ func (c *Controller) SetAssertEOI(enable bool) error {
 // be appended as the GPIB terminator to all data sent from the Prologix
 // Controller to the instrument.
 func (c *Controller) SetGPIBTermination(term GpibTerm) error {
-	return c.CommandController(fmt.Sprintf("eos %d", term))
+	err := c.CommandController(fmt.Sprintf("eos %d", term))
+	if err != nil {
+		return err
+	}
+	c.eos = term
+	return nil
 }
 
 // SetInstrumentAddress sets the GPIB address for the instrument under control.
 func (c *Controller) SetInstrumentAddress(addr int) error {
+	if !isPrimaryAddressValid(addr) {
+		return fmt.Errorf("%w: primary address %d (must be 0-30)", ErrInvalidAddress, addr)
+	}
 	cmd := fmt.Sprintf("addr %d", addr)
 	err := c.CommandController(cmd)
 	if err != nil {
@@ -248,10 +201,21 @@ func (c *Controller) SetReadAfterWrite(enable bool) error {
 // SetReadTimeout sets the Proglogix controller's read timeout in milliseconds.
 // The timeout must be between 1 and 3000 milliseconds.
 func (c *Controller) SetReadTimeout(timeout int) error {
-	if timeout < 1 || timeout > 3000 {
+	if !isReadTimeoutValid(timeout) {
 		return fmt.Errorf("read timeout outside 1 to 3000 ms; attempted to set to %d", timeout)
 	}
-	return c.CommandController(fmt.Sprintf("read_tmo_ms %d", timeout))
+	err := c.CommandController(fmt.Sprintf("read_tmo_ms %d", timeout))
+	if err != nil {
+		return err
+	}
+	c.readTimeout = timeout
+	return nil
+}
+
+// Trigger sends the `trg` command to the Prologix controller which sends the
+// Group Execute Trigger (GET) message to the current GPIB address.
+func (c *Controller) Trigger() error {
+	return c.CommandController("trg")
 }
 
 // Version returns the version string from the Prologix GPIB controller.
@@ -7,12 +7,28 @@ package prologix
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotmc/prologix/internal/netserver"
 )
 
+// esc is the Prologix escape character, which causes the following CR, LF,
+// ESC, or `+` character to be sent to the instrument instead of being
+// interpreted by the Prologix.
+const esc = 0x1b
+
+// EOT is the ASCII end-of-transmission character. Unlike the default LF, it
+// isn't used as a GPIB terminator, so setting it as the EOT character using
+// WithEOTChar lets QueryWithTimeout tell the end of the response apart from
+// the data without waiting.
+const EOT = 0x04
+
 // Controller models a GPIB controller-in-charge.
 type Controller struct {
 	rw               io.ReadWriter
@@ -21,15 +37,51 @@ type Controller struct {
 	secondaryAddr    int
 	auto             bool
 	eoi              bool
+	eos              GpibTerm
 	usbTerm          byte
 	eotChar          byte
-	debug            bool // if true, print controller commands before sending. Set via WithDebug().
-	ar488            bool // compatibility with Arduino AR488 - see WithAR488 documentation for details.
+	eotEnable        bool
+	readTimeout      int               // Read timeout in milliseconds.
+	saveConfig       SaveConfig        // EEPROM policy for the init sequence. Set via WithSaveConfig().
+	skipInit         bool              // if true, NewController doesn't configure the Prologix controller.
+	handshakeTimeout time.Duration     // if positive, NewController verifies the adapter. Set via WithHandshake().
+	logger           *slog.Logger      // Traffic logger; silent unless set via WithLogger() or WithDebug().
+	logLevel         slog.Level        // Level at which traffic is logged. Set via WithLogLevel().
+	metrics          Metrics           // Traffic and health measurements. Set via WithMetrics().
+	pacing           Pacing            // Pacing for all instruments. Set via WithPacing().
+	addrPacing       map[int]Pacing    // Pacing per primary address. Set via WithAddressPacing().
+	nextWrite        map[int]time.Time // Earliest time of the next paced write per primary address.
+	reconnecting     bool              // true while recovering from a lost connection.
+	ar488            bool              // compatibility with Arduino AR488 - see WithAR488 documentation for details.
+	mu               sync.Mutex        // Serializes bus access by Devices.
 }
 
+// readTimeoutMargin is the extra time allowed beyond the Prologix read timeout
+// for the response to reach the host.
+const readTimeoutMargin = 200 * time.Millisecond
+
 // ControllerOption applies an option to the controller.
 type ControllerOption func(*Controller)
 
+// SaveConfig determines how the NewController init sequence treats the
+// Prologix `savecfg` setting, which controls whether configuration changes are
+// saved in the EEPROM of the Prologix controller.
+type SaveConfig int
+
+// Available policies for saving the configuration in the EEPROM.
+const (
+	// SaveConfigEnable disables saving during the init sequence and then
+	// enables it again, which writes the configuration to the EEPROM.
+	SaveConfigEnable SaveConfig = iota
+	// SaveConfigDisable disables saving during the init sequence and leaves it
+	// disabled, so the EEPROM is left untouched.
+	SaveConfigDisable
+	// SaveConfigUnchanged doesn't send the `savecfg` command at all. If saving
+	// is enabled on the Prologix controller, the init sequence is written to
+	// the EEPROM.
+	SaveConfigUnchanged
+)
+
 // NewController creates a GPIB controller-in-charge at the given address using
 // the given Prologix driver, which can either be a Virtual COM Port (VCP), USB
 // direct, or Ethernet. Enable clear to send the Selected Device Clear (SDC)
@@ -47,8 +99,15 @@ func NewController(
 		hasSecondaryAddr: false,
 		auto:             false,
 		eoi:              true,
+		eos:              AppendCRLF,
 		usbTerm:          '\n',
 		eotChar:          '\n',
+		eotEnable:        true,
+		readTimeout:      500,
+		saveConfig:       SaveConfigEnable,
+		logger:           netserver.DiscardLogger(),
+		logLevel:         slog.LevelDebug,
+		metrics:          nopMetrics{},
 	}
 
 	// Apply options using the functional option pattern.
@@ -58,40 +117,30 @@ func NewController(
 
 	// Verify validate primary address.
 	if !isPrimaryAddressValid(c.primaryAddr) {
-		return nil, fmt.Errorf("invalid primary address %d (must by 0-30)", c.primaryAddr)
+		return nil, fmt.Errorf("%w: primary address %d (must be 0-30)", ErrInvalidAddress, c.primaryAddr)
+	}
+	if c.hasSecondaryAddr && !isSecondaryAddressValid(c.secondaryAddr) {
+		return nil, fmt.Errorf("%w: secondary address %d (must be 96-126)", ErrInvalidAddress, c.secondaryAddr)
+	}
+	if !isReadTimeoutValid(c.readTimeout) {
+		return nil, fmt.Errorf("read timeout outside 1 to 3000 ms; attempted to set to %d", c.readTimeout)
+	}
+	if _, ok := gpibTermDesc[c.eos]; !ok {
+		return nil, fmt.Errorf("invalid GPIB termination %d (must be 0-3)", c.eos)
 	}
 
-	// Configure the Prologix GPIB controller.
-	addrCmd := fmt.Sprintf("addr %d", c.primaryAddr)
-	if c.hasSecondaryAddr {
-		if !isSecondaryAddressValid(c.secondaryAddr) {
-			return nil, fmt.Errorf("invalid secondary address %d (must be 96-126)", c.secondaryAddr)
+	if c.handshakeTimeout > 0 {
+		if err := c.handshake(c.handshakeTimeout); err != nil {
+			return nil, fmt.Errorf("handshake failed: %w", err)
 		}
-		addrCmd = fmt.Sprintf("addr %d %d", c.primaryAddr, c.secondaryAddr)
 	}
-	eotCharCmd := fmt.Sprintf("eot_char %d", c.eotChar)
-	cmds := []string{}
-	if !c.ar488 {
-		cmds = append(cmds,
-			"verbose 0", // turn off verbosity if on
-			"savecfg 0", // Disable saving of configuration parameters in EPROM
-		)
-	}
-	cmds = append(cmds,
-		addrCmd,           // Set the primary address.
-		"mode 1",          // Switch to controller mode.
-		"auto 0",          // Turn off read-after-write and address instrument to listen.
-		"eoi 1",           // Enable EOI assertion with last character.
-		"eos 0",           // Set GPIB termination.
-		"read_tmo_ms 500", // Set the read timeout to 500 ms.
-		eotCharCmd,        // Set the EOT char
-		"eot_enable 1",    // Append character when EOI detected?
-	)
-	if !c.ar488 {
-		cmds = append(cmds,
-			"savecfg 1", // Enable saving of configuration parameters in EPROM
-		)
+
+	if c.skipInit {
+		return &c, nil
 	}
+
+	// Configure the Prologix GPIB controller.
+	cmds := c.initCommands()
 	if clear {
 		cmds = append(cmds, "clr")
 	}
@@ -104,6 +153,36 @@ func NewController(
 	return &c, nil
 }
 
+// initCommands returns the Prologix commands that configure the Prologix
+// controller to match the settings of the Controller.
+func (c *Controller) initCommands() []string {
+	addrCmd := fmt.Sprintf("addr %d", c.primaryAddr)
+	if c.hasSecondaryAddr {
+		addrCmd = fmt.Sprintf("addr %d %d", c.primaryAddr, c.secondaryAddr)
+	}
+	cmds := []string{}
+	if !c.ar488 {
+		cmds = append(cmds, "verbose 0") // turn off verbosity if on
+		if c.saveConfig != SaveConfigUnchanged {
+			cmds = append(cmds, "savecfg 0") // Disable saving of configuration parameters in EPROM
+		}
+	}
+	cmds = append(cmds,
+		addrCmd,                                         // Set the primary address.
+		"mode 1",                                        // Switch to controller mode.
+		fmt.Sprintf("auto %d", btoi(c.auto)),            // Set read-after-write.
+		fmt.Sprintf("eoi %d", btoi(c.eoi)),              // Set EOI assertion with last character.
+		fmt.Sprintf("eos %d", c.eos),                    // Set GPIB termination.
+		fmt.Sprintf("read_tmo_ms %d", c.readTimeout),    // Set the read timeout.
+		fmt.Sprintf("eot_char %d", c.eotChar),           // Set the EOT char
+		fmt.Sprintf("eot_enable %d", btoi(c.eotEnable)), // Append character when EOI detected?
+	)
+	if !c.ar488 && c.saveConfig == SaveConfigEnable {
+		cmds = append(cmds, "savecfg 1") // Enable saving of configuration parameters in EPROM
+	}
+	return cmds
+}
+
 // WithSecondaryAddress sets a secondary address, which must be in the range of
 // 96 and 126, inclusive.
 func WithSecondaryAddress(addr int) ControllerOption {
@@ -113,32 +192,144 @@ func WithSecondaryAddress(addr int) ControllerOption {
 	}
 }
 
-// WithDebug causes commands and responses to be logged.
-func WithDebug() ControllerOption { return func(c *Controller) { c.debug = true } }
+// WithReadAfterWrite sets whether the Prologix controller automatically
+// addresses the instrument to talk after sending it a command, which is
+// disabled by default.
+func WithReadAfterWrite(enable bool) ControllerOption {
+	return func(c *Controller) { c.auto = enable }
+}
+
+// WithAssertEOI sets whether the Prologix controller asserts the EOI signal
+// with the last character sent over GPIB, which is enabled by default.
+func WithAssertEOI(enable bool) ControllerOption {
+	return func(c *Controller) { c.eoi = enable }
+}
+
+// WithGPIBTermination sets the GPIB terminator appended to data sent to the
+// instrument, which defaults to AppendCRLF.
+func WithGPIBTermination(term GpibTerm) ControllerOption {
+	return func(c *Controller) { c.eos = term }
+}
+
+// WithReadTimeout sets the Prologix controller's read timeout in milliseconds,
+// which must be between 1 and 3000 milliseconds and defaults to 500 ms.
+func WithReadTimeout(timeout int) ControllerOption {
+	return func(c *Controller) { c.readTimeout = timeout }
+}
+
+// WithEOTChar sets the character appended to data received from the
+// instrument when EOI is detected, which defaults to a new line. To detect the
+// end of responses reliably, use a character that the instrument doesn't use
+// as its terminator, such as EOT.
+func WithEOTChar(char byte) ControllerOption {
+	return func(c *Controller) { c.eotChar = char }
+}
+
+// WithEOTEnable sets whether the EOT character is appended to data received
+// from the instrument when EOI is detected, which is enabled by default.
+// Query and QueryController read until the EOT character, so disabling it is
+// only useful when reading with Read.
+func WithEOTEnable(enable bool) ControllerOption {
+	return func(c *Controller) { c.eotEnable = enable }
+}
+
+// WithSaveConfig sets the EEPROM policy for the init sequence, which defaults
+// to SaveConfigEnable. Use SaveConfigDisable to avoid wearing out the EEPROM
+// when frequently reconnecting.
+func WithSaveConfig(policy SaveConfig) ControllerOption {
+	return func(c *Controller) { c.saveConfig = policy }
+}
+
+// WithSkipInit skips sending the init sequence to the Prologix controller,
+// which is assumed to already be configured to match the options given to
+// NewController.
+func WithSkipInit() ControllerOption {
+	return func(c *Controller) { c.skipInit = true }
+}
+
+// WithDebug causes commands and responses to be logged at the info level
+// using the default slog logger.
+func WithDebug() ControllerOption {
+	return func(c *Controller) {
+		c.logger = slog.Default()
+		c.logLevel = slog.LevelInfo
+	}
+}
+
+// WithLogger sets the structured logger used to log all traffic between the
+// host and the Prologix controller. By default nothing is logged.
+func WithLogger(logger *slog.Logger) ControllerOption {
+	return func(c *Controller) {
+		if logger == nil {
+			logger = netserver.DiscardLogger()
+		}
+		c.logger = logger
+	}
+}
+
+// WithLogLevel sets the level at which traffic is logged, which defaults to
+// slog.LevelDebug.
+func WithLogLevel(level slog.Level) ControllerOption {
+	return func(c *Controller) { c.logLevel = level }
+}
 
 // WithAR488 slightly alters the init commands, for compatiblity with the
 // Arduino-based AR488. Specifically, we do not emit 'verbose 0', nor do
-// we toggle savecfg.
+// we toggle savecfg regardless of the SaveConfig policy.
 func WithAR488() ControllerOption { return func(c *Controller) { c.ar488 = true } }
 
 // Write writes the given data to the instrument at the currently assigned GPIB
 // address.
 func (c *Controller) Write(p []byte) (n int, err error) {
-	return c.rw.Write(p)
+	c.pace()
+	start := time.Now()
+	n, err = c.rw.Write(p)
+	c.logTraffic("tx", start, n, err)
+	c.schedule("")
+	return n, c.recoverIO(err)
+}
+
+// WriteBinary sends binary data, such as a SCPI command containing a definite
+// length block, to the instrument at the currently assigned GPIB address. The
+// CR, LF, ESC, and `+` characters in the data are escaped, so that the
+// Prologix passes them to the instrument instead of removing them, and the
+// USB terminator is appended. The returned byte count excludes the escape
+// characters and the USB terminator.
+func (c *Controller) WriteBinary(p []byte) (n int, err error) {
+	buf := make([]byte, 0, len(p)+len(p)/8+1)
+	for _, b := range p {
+		switch b {
+		case '\r', '\n', esc, '+':
+			buf = append(buf, esc)
+		}
+		buf = append(buf, b)
+	}
+	buf = append(buf, c.usbTerm)
+	c.pace()
+	start := time.Now()
+	written, err := c.rw.Write(buf)
+	c.logTraffic("tx", start, written, err)
+	c.schedule("")
+	c.metrics.Command(c.primaryAddr)
+	if err != nil {
+		return 0, c.recoverIO(err)
+	}
+	return len(p), nil
 }
 
 // Read reads from the instrument at the currently assigned GPIB address into
 // the given byte slice.
 func (c *Controller) Read(p []byte) (n int, err error) {
-	return c.rw.Read(p)
+	start := time.Now()
+	n, err = c.rw.Read(p)
+	c.logTraffic("rx", start, n, err)
+	return n, c.recoverIO(err)
 }
 
 // WriteString writes a string to the instrument at the currently assigned GPIB
 // address.
 func (c *Controller) WriteString(s string) (n int, err error) {
-	cmd := fmt.Sprintf("%s%c", strings.TrimSpace(s), c.usbTerm)
-	log.Printf("prologix driver writing string: %s", cmd)
-	return c.rw.Write([]byte(cmd))
+	return c.send(strings.TrimSpace(s))
 }
 
 // Command formats according to a format specifier if provided and sends a
@@ -150,15 +341,8 @@ func (c *Controller) Command(format string, a ...any) error {
 	if a != nil {
 		cmd = fmt.Sprintf(format, a...)
 	}
-	// log.Printf("sending cmd (terminator not yet added): %#v", cmd)
-	// TODO: Why am I trimming whitespace and adding the USB terminator here if
-	// I'm calling the WriteString method, which does that as well?
-	cmd = fmt.Sprintf("%s%c", strings.TrimSpace(cmd), c.usbTerm)
-	// log.Printf("sending cmd (with terminator added): %#v", cmd)
-	if c.debug {
-		log.Printf("cmd %q (%x)", cmd, cmd)
-	}
-	_, err := fmt.Fprint(c.rw, cmd)
+	_, err := c.send(strings.TrimSpace(cmd))
+	c.metrics.Command(c.primaryAddr)
 	return err
 }
 
@@ -170,47 +354,288 @@ func (c *Controller) Command(format string, a ...any) error {
 // non-escaped LF, CR and ESC characters and appends the GPIB terminator, as
 // specified by the `eos` command, before sending the data to instruments.  To
 // change the GPIB terminator use the SetGPIBTermination method.
-func (c *Controller) Query(cmd string) (string, error) {
-	cmd = fmt.Sprintf("%s%c", strings.TrimSpace(cmd), c.usbTerm)
-	if c.debug {
-		log.Printf("query: %q", cmd)
-	}
-	_, err := fmt.Fprint(c.rw, cmd)
+func (c *Controller) Query(cmd string) (s string, err error) {
+	start := time.Now()
+	defer func() { c.metrics.Query(c.primaryAddr, time.Since(start), err) }()
+	cmd = strings.TrimSpace(cmd)
+	_, err = c.send(cmd)
 	if err != nil {
-		return "", fmt.Errorf("error writing command: %s", err)
+		return "", fmt.Errorf("error writing command: %w", err)
 	}
 	// If read-after-write is disabled, need to tell the Prologix controller to
 	// read.
 	if !c.auto {
 		readCmd := "++read eoi"
-		_, err = fmt.Fprintf(c.rw, "%s%c", readCmd, c.usbTerm)
+		_, err = c.send(readCmd)
 		if err != nil {
-			return "", fmt.Errorf("error sending `%s` command: %s", readCmd, err)
+			return "", fmt.Errorf("error sending `%s` command: %w", readCmd, err)
 		}
 	}
-	s, err := bufio.NewReader(c.rw).ReadString(c.eotChar)
+	s, err = c.readResponse(cmd, start)
 	if err == io.EOF {
-		log.Printf("found EOF")
 		return s, nil
 	}
 	return s, err
 }
 
+// ReadResponse reads the response of the instrument at the current GPIB
+// address to a previously sent command, such as one sent using WriteBinary.
+// Unless read-after-write is enabled, the Prologix `++read eoi` command is sent
+// first to address the instrument to talk.
+//
+// If the EOT character is enabled, the whole response is read until the EOT
+// character, which the Prologix controller appends when EOI is detected, or
+// until the Prologix read timeout expires, so responses containing LF, such as
+// binary blocks or multi-line responses, aren't cut short. The response is
+// returned without the EOT character. Only if nothing is read before the read
+// timeout expires is ErrTimeout returned. If the transport doesn't support
+// read deadlines and the EOT character is LF or CR, as by default, the response
+// ends at the first EOT character. If the EOT character is disabled,
+// the response is read up to the first EOT character like Query does.
+func (c *Controller) ReadResponse() (s string, err error) {
+	start := time.Now()
+	defer func() { c.metrics.Query(c.primaryAddr, time.Since(start), err) }()
+	if !c.auto {
+		readCmd := "++read eoi"
+		if _, err := c.send(readCmd); err != nil {
+			return "", fmt.Errorf("error sending `%s` command: %w", readCmd, err)
+		}
+	}
+	if !c.eotEnable {
+		s, err = c.readResponse("", start)
+		if err == io.EOF {
+			return s, nil
+		}
+		return s, err
+	}
+	deadline := start.Add(time.Duration(c.readTimeout)*time.Millisecond + readTimeoutMargin)
+	resp, _, err := c.readEOI(nil, deadline)
+	if err == io.EOF {
+		err = nil
+	}
+	if err == nil && len(resp) == 0 {
+		err = fmt.Errorf("%w: no response within the read timeout", ErrTimeout)
+	}
+	c.logCommand("rx", "", string(resp), start, len(resp), err)
+	return string(resp), c.recoverIO(err)
+}
+
+// QueryWithTimeout queries the instrument at the currently assigned GPIB
+// address like Query, but waits up to the given host-side timeout for the
+// response, which may be much longer than the 3000 ms limit of the Prologix
+// read timeout. Whenever the Prologix read timeout expires without receiving
+// the end of the response, the `++read eoi` command is sent again until either
+// the EOT character, which the Prologix controller appends when EOI is
+// detected, is received or the timeout expires. The response is returned
+// without the EOT character. If the timeout expires, the partial response is
+// returned along with ErrTimeout.
+//
+// The transport must support read deadlines, such as a net.Conn or a VCP,
+// and the EOT character must be enabled; otherwise ErrUnsupported is returned.
+// If the EOT character is LF or CR, as by default, it can't be told apart from
+// the terminator of the instrument, so the end of the response is only
+// accepted after no more data arrives for a short time. Use WithEOTChar to set
+// another EOT character, such as EOT, to avoid this delay.
+func (c *Controller) QueryWithTimeout(cmd string, timeout time.Duration) (s string, err error) {
+	start := time.Now()
+	defer func() { c.metrics.Query(c.primaryAddr, time.Since(start), err) }()
+	if err := c.checkReadWithTimeout(); err != nil {
+		return "", err
+	}
+	cmd = strings.TrimSpace(cmd)
+	if _, err := c.send(cmd); err != nil {
+		return "", fmt.Errorf("error writing command: %w", err)
+	}
+	return c.readWithTimeout(cmd, start, timeout)
+}
+
+// ReadResponseWithTimeout reads the response of the instrument at the current
+// GPIB address to a previously sent command, such as one sent using
+// WriteBinary, like ReadResponse, but waits up to the given host-side timeout
+// for the response like QueryWithTimeout. The transport and EOT character
+// requirements of QueryWithTimeout apply.
+func (c *Controller) ReadResponseWithTimeout(timeout time.Duration) (s string, err error) {
+	start := time.Now()
+	defer func() { c.metrics.Query(c.primaryAddr, time.Since(start), err) }()
+	if err := c.checkReadWithTimeout(); err != nil {
+		return "", err
+	}
+	return c.readWithTimeout("", start, timeout)
+}
+
+// checkReadWithTimeout returns ErrUnsupported unless the transport supports
+// read deadlines and the EOT character is enabled, as needed to detect EOI.
+func (c *Controller) checkReadWithTimeout() error {
+	if _, ok := c.rw.(deadlineSetter); !ok {
+		return fmt.Errorf("%w: transport does not support read deadlines", ErrUnsupported)
+	}
+	if !c.eotEnable {
+		return fmt.Errorf("%w: EOT character must be enabled to detect EOI", ErrUnsupported)
+	}
+	return nil
+}
+
+// readWithTimeout reads the response to the command, which has already been
+// sent, until EOI is detected or the timeout since start expires, sending the
+// `++read eoi` command again whenever the Prologix read timeout expires.
+func (c *Controller) readWithTimeout(cmd string, start time.Time, timeout time.Duration) (string, error) {
+	deadline := start.Add(timeout)
+
+	// Each `++read` lasts at most the Prologix read timeout, so allow some
+	// extra time for the USB or network latency before sending it again.
+	attempt := time.Duration(c.readTimeout)*time.Millisecond + readTimeoutMargin
+	var resp []byte
+	for first := true; ; first = false {
+		// If read-after-write is enabled, the Prologix controller already
+		// addressed the instrument to talk after the command was written.
+		if !first || !c.auto {
+			readCmd := "++read eoi"
+			if _, err := c.send(readCmd); err != nil {
+				return string(resp), fmt.Errorf("error sending `%s` command: %w", readCmd, err)
+			}
+		}
+		attemptDeadline := time.Now().Add(attempt)
+		if attemptDeadline.After(deadline) {
+			attemptDeadline = deadline
+		}
+		var eoi bool
+		var err error
+		resp, eoi, err = c.readEOI(resp, attemptDeadline)
+		if err != nil {
+			c.logCommand("rx", cmd, string(resp), start, len(resp), err)
+			return string(resp), c.recoverIO(err)
+		}
+		if eoi {
+			c.logCommand("rx", cmd, string(resp), start, len(resp), nil)
+			return string(resp), nil
+		}
+		if !time.Now().Before(deadline) {
+			err := fmt.Errorf("%w: no EOI-terminated response within %s", ErrTimeout, timeout)
+			if cmd != "" {
+				err = fmt.Errorf("%w: no EOI-terminated response to %q within %s", ErrTimeout, cmd, timeout)
+			}
+			c.logCommand("rx", cmd, string(resp), start, len(resp), err)
+			return string(resp), err
+		}
+	}
+}
+
+// deadlineSetter is implemented by transports that support read deadlines,
+// such as a net.Conn or a VCP.
+type deadlineSetter interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// eotGrace is how long readEOI waits for more data after the EOT character
+// when the EOT character is also a GPIB terminator, since the terminator of
+// the instrument and the EOT character may arrive in separate reads.
+const eotGrace = 50 * time.Millisecond
+
+// readEOI appends the data read from the Prologix controller to resp until the
+// EOT character, which the Prologix controller appends when EOI is detected,
+// ends the response or the deadline expires. An IEEE 488.2 definite length
+// block at the start of the response is read in full first, so binary data
+// containing the EOT character isn't cut short. It returns the response
+// without the EOT character and whether EOI was detected; a timeout isn't
+// returned as an error.
+func (c *Controller) readEOI(resp []byte, deadline time.Time) ([]byte, bool, error) {
+	ds, hasDeadline := c.rw.(deadlineSetter)
+	if hasDeadline {
+		if err := ds.SetReadDeadline(deadline); err != nil {
+			return resp, false, err
+		}
+		defer ds.SetReadDeadline(time.Time{})
+	}
+	ambiguous := c.eotChar == '\n' || c.eotChar == '\r'
+	eoi := false
+	buf := make([]byte, 512)
+	for {
+		n, err := c.rw.Read(buf)
+		if n > 0 {
+			resp = append(resp, buf[:n]...)
+			eoi = c.endsWithEOT(resp)
+			if eoi && (!ambiguous || !hasDeadline) {
+				return resp[:len(resp)-1], true, nil
+			}
+			// Wait briefly for an EOT character following the terminator,
+			// or for the rest of the response if it didn't end yet.
+			next := deadline
+			if eoi {
+				next = time.Now().Add(eotGrace)
+				if next.After(deadline) {
+					next = deadline
+				}
+			}
+			if hasDeadline {
+				if err := ds.SetReadDeadline(next); err != nil {
+					return resp, false, err
+				}
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if eoi {
+			return resp[:len(resp)-1], true, nil
+		}
+		if err = wrapReadError(err); errors.Is(err, ErrTimeout) {
+			return resp, false, nil
+		}
+		return resp, false, err
+	}
+}
+
+// endsWithEOT reports whether the response ends with the EOT character after
+// any IEEE 488.2 definite length block at its start.
+func (c *Controller) endsWithEOT(resp []byte) bool {
+	end := blockEnd(resp)
+	return end >= 0 && len(resp) > end && resp[len(resp)-1] == c.eotChar
+}
+
+// blockEnd returns the length of the IEEE 488.2 definite length block, such as
+// `#210<10 bytes>`, at the start of the response, 0 if the response doesn't
+// start with a definite length block, or -1 if the block header is incomplete.
+func blockEnd(resp []byte) int {
+	if len(resp) < 2 || resp[0] != '#' || resp[1] < '1' || resp[1] > '9' {
+		return 0
+	}
+	digits := int(resp[1] - '0')
+	if len(resp) < 2+digits {
+		return -1
+	}
+	length := 0
+	for _, b := range resp[2 : 2+digits] {
+		if b < '0' || b > '9' {
+			return 0
+		}
+		length = 10*length + int(b-'0')
+	}
+	return 2 + digits + length
+}
+
 // QueryController sends the given command to the Prologix controller and
 // returns its response as a string. To indicate this is a command for the
 // Prologix controller, thereby not transmitting over GPIB, two plus signs `++`
 // are prepended. Addtionally, a new line is appended to act as the USB
 // termination character.
 func (c *Controller) QueryController(cmd string) (string, error) {
+	start := time.Now()
 	err := c.CommandController(cmd)
 	if err != nil {
 		return "", err
 	}
-	s, err := bufio.NewReader(c.rw).ReadString(c.eotChar)
-	if c.debug {
-		log.Printf("read data: %q", s)
+	return c.readResponse(controllerCommand(cmd), start)
+}
+
+// Flush discards any unread data and any data not yet sent by the underlying
+// transport. ErrUnsupported is returned if the transport, such as a network
+// connection, cannot be flushed.
+func (c *Controller) Flush() error {
+	f, ok := c.rw.(interface{ Flush() error })
+	if !ok {
+		return ErrUnsupported
 	}
-	return s, err
+	return f.Flush()
 }
 
 // CommandController sends the given command to the Prologix controller. To
@@ -218,14 +643,41 @@ func (c *Controller) QueryController(cmd string) (string, error) {
 // transmitting to the instrument over GPIB, two plus signs `++` are prepended.
 // Addtionally, a new line is appended to act as the USB termination character.
 func (c *Controller) CommandController(cmd string) error {
-	cmd = fmt.Sprintf("++%s%c", strings.ToLower(strings.TrimSpace(cmd)), c.usbTerm)
-	if c.debug {
-		log.Printf("cmd %q (%2x)", cmd, cmd)
-	}
-	_, err := c.rw.Write([]byte(cmd))
+	_, err := c.send(controllerCommand(cmd))
 	return err
 }
 
+// controllerCommand normalizes the given Prologix controller command and
+// prepends the two plus signs `++`.
+func controllerCommand(cmd string) string {
+	return "++" + strings.ToLower(strings.TrimSpace(cmd))
+}
+
+// send appends the USB terminator to the given command and writes it to the
+// Prologix controller.
+func (c *Controller) send(cmd string) (int, error) {
+	paced := isPaced(cmd)
+	if paced {
+		c.pace()
+	}
+	start := time.Now()
+	n, err := fmt.Fprintf(c.rw, "%s%c", cmd, c.usbTerm)
+	c.logCommand("tx", cmd, "", start, n, err)
+	if paced {
+		c.schedule(cmd)
+	}
+	return n, c.recoverIO(err)
+}
+
+// readResponse reads from the Prologix controller until the EOT character is
+// received. The cmd is only used for logging the response.
+func (c *Controller) readResponse(cmd string, start time.Time) (string, error) {
+	s, err := bufio.NewReader(c.rw).ReadString(c.eotChar)
+	err = wrapReadError(err)
+	c.logCommand("rx", cmd, s, start, len(s), err)
+	return s, c.recoverIO(err)
+}
+
 // GpibTerm provides the type for the available GPIB terminators.
 type GpibTerm int
 
@@ -265,3 +717,17 @@ func isSecondaryAddressValid(addr int) bool {
 	}
 	return true
 }
+
+// isReadTimeoutValid checks that the read timeout is between 1 and 3000
+// milliseconds, inclusive.
+func isReadTimeoutValid(timeout int) bool {
+	return timeout >= 1 && timeout <= 3000
+}
+
+// btoi converts a bool into the 0 or 1 used by the Prologix commands.
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -6,8 +6,14 @@
 package prologix
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"os"
 	"testing"
+	"time"
+
+	"github.com/gotmc/prologix/internal/emulator"
 )
 
 func TestIsPrimaryAddressValid(t *testing.T) {
@@ -69,3 +75,245 @@ func TestIsSecondaryAddressValid(t *testing.T) {
 		})
 	}
 }
+
+// fakeAdapter records everything written to it and replays the given
+// responses when read.
+type fakeAdapter struct {
+	written bytes.Buffer
+	resp    bytes.Buffer
+}
+
+func (f *fakeAdapter) Write(p []byte) (int, error) { return f.written.Write(p) }
+func (f *fakeAdapter) Read(p []byte) (int, error)  { return f.resp.Read(p) }
+
+func TestNewControllerInitSequence(t *testing.T) {
+	tests := []struct {
+		name  string
+		clear bool
+		opts  []ControllerOption
+		want  string
+	}{
+		{
+			"defaults",
+			false,
+			nil,
+			"++verbose 0\n++savecfg 0\n++addr 5\n++mode 1\n++auto 0\n++eoi 1\n++eos 0\n" +
+				"++read_tmo_ms 500\n++eot_char 10\n++eot_enable 1\n++savecfg 1\n",
+		},
+		{
+			"leave eeprom untouched with clear",
+			true,
+			[]ControllerOption{
+				WithSaveConfig(SaveConfigDisable),
+				WithReadTimeout(3000),
+				WithGPIBTermination(AppendLF),
+				WithReadAfterWrite(true),
+			},
+			"++verbose 0\n++savecfg 0\n++addr 5\n++mode 1\n++auto 1\n++eoi 1\n++eos 2\n" +
+				"++read_tmo_ms 3000\n++eot_char 10\n++eot_enable 1\n++clr\n",
+		},
+		{
+			"ar488",
+			false,
+			[]ControllerOption{WithAR488(), WithEOTChar('\r'), WithEOTEnable(false), WithAssertEOI(false)},
+			"++addr 5\n++mode 1\n++auto 0\n++eoi 0\n++eos 0\n" +
+				"++read_tmo_ms 500\n++eot_char 13\n++eot_enable 0\n",
+		},
+		{
+			"savecfg unchanged",
+			false,
+			[]ControllerOption{WithSaveConfig(SaveConfigUnchanged)},
+			"++verbose 0\n++addr 5\n++mode 1\n++auto 0\n++eoi 1\n++eos 0\n" +
+				"++read_tmo_ms 500\n++eot_char 10\n++eot_enable 1\n",
+		},
+		{"skip init", true, []ControllerOption{WithSkipInit()}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var f fakeAdapter
+			if _, err := NewController(&f, 5, test.clear, test.opts...); err != nil {
+				t.Fatalf("NewController error: %s", err)
+			}
+			if got := f.written.String(); got != test.want {
+				t.Errorf("init sequence\n\tgot %q\n\twant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewControllerInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		addr int
+		opts []ControllerOption
+	}{
+		{"primary address", 31, nil},
+		{"secondary address", 5, []ControllerOption{WithSecondaryAddress(95)}},
+		{"read timeout", 5, []ControllerOption{WithReadTimeout(3001)}},
+		{"gpib termination", 5, []ControllerOption{WithGPIBTermination(4)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var f fakeAdapter
+			if _, err := NewController(&f, test.addr, false, test.opts...); err == nil {
+				t.Errorf("expected error")
+			}
+			if f.written.Len() != 0 {
+				t.Errorf("sent %q before validating options", f.written.String())
+			}
+		})
+	}
+}
+
+// slowInstrument responds to a query only after being addressed to talk the
+// given number of times.
+type slowInstrument struct {
+	reads int
+}
+
+func (s *slowInstrument) Handle([]byte) string { return "" }
+
+func (s *slowInstrument) Talk() string {
+	s.reads--
+	if s.reads > 0 {
+		return ""
+	}
+	return "+1.234E+00\n"
+}
+
+func TestQueryWithTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		reads   int
+		auto    bool
+		timeout time.Duration
+		want    string
+		wantErr error
+	}{
+		{"immediate", 1, false, time.Second, "+1.234E+00\n", nil},
+		{"after several reads", 4, false, time.Second, "+1.234E+00\n", nil},
+		{"after several reads with auto", 4, true, time.Second, "+1.234E+00\n", nil},
+		{"timeout", 1 << 30, false, 20 * time.Millisecond, "", ErrTimeout},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			adapter := emulator.New()
+			adapter.Attach(3, &slowInstrument{reads: test.reads})
+			c, err := NewController(adapter, 3, false, WithReadAfterWrite(test.auto))
+			if err != nil {
+				t.Fatalf("NewController error: %s", err)
+			}
+			got, err := c.QueryWithTimeout("meas?", test.timeout)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("error\n\tgot %v; want %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("response\n\tgot %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// chunkedAdapter returns each of the given chunks from a separate Read and
+// then times out, like a transport delivering a response in several pieces.
+type chunkedAdapter struct {
+	written bytes.Buffer
+	chunks  []string
+}
+
+func (a *chunkedAdapter) Write(p []byte) (int, error) { return a.written.Write(p) }
+
+func (a *chunkedAdapter) Read(p []byte) (int, error) {
+	if len(a.chunks) == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n := copy(p, a.chunks[0])
+	a.chunks = a.chunks[1:]
+	return n, nil
+}
+
+func (a *chunkedAdapter) SetReadDeadline(time.Time) error { return nil }
+
+func TestQueryWithTimeoutSeparateEOT(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []ControllerOption
+		chunks []string
+		want   string
+	}{
+		{
+			"terminator and EOT in separate reads",
+			nil,
+			[]string{"+1.234E+00\n", "\n"},
+			"+1.234E+00\n",
+		},
+		{
+			"distinct EOT character",
+			[]ControllerOption{WithEOTChar(EOT)},
+			[]string{"+1.2", "34E+00\n", "\x04"},
+			"+1.234E+00\n",
+		},
+		{
+			"no terminator",
+			nil,
+			[]string{"+1.234E+00", "\n"},
+			"+1.234E+00",
+		},
+		{
+			"binary block containing the EOT character",
+			nil,
+			[]string{"#15a\n", "b\nc\n", "\n"},
+			"#15a\nb\nc\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &chunkedAdapter{chunks: test.chunks}
+			c, err := NewController(a, 3, false, append(test.opts, WithSkipInit())...)
+			if err != nil {
+				t.Fatalf("NewController error: %s", err)
+			}
+			got, err := c.QueryWithTimeout("meas?", time.Second)
+			if err != nil {
+				t.Fatalf("QueryWithTimeout error: %s", err)
+			}
+			if got != test.want {
+				t.Errorf("response\n\tgot %q; want %q", got, test.want)
+			}
+			if len(a.chunks) != 0 {
+				t.Errorf("unread data %q left for the next query", a.chunks)
+			}
+		})
+	}
+}
+
+func TestQueryWithTimeoutUnsupported(t *testing.T) {
+	var f fakeAdapter
+	c, err := NewController(&f, 3, false)
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	if _, err := c.QueryWithTimeout("meas?", time.Second); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v; want ErrUnsupported", err)
+	}
+}
+
+func TestWriteBinary(t *testing.T) {
+	var f fakeAdapter
+	c, err := NewController(&f, 3, false, WithSkipInit())
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	data := []byte("#15a\r\n\x1b+z")
+	n, err := c.WriteBinary(data)
+	if err != nil {
+		t.Fatalf("WriteBinary error: %s", err)
+	}
+	if n != len(data) {
+		t.Errorf("got %d bytes written; want %d", n, len(data))
+	}
+	want := "#15a\x1b\r\x1b\n\x1b\x1b\x1b+z\n"
+	if got := f.written.String(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package reconnect provides a transport for the Prologix controller that
+reopens the underlying serial port or network connection after an I/O error,
+such as when a GPIB-USB controller is unplugged and plugged back in or a
+GPIB-ETHERNET controller reboots.
+
+When used with prologix.NewController, the Controller reconnects the transport
+after an I/O error and replays its init sequence, including the address of the
+last addressed instrument. The operation that failed returns an error wrapping
+prologix.ErrReconnected, so that the caller can decide whether to retry it.
+*/
+package reconnect
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/driver/vcp"
+)
+
+// ErrDisconnected is returned by Read and Write while the transport is
+// disconnected.
+var ErrDisconnected = errors.New("reconnect: transport disconnected")
+
+// ErrClosed is returned after the Conn is closed, including by a Reconnect
+// that was waiting to retry when the Conn was closed.
+var ErrClosed = errors.New("reconnect: transport closed")
+
+// OpenFunc opens the underlying transport.
+type OpenFunc func() (io.ReadWriteCloser, error)
+
+// VCP returns an OpenFunc that opens the given serial port of a Prologix
+// GPIB-USB controller using the Virtual COM Port (VCP) driver with the given
+// options.
+func VCP(serialPort string, opts ...vcp.Option) OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		return vcp.NewVCP(serialPort, opts...)
+	}
+}
+
+// TCP returns an OpenFunc that connects to a Prologix GPIB-ETHERNET
+// controller at the given address, such as "192.168.1.20:1234".
+func TCP(address string, timeout time.Duration) OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		return net.DialTimeout("tcp", address, timeout)
+	}
+}
+
+// EventKind provides the type for the kinds of connection events.
+type EventKind int
+
+// Available connection events.
+const (
+	Disconnected EventKind = iota
+	Reconnecting
+	Reconnected
+	ReconnectFailed
+)
+
+var eventKindDesc = map[EventKind]string{
+	Disconnected:    "disconnected",
+	Reconnecting:    "reconnecting",
+	Reconnected:     "reconnected",
+	ReconnectFailed: "reconnect failed",
+}
+
+func (kind EventKind) String() string {
+	return eventKindDesc[kind]
+}
+
+// Event describes a change in the connection state.
+type Event struct {
+	Kind    EventKind
+	Attempt int   // Reconnect attempt, starting at 1.
+	Err     error // Error that caused the event, if any.
+}
+
+// Conn is a transport that can reopen the underlying transport after an I/O
+// error. It is safe for concurrent use.
+type Conn struct {
+	open         OpenFunc
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	maxElapsed   time.Duration
+	onEvent      func(Event)
+
+	reconnectMu sync.Mutex // Serializes Reconnect.
+	done        chan struct{}
+
+	mu       sync.Mutex
+	rwc      io.ReadWriteCloser
+	deadline time.Time
+	closed   bool
+}
+
+// Option applies an option to the Conn.
+type Option func(*Conn)
+
+// WithBackoff sets the delay before the first reconnect attempt, which is
+// doubled after each failed attempt up to the given maximum. The defaults are
+// 100 ms and 5 s.
+func WithBackoff(initial, maxDelay time.Duration) Option {
+	return func(c *Conn) {
+		c.initialDelay = initial
+		c.maxDelay = maxDelay
+	}
+}
+
+// WithMaxElapsed sets how long Reconnect keeps trying before giving up, which
+// defaults to 30 s so that a GPIB-ETHERNET controller has time to reboot.
+func WithMaxElapsed(d time.Duration) Option {
+	return func(c *Conn) { c.maxElapsed = d }
+}
+
+// WithEventHandler sets a function that is called for every connection
+// event. The handler must not call the methods of the Conn.
+func WithEventHandler(handler func(Event)) Option {
+	return func(c *Conn) { c.onEvent = handler }
+}
+
+// New opens the transport using the given OpenFunc and returns a Conn that
+// reopens it using the same OpenFunc after an I/O error.
+func New(open OpenFunc, opts ...Option) (*Conn, error) {
+	c := Conn{
+		open:         open,
+		initialDelay: 100 * time.Millisecond,
+		maxDelay:     5 * time.Second,
+		maxElapsed:   30 * time.Second,
+		onEvent:      func(Event) {},
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	rwc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	c.rwc = rwc
+	return &c, nil
+}
+
+// Write writes to the underlying transport. An error other than a timeout
+// disconnects the transport.
+func (c *Conn) Write(p []byte) (int, error) {
+	rwc, err := c.current()
+	if err != nil {
+		return 0, err
+	}
+	n, err := rwc.Write(p)
+	c.check(rwc, err)
+	return n, err
+}
+
+// Read reads from the underlying transport. An error other than a timeout
+// disconnects the transport.
+func (c *Conn) Read(p []byte) (int, error) {
+	rwc, err := c.current()
+	if err != nil {
+		return 0, err
+	}
+	n, err := rwc.Read(p)
+	c.check(rwc, err)
+	return n, err
+}
+
+// SetReadDeadline sets the read deadline of the underlying transport, which is
+// also applied after reconnecting. ErrUnsupported is returned if the
+// underlying transport doesn't support read deadlines.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	if c.rwc == nil {
+		return nil
+	}
+	return setReadDeadline(c.rwc, t)
+}
+
+// Flush flushes the underlying transport if supported.
+func (c *Conn) Flush() error {
+	rwc, err := c.current()
+	if err != nil {
+		return err
+	}
+	f, ok := rwc.(interface{ Flush() error })
+	if !ok {
+		return prologix.ErrUnsupported
+	}
+	return f.Flush()
+}
+
+// Connected reports whether the underlying transport is open.
+func (c *Conn) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rwc != nil
+}
+
+// Reconnect closes the underlying transport, if still open, and reopens it,
+// waiting with an exponential backoff between attempts. The Conn isn't locked
+// while waiting, so Close can stop the attempts.
+func (c *Conn) Reconnect() error {
+	c.reconnectMu.Lock()
+	defer c.reconnectMu.Unlock()
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	if c.rwc != nil {
+		c.rwc.Close()
+		c.rwc = nil
+	}
+	c.mu.Unlock()
+
+	start := time.Now()
+	delay := c.initialDelay
+	for attempt := 1; ; attempt++ {
+		c.onEvent(Event{Kind: Reconnecting, Attempt: attempt})
+		rwc, err := c.open()
+		if err == nil {
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				rwc.Close()
+				return ErrClosed
+			}
+			if !c.deadline.IsZero() {
+				setReadDeadline(rwc, c.deadline)
+			}
+			c.rwc = rwc
+			c.mu.Unlock()
+			c.onEvent(Event{Kind: Reconnected, Attempt: attempt})
+			return nil
+		}
+		if time.Since(start)+delay > c.maxElapsed {
+			c.onEvent(Event{Kind: ReconnectFailed, Attempt: attempt, Err: err})
+			return fmt.Errorf("reconnect failed after %d attempts: %w", attempt, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-c.done:
+			timer.Stop()
+			return ErrClosed
+		}
+		delay = min(2*delay, c.maxDelay)
+	}
+}
+
+// Close closes the underlying transport and stops any reconnect attempts.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	if c.rwc == nil {
+		return nil
+	}
+	err := c.rwc.Close()
+	c.rwc = nil
+	return err
+}
+
+// current returns the underlying transport or ErrDisconnected.
+func (c *Conn) current() (io.ReadWriteCloser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if c.rwc == nil {
+		return nil, ErrDisconnected
+	}
+	return c.rwc, nil
+}
+
+// check closes the given transport if err indicates that the connection was
+// lost. Timeouts don't indicate a lost connection.
+func (c *Conn) check(rwc io.ReadWriteCloser, err error) {
+	if err == nil || isTimeout(err) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rwc != rwc {
+		return // already reconnected
+	}
+	c.rwc.Close()
+	c.rwc = nil
+	c.onEvent(Event{Kind: Disconnected, Err: err})
+}
+
+// isTimeout reports whether err is a read timeout.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, prologix.ErrTimeout) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// setReadDeadline sets the read deadline of rw if supported.
+func setReadDeadline(rw io.ReadWriter, t time.Time) error {
+	ds, ok := rw.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return prologix.ErrUnsupported
+	}
+	return ds.SetReadDeadline(t)
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package reconnect
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/emulator"
+)
+
+// unpluggable wraps an emulated Prologix controller that can be unplugged.
+type unpluggable struct {
+	*emulator.Adapter
+	unplugged bool
+}
+
+func (u *unpluggable) Write(p []byte) (int, error) {
+	if u.unplugged {
+		return 0, syscall.EIO
+	}
+	return u.Adapter.Write(p)
+}
+
+func (u *unpluggable) Close() error { return nil }
+
+func TestControllerReconnects(t *testing.T) {
+	var adapters []*unpluggable
+	failOpens := 0
+	open := func() (io.ReadWriteCloser, error) {
+		if failOpens > 0 {
+			failOpens--
+			return nil, syscall.ENOENT
+		}
+		u := &unpluggable{Adapter: emulator.New()}
+		u.Attach(7, emulator.InstrumentFunc(func(msg string) string {
+			if msg == "*IDN?" {
+				return "ACME,1,2,3\n"
+			}
+			return ""
+		}))
+		adapters = append(adapters, u)
+		return u, nil
+	}
+	var events []EventKind
+	conn, err := New(
+		open,
+		WithBackoff(time.Millisecond, 2*time.Millisecond),
+		WithEventHandler(func(e Event) { events = append(events, e.Kind) }),
+	)
+	if err != nil {
+		t.Fatalf("New error: %s", err)
+	}
+	c, err := prologix.NewController(conn, 7, false, prologix.WithSaveConfig(prologix.SaveConfigDisable))
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+
+	// Unplug the adapter and fail the first two attempts to reopen it.
+	adapters[0].unplugged = true
+	failOpens = 2
+	_, err = c.Query("*IDN?")
+	if !errors.Is(err, prologix.ErrReconnected) {
+		t.Fatalf("got %v; want ErrReconnected", err)
+	}
+	if !errors.Is(err, syscall.EIO) {
+		t.Errorf("got %v; want the original error to be wrapped", err)
+	}
+	want := []EventKind{Disconnected, Reconnecting, Reconnecting, Reconnecting, Reconnected}
+	if len(events) != len(want) {
+		t.Fatalf("events\n\tgot %v\n\twant %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("events\n\tgot %v\n\twant %v", events, want)
+			break
+		}
+	}
+
+	// The new adapter must be configured and address the same instrument.
+	if len(adapters) != 2 {
+		t.Fatalf("got %d adapters; want 2", len(adapters))
+	}
+	if got := adapters[1].Setting("addr"); got != "7" {
+		t.Errorf("addr got %s; want 7", got)
+	}
+	if got := adapters[1].Setting("eot_enable"); got != "1" {
+		t.Errorf("eot_enable got %s; want 1", got)
+	}
+	idn, err := c.Query("*IDN?")
+	if err != nil {
+		t.Fatalf("Query error after reconnect: %s", err)
+	}
+	if idn != "ACME,1,2,3\n" {
+		t.Errorf("got %q; want %q", idn, "ACME,1,2,3\n")
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	opened := false
+	open := func() (io.ReadWriteCloser, error) {
+		if opened {
+			return nil, syscall.ENOENT
+		}
+		opened = true
+		return &unpluggable{Adapter: emulator.New()}, nil
+	}
+	var last Event
+	conn, err := New(
+		open,
+		WithBackoff(time.Millisecond, time.Millisecond),
+		WithMaxElapsed(10*time.Millisecond),
+		WithEventHandler(func(e Event) { last = e }),
+	)
+	if err != nil {
+		t.Fatalf("New error: %s", err)
+	}
+	if err := conn.Reconnect(); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("got %v; want ENOENT", err)
+	}
+	if last.Kind != ReconnectFailed {
+		t.Errorf("last event got %s; want %s", last.Kind, ReconnectFailed)
+	}
+	if _, err := conn.Write([]byte("++ver\n")); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("got %v; want ErrDisconnected", err)
+	}
+}
+
+func TestReconnectKeepsEEPROM(t *testing.T) {
+	var adapters []*unpluggable
+	open := func() (io.ReadWriteCloser, error) {
+		u := &unpluggable{Adapter: emulator.New()}
+		adapters = append(adapters, u)
+		return u, nil
+	}
+	conn, err := New(open, WithBackoff(time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatalf("New error: %s", err)
+	}
+	// The default SaveConfigEnable policy saves the init sequence once, but the
+	// replay after reconnecting must not enable savecfg again.
+	c, err := prologix.NewController(conn, 7, false)
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	adapters[0].unplugged = true
+	if err := c.Command("*RST"); !errors.Is(err, prologix.ErrReconnected) {
+		t.Fatalf("got %v; want ErrReconnected", err)
+	}
+	if got := adapters[1].Setting("savecfg"); got != "0" {
+		t.Errorf("savecfg after reconnect got %s; want 0", got)
+	}
+}
+
+func TestCloseStopsReconnect(t *testing.T) {
+	opened := false
+	open := func() (io.ReadWriteCloser, error) {
+		if opened {
+			return nil, syscall.ENOENT
+		}
+		opened = true
+		return &unpluggable{Adapter: emulator.New()}, nil
+	}
+	conn, err := New(open, WithBackoff(time.Hour, time.Hour), WithMaxElapsed(24*time.Hour))
+	if err != nil {
+		t.Fatalf("New error: %s", err)
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- conn.Reconnect() }()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- conn.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked by Reconnect waiting to retry")
+	}
+	if err := <-errs; !errors.Is(err, ErrClosed) {
+		t.Errorf("Reconnect error = %v; want ErrClosed", err)
+	}
+}
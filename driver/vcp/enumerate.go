@@ -0,0 +1,119 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package vcp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.bug.st/serial/enumerator"
+)
+
+// ErrAdapterNotFound is returned by FindPort when no adapter with the given
+// serial number is connected.
+var ErrAdapterNotFound = errors.New("vcp: adapter not found")
+
+// AdapterKind provides the type for the kinds of GPIB-USB adapters.
+type AdapterKind int
+
+// Available kinds of GPIB-USB adapters.
+const (
+	Prologix AdapterKind = iota
+	AR488
+)
+
+var adapterKindDesc = map[AdapterKind]string{
+	Prologix: "Prologix GPIB-USB",
+	AR488:    "AR488",
+}
+
+func (kind AdapterKind) String() string {
+	return adapterKindDesc[kind]
+}
+
+// Adapter describes a serial port that is likely a Prologix GPIB-USB
+// controller or an Arduino-based AR488.
+type Adapter struct {
+	Port         string // Serial port name, such as /dev/ttyUSB0 or COM3.
+	VID          string // USB vendor ID in uppercase hex.
+	PID          string // USB product ID in uppercase hex.
+	SerialNumber string // USB serial number, such as PXFJL0WD.
+	Product      string // OS-dependent product description, if available.
+	Kind         AdapterKind
+}
+
+type usbID struct {
+	vid, pid string
+}
+
+// knownAdapters maps the USB VID/PID of the FTDI chip used by the Prologix
+// GPIB-USB controller and of the boards commonly used to build an AR488.
+var knownAdapters = map[usbID]AdapterKind{
+	{"0403", "6001"}: Prologix, // FTDI FT245R/FT232R
+	{"2341", "0001"}: AR488,    // Arduino Uno
+	{"2341", "0043"}: AR488,    // Arduino Uno R3
+	{"2341", "0010"}: AR488,    // Arduino Mega 2560
+	{"2341", "0042"}: AR488,    // Arduino Mega 2560 R3
+	{"2341", "0036"}: AR488,    // Arduino Leonardo bootloader
+	{"2341", "8036"}: AR488,    // Arduino Leonardo
+	{"2341", "8037"}: AR488,    // Arduino Micro
+	{"2A03", "0043"}: AR488,    // Arduino.org Uno R3
+	{"1A86", "7523"}: AR488,    // CH340 based Nano and Uno clones
+	{"10C4", "EA60"}: AR488,    // Silicon Labs CP210x
+}
+
+// ListAdapters returns the serial ports whose USB VID/PID match the FTDI chip
+// of the Prologix GPIB-USB controller or one of the boards commonly used for
+// the AR488. Note that other FTDI based serial cables are also returned as
+// Prologix adapters.
+func ListAdapters() ([]Adapter, error) {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return nil, err
+	}
+	return filterAdapters(ports), nil
+}
+
+// FindPort returns the serial port name of the connected adapter with the
+// given USB serial number, which, unlike the serial port name, doesn't change
+// between machines or when the adapter is plugged into a different USB port.
+func FindPort(serialNumber string) (string, error) {
+	adapters, err := ListAdapters()
+	if err != nil {
+		return "", err
+	}
+	for _, adapter := range adapters {
+		if strings.EqualFold(adapter.SerialNumber, serialNumber) {
+			return adapter.Port, nil
+		}
+	}
+	return "", fmt.Errorf("%w: serial number %s", ErrAdapterNotFound, serialNumber)
+}
+
+// filterAdapters returns the USB serial ports that match a known adapter.
+func filterAdapters(ports []*enumerator.PortDetails) []Adapter {
+	var adapters []Adapter
+	for _, port := range ports {
+		if !port.IsUSB {
+			continue
+		}
+		id := usbID{strings.ToUpper(port.VID), strings.ToUpper(port.PID)}
+		kind, ok := knownAdapters[id]
+		if !ok {
+			continue
+		}
+		adapters = append(adapters, Adapter{
+			Port:         port.Name,
+			VID:          id.vid,
+			PID:          id.pid,
+			SerialNumber: port.SerialNumber,
+			Product:      port.Product,
+			Kind:         kind,
+		})
+	}
+	return adapters
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package vcp
+
+import (
+	"testing"
+
+	"go.bug.st/serial/enumerator"
+)
+
+func TestFilterAdapters(t *testing.T) {
+	ports := []*enumerator.PortDetails{
+		{Name: "/dev/ttyS0"},
+		{Name: "/dev/ttyUSB0", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "PXFJL0WD", Product: "Prologix GPIB-USB Controller"},
+		{Name: "/dev/ttyACM0", IsUSB: true, VID: "2341", PID: "0043", SerialNumber: "85736323"},
+		{Name: "/dev/ttyUSB1", IsUSB: true, VID: "1a86", PID: "7523"},
+		{Name: "/dev/ttyUSB2", IsUSB: true, VID: "067B", PID: "2303"},
+	}
+	want := []Adapter{
+		{"/dev/ttyUSB0", "0403", "6001", "PXFJL0WD", "Prologix GPIB-USB Controller", Prologix},
+		{"/dev/ttyACM0", "2341", "0043", "85736323", "", AR488},
+		{"/dev/ttyUSB1", "1A86", "7523", "", "", AR488},
+	}
+	got := filterAdapters(ports)
+	if len(got) != len(want) {
+		t.Fatalf("got %d adapters; want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("adapter %d\n\tgot  %+v\n\twant %+v", i, got[i], want[i])
+		}
+	}
+}
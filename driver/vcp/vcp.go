@@ -6,45 +6,197 @@
 package vcp
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"strings"
+	"time"
 
+	"github.com/gotmc/prologix"
 	"go.bug.st/serial"
 )
 
 // VCP models a Prologix GPIB-USB controller communicating using a Virtual COM
 // Port (VCP).
 type VCP struct {
-	port serial.Port
+	port        serial.Port
+	readTimeout time.Duration
 }
 
-// NewVCP creates a new Virtual COM Port (VCP).
-func NewVCP(serialPort string) (*VCP, error) {
-	mode := &serial.Mode{
-		BaudRate: 115200,
-		Parity:   serial.EvenParity,
-		DataBits: 7,
-		StopBits: serial.OneStopBit,
+// config holds the serial port configuration used when opening the VCP.
+type config struct {
+	mode         serial.Mode
+	readTimeout  time.Duration
+	startupDelay time.Duration
+	probeTimeout time.Duration
+}
+
+// openPort opens the serial port, which is replaced by a fake serial.Port in
+// the tests.
+var openPort = serial.Open
+
+// Option applies an option to the VCP.
+type Option func(*config)
+
+// WithMode sets the serial port mode, which defaults to 115200 baud, 7 data
+// bits, even parity, and one stop bit. The InitialStatusBits of the mode are
+// kept if already set using WithDTR or WithRTS.
+func WithMode(mode serial.Mode) Option {
+	return func(cfg *config) {
+		bits := cfg.mode.InitialStatusBits
+		cfg.mode = mode
+		if mode.InitialStatusBits == nil {
+			cfg.mode.InitialStatusBits = bits
+		}
 	}
-	port, err := serial.Open(serialPort, mode)
+}
+
+// WithBaudRate sets the baud rate of the serial port, which defaults to 115200
+// baud. The Prologix GPIB-USB controller ignores the baud rate, but the AR488
+// must match the baud rate it was built with.
+func WithBaudRate(baud int) Option {
+	return func(cfg *config) { cfg.mode.BaudRate = baud }
+}
+
+// WithReadTimeout sets the read timeout of the serial port. When the timeout
+// expires before any data is received, Read returns prologix.ErrTimeout. By
+// default Read blocks until data is received.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(cfg *config) { cfg.readTimeout = timeout }
+}
+
+// WithDTR sets the state of the Data Terminal Ready (DTR) signal when the
+// serial port is opened. Disable DTR to avoid resetting an Arduino-based
+// AR488 on open. Note that on Linux and macOS the signal may still pulse
+// briefly when the port is opened.
+func WithDTR(enable bool) Option {
+	return func(cfg *config) { cfg.statusBits().DTR = enable }
+}
+
+// WithRTS sets the state of the Request To Send (RTS) signal when the serial
+// port is opened.
+func WithRTS(enable bool) Option {
+	return func(cfg *config) { cfg.statusBits().RTS = enable }
+}
+
+// WithStartupDelay waits the given duration after opening the serial port,
+// for instance to let an Arduino-based AR488 finish booting after being reset
+// by the port opening.
+func WithStartupDelay(delay time.Duration) Option {
+	return func(cfg *config) { cfg.startupDelay = delay }
+}
+
+// WithReadyProbe repeatedly sends the `++ver` command after opening the serial
+// port until the adapter responds or the given timeout expires, and then
+// discards the response. NewVCP returns prologix.ErrTimeout if the adapter
+// doesn't respond in time.
+func WithReadyProbe(timeout time.Duration) Option {
+	return func(cfg *config) { cfg.probeTimeout = timeout }
+}
+
+// statusBits returns the initial modem status bits, which default to DTR and
+// RTS enabled.
+func (cfg *config) statusBits() *serial.ModemOutputBits {
+	if cfg.mode.InitialStatusBits == nil {
+		cfg.mode.InitialStatusBits = &serial.ModemOutputBits{DTR: true, RTS: true}
+	}
+	return cfg.mode.InitialStatusBits
+}
+
+// NewVCP creates a new Virtual COM Port (VCP). Optionally the serial port
+// configuration can be changed using an Option.
+func NewVCP(serialPort string, opts ...Option) (*VCP, error) {
+	cfg := config{
+		mode: serial.Mode{
+			BaudRate: 115200,
+			Parity:   serial.EvenParity,
+			DataBits: 7,
+			StopBits: serial.OneStopBit,
+		},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	port, err := openPort(serialPort, &cfg.mode)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("opening serial port %s: %w", serialPort, err)
 	}
 
 	vcp := VCP{
-		port: port,
+		port:        port,
+		readTimeout: serial.NoTimeout,
+	}
+	if cfg.startupDelay > 0 {
+		time.Sleep(cfg.startupDelay)
+	}
+	if cfg.probeTimeout > 0 {
+		if err := vcp.probe(cfg.probeTimeout); err != nil {
+			port.Close()
+			return nil, fmt.Errorf("probing serial port %s: %w", serialPort, err)
+		}
+	}
+	if cfg.readTimeout > 0 {
+		vcp.readTimeout = cfg.readTimeout
+		if err := port.SetReadTimeout(cfg.readTimeout); err != nil {
+			port.Close()
+			return nil, err
+		}
 	}
 	return &vcp, nil
 }
 
+// probe sends the `++ver` command until the adapter responds or the timeout
+// expires, then discards the response.
+func (vcp *VCP) probe(timeout time.Duration) error {
+	const interval = 250 * time.Millisecond
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 128)
+	for time.Now().Before(deadline) {
+		if _, err := vcp.WriteString("++ver"); err != nil {
+			return err
+		}
+		if err := vcp.port.SetReadTimeout(interval); err != nil {
+			return err
+		}
+		n, err := vcp.port.Read(buf)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			// Wait for the rest of the response before discarding it.
+			time.Sleep(interval)
+			return errors.Join(vcp.Flush(), vcp.port.SetReadTimeout(serial.NoTimeout))
+		}
+	}
+	return fmt.Errorf("%w: no response to ++ver within %s", prologix.ErrTimeout, timeout)
+}
+
 // Write writes the given data to the serial port.
 func (vcp *VCP) Write(p []byte) (n int, err error) {
 	return vcp.port.Write(p)
 }
 
-// Read reads from the serial port into the given byte slice.
+// Read reads from the serial port into the given byte slice. If the serial
+// port read timeout expires before any data is received, prologix.ErrTimeout
+// is returned.
 func (vcp *VCP) Read(p []byte) (n int, err error) {
-	return vcp.port.Read(p)
+	n, err = vcp.port.Read(p)
+	if n == 0 && err == nil && len(p) > 0 {
+		return 0, prologix.ErrTimeout
+	}
+	return n, err
+}
+
+// SetReadDeadline sets the deadline for future Read calls using the read
+// timeout of the serial port. A zero value for t restores the read timeout set
+// using WithReadTimeout.
+func (vcp *VCP) SetReadDeadline(t time.Time) error {
+	if t.IsZero() {
+		return vcp.port.SetReadTimeout(vcp.readTimeout)
+	}
+	// The serial port timeout must be positive, so an expired deadline reads
+	// for as short as possible instead.
+	return vcp.port.SetReadTimeout(max(time.Until(t), time.Millisecond))
 }
 
 // Close closes the underlying serial port.
@@ -52,6 +204,8 @@ func (vcp *VCP) Close() error {
 	return vcp.port.Close()
 }
 
+// Flush discards both the unread data in the input buffer and the unsent data
+// in the output buffer of the serial port.
 func (vcp *VCP) Flush() error {
 	err := vcp.port.ResetInputBuffer()
 	if err != nil {
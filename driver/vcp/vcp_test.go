@@ -0,0 +1,218 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package vcp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"go.bug.st/serial"
+)
+
+// fakePort is a serial.Port that records the configuration and the data
+// written, and answers `++ver` after the given number of writes.
+type fakePort struct {
+	mode         serial.Mode
+	timeouts     []time.Duration
+	written      bytes.Buffer
+	resp         bytes.Buffer
+	answerAfter  int // number of `++ver` writes before answering, or 0 to never answer
+	writes       int
+	inputResets  int
+	outputResets int
+	closed       bool
+}
+
+func (p *fakePort) SetMode(mode *serial.Mode) error { p.mode = *mode; return nil }
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	// Like go.bug.st/serial, return no data and no error on timeout.
+	if p.resp.Len() == 0 {
+		if n := len(p.timeouts); n > 0 && p.timeouts[n-1] > 0 {
+			time.Sleep(p.timeouts[n-1])
+		}
+		return 0, nil
+	}
+	return p.resp.Read(b)
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	p.writes++
+	if p.answerAfter > 0 && p.writes == p.answerAfter && strings.HasPrefix(string(b), "++ver") {
+		p.resp.WriteString("Prologix GPIB-USB Controller version 6.0\r\n")
+	}
+	return p.written.Write(b)
+}
+
+func (p *fakePort) Drain() error             { return nil }
+func (p *fakePort) ResetInputBuffer() error  { p.inputResets++; p.resp.Reset(); return nil }
+func (p *fakePort) ResetOutputBuffer() error { p.outputResets++; return nil }
+func (p *fakePort) SetDTR(bool) error        { return nil }
+func (p *fakePort) SetRTS(bool) error        { return nil }
+func (p *fakePort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
+func (p *fakePort) Break(time.Duration) error { return nil }
+func (p *fakePort) Close() error              { p.closed = true; return nil }
+
+func (p *fakePort) SetReadTimeout(t time.Duration) error {
+	p.timeouts = append(p.timeouts, t)
+	return nil
+}
+
+// useFakePort makes NewVCP open the given fakePort for the duration of the
+// test.
+func useFakePort(t *testing.T, p *fakePort) {
+	t.Helper()
+	orig := openPort
+	openPort = func(_ string, mode *serial.Mode) (serial.Port, error) {
+		p.mode = *mode
+		return p, nil
+	}
+	t.Cleanup(func() { openPort = orig })
+}
+
+func TestOptions(t *testing.T) {
+	bits := func(dtr, rts bool) *serial.ModemOutputBits {
+		return &serial.ModemOutputBits{DTR: dtr, RTS: rts}
+	}
+	tests := []struct {
+		name string
+		opts []Option
+		want serial.Mode
+	}{
+		{
+			"defaults",
+			nil,
+			serial.Mode{BaudRate: 115200, DataBits: 7, Parity: serial.EvenParity, StopBits: serial.OneStopBit},
+		},
+		{
+			"baud rate",
+			[]Option{WithBaudRate(9600)},
+			serial.Mode{BaudRate: 9600, DataBits: 7, Parity: serial.EvenParity, StopBits: serial.OneStopBit},
+		},
+		{
+			"dtr and rts",
+			[]Option{WithDTR(false), WithRTS(true)},
+			serial.Mode{BaudRate: 115200, DataBits: 7, Parity: serial.EvenParity, StopBits: serial.OneStopBit,
+				InitialStatusBits: bits(false, true)},
+		},
+		{
+			"mode keeps status bits",
+			[]Option{WithDTR(false), WithMode(serial.Mode{BaudRate: 57600, DataBits: 8})},
+			serial.Mode{BaudRate: 57600, DataBits: 8, InitialStatusBits: bits(false, true)},
+		},
+		{
+			"mode replaces status bits",
+			[]Option{WithDTR(false), WithMode(serial.Mode{BaudRate: 57600, DataBits: 8, InitialStatusBits: bits(true, false)})},
+			serial.Mode{BaudRate: 57600, DataBits: 8, InitialStatusBits: bits(true, false)},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &fakePort{}
+			useFakePort(t, p)
+			if _, err := NewVCP("/dev/ttyUSB0", tc.opts...); err != nil {
+				t.Fatalf("NewVCP error: %s", err)
+			}
+			got := p.mode
+			if got.BaudRate != tc.want.BaudRate || got.DataBits != tc.want.DataBits ||
+				got.Parity != tc.want.Parity || got.StopBits != tc.want.StopBits {
+				t.Errorf("mode\n\tgot  %+v\n\twant %+v", got, tc.want)
+			}
+			switch {
+			case tc.want.InitialStatusBits == nil:
+				if got.InitialStatusBits != nil {
+					t.Errorf("status bits got %+v; want nil", *got.InitialStatusBits)
+				}
+			case got.InitialStatusBits == nil || *got.InitialStatusBits != *tc.want.InitialStatusBits:
+				t.Errorf("status bits got %+v; want %+v", got.InitialStatusBits, *tc.want.InitialStatusBits)
+			}
+		})
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	p := &fakePort{}
+	useFakePort(t, p)
+	vcp, err := NewVCP("/dev/ttyUSB0", WithReadTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewVCP error: %s", err)
+	}
+	if _, err := vcp.Read(make([]byte, 8)); !errors.Is(err, prologix.ErrTimeout) {
+		t.Errorf("Read error = %v; want ErrTimeout", err)
+	}
+
+	// A deadline sets the serial port timeout, and the zero deadline restores
+	// the read timeout.
+	if err := vcp.SetReadDeadline(time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline error: %s", err)
+	}
+	if err := vcp.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatalf("SetReadDeadline error: %s", err)
+	}
+	want := []time.Duration{100 * time.Millisecond, time.Millisecond, 100 * time.Millisecond}
+	if len(p.timeouts) != len(want) {
+		t.Fatalf("timeouts got %v; want %v", p.timeouts, want)
+	}
+	for i := range want {
+		if p.timeouts[i] != want[i] {
+			t.Errorf("timeouts got %v; want %v", p.timeouts, want)
+			break
+		}
+	}
+}
+
+func TestStartupDelay(t *testing.T) {
+	useFakePort(t, &fakePort{})
+	start := time.Now()
+	if _, err := NewVCP("/dev/ttyUSB0", WithStartupDelay(20*time.Millisecond)); err != nil {
+		t.Fatalf("NewVCP error: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("NewVCP returned after %s; want at least 20ms", elapsed)
+	}
+}
+
+func TestReadyProbe(t *testing.T) {
+	tests := []struct {
+		name        string
+		answerAfter int
+		wantErr     error
+	}{
+		{"answers", 2, nil},
+		{"silent", 0, prologix.ErrTimeout},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &fakePort{answerAfter: tc.answerAfter}
+			useFakePort(t, p)
+			_, err := NewVCP("/dev/ttyUSB0", WithReadyProbe(600*time.Millisecond))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("NewVCP error = %v; want %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil {
+				if !p.closed {
+					t.Error("port not closed after the probe failed")
+				}
+				return
+			}
+			if p.writes != tc.answerAfter {
+				t.Errorf("sent ++ver %d times; want %d", p.writes, tc.answerAfter)
+			}
+			if p.inputResets == 0 || p.resp.Len() != 0 {
+				t.Error("probe response not discarded")
+			}
+			if last := p.timeouts[len(p.timeouts)-1]; last != serial.NoTimeout {
+				t.Errorf("read timeout after probe got %s; want none", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// Sentinel errors returned by the Controller and the drivers. Use errors.Is to
+// test for them, since they are usually wrapped with additional context.
+var (
+	// ErrTimeout indicates that the Prologix controller or the instrument did
+	// not respond before the read timeout expired.
+	ErrTimeout = errors.New("prologix: timeout")
+
+	// ErrInvalidAddress indicates a GPIB primary or secondary address outside
+	// of the range allowed by IEEE 488.
+	ErrInvalidAddress = errors.New("prologix: invalid GPIB address")
+
+	// ErrStateMismatch indicates that a setting reported by the Prologix
+	// controller differs from the value cached in the Controller. Use
+	// errors.As with a *StateMismatchError to get the setting and values.
+	ErrStateMismatch = errors.New("prologix: internal state mismatch")
+
+	// ErrUnexpectedResponse indicates that a response could not be parsed. Use
+	// errors.As with a *UnexpectedResponseError to get the raw response.
+	ErrUnexpectedResponse = errors.New("prologix: unexpected response")
+
+	// ErrReconnected indicates that the operation failed because the
+	// connection to the Prologix controller was lost, but the transport has
+	// since been reconnected and the Prologix controller reconfigured. The
+	// operation may be retried.
+	ErrReconnected = errors.New("prologix: reconnected after I/O error")
+
+	// ErrUnsupported indicates that the operation is not supported by the
+	// Prologix controller or the underlying transport.
+	ErrUnsupported = errors.New("prologix: unsupported operation")
+)
+
+// StateMismatchError records a difference between the value of a setting
+// reported by the Prologix controller and the value cached in the Controller.
+// The cached value is updated to the actual value when the mismatch is
+// detected.
+type StateMismatchError struct {
+	Setting  string
+	Expected any
+	Actual   any
+}
+
+func (e *StateMismatchError) Error() string {
+	return fmt.Sprintf(
+		"internal state mismatch, %s was %v now %v",
+		e.Setting, e.Expected, e.Actual,
+	)
+}
+
+// Is reports whether target is ErrStateMismatch.
+func (e *StateMismatchError) Is(target error) bool {
+	return target == ErrStateMismatch
+}
+
+// UnexpectedResponseError records a response from the Prologix controller or
+// instrument that could not be interpreted.
+type UnexpectedResponseError struct {
+	Command string // Command that produced the response.
+	Raw     []byte // Raw response including any terminators.
+	Err     error  // Underlying parse error, if any.
+}
+
+func (e *UnexpectedResponseError) Error() string {
+	msg := fmt.Sprintf("unexpected response %q to %s", e.Raw, e.Command)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is reports whether target is ErrUnexpectedResponse.
+func (e *UnexpectedResponseError) Is(target error) bool {
+	return target == ErrUnexpectedResponse
+}
+
+// Unwrap returns the underlying parse error.
+func (e *UnexpectedResponseError) Unwrap() error {
+	return e.Err
+}
+
+// unexpectedResponse creates an UnexpectedResponseError for the given command
+// and raw response string.
+func unexpectedResponse(cmd, raw string, err error) error {
+	return &UnexpectedResponseError{Command: cmd, Raw: []byte(raw), Err: err}
+}
+
+// wrapReadError converts the timeout errors reported by the various
+// transports into ErrTimeout so callers only need to check for one error.
+func wrapReadError(err error) error {
+	if err == nil || errors.Is(err, ErrTimeout) {
+		return err
+	}
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
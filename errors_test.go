@@ -0,0 +1,58 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestErrorsIs(t *testing.T) {
+	tests := []struct {
+		name   string
+		given  error
+		target error
+		want   bool
+	}{
+		{"state mismatch", &StateMismatchError{"eoi", true, false}, ErrStateMismatch, true},
+		{"wrapped state mismatch", fmt.Errorf("ctx: %w", &StateMismatchError{}), ErrStateMismatch, true},
+		{"state mismatch not timeout", &StateMismatchError{}, ErrTimeout, false},
+		{"unexpected response", unexpectedResponse("++eoi", "x", nil), ErrUnexpectedResponse, true},
+		{"unexpected response unwraps", unexpectedResponse("++eos", "x", io.ErrUnexpectedEOF), io.ErrUnexpectedEOF, true},
+		{"deadline is timeout", wrapReadError(os.ErrDeadlineExceeded), ErrTimeout, true},
+		{"deadline keeps cause", wrapReadError(os.ErrDeadlineExceeded), os.ErrDeadlineExceeded, true},
+		{"eof is not timeout", wrapReadError(io.EOF), ErrTimeout, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := errors.Is(test.given, test.target); got != test.want {
+				t.Errorf("errors.Is(%v, %v)\n\tgot %t; want %t", test.given, test.target, got, test.want)
+			}
+		})
+	}
+}
+
+func TestErrorsAs(t *testing.T) {
+	err := fmt.Errorf("ctx: %w", &StateMismatchError{Setting: "auto", Expected: false, Actual: true})
+	var mismatch *StateMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("errors.As failed for %v", err)
+	}
+	if mismatch.Setting != "auto" || mismatch.Expected != false || mismatch.Actual != true {
+		t.Errorf("got %+v; want auto false->true", mismatch)
+	}
+	err = unexpectedResponse("++srq", "?\n", nil)
+	var unexpected *UnexpectedResponseError
+	if !errors.As(err, &unexpected) {
+		t.Fatalf("errors.As failed for %v", err)
+	}
+	if string(unexpected.Raw) != "?\n" {
+		t.Errorf("got raw %q; want %q", unexpected.Raw, "?\n")
+	}
+}
@@ -13,20 +13,29 @@ import (
 
 	"github.com/gotmc/prologix"
 	"github.com/gotmc/prologix/driver/vcp"
+	"github.com/gotmc/prologix/instrument/ds345"
 )
 
 var (
-	serialPort  string
-	gpibAddress int
+	serialPort   string
+	serialNumber string
+	gpibAddress  int
 )
 
 func init() {
-	// Get Virtual COM Port (VCP) serial port for Prologix.
+	// Get Virtual COM Port (VCP) serial port for Prologix either directly or
+	// using the USB serial number of the Prologix.
 	flag.StringVar(
 		&serialPort,
 		"port",
-		"/dev/tty.usbserial-PX8X3YR6",
-		"Serial port for Prologix VCP GPIB controller",
+		"",
+		"Serial port for Prologix VCP GPIB controller (overrides -serial)",
+	)
+	flag.StringVar(
+		&serialNumber,
+		"serial",
+		"PX8X3YR6",
+		"USB serial number of the Prologix VCP GPIB controller",
 	)
 
 	flag.IntVar(&gpibAddress, "gpib", 6, "GPIB address for the SRS DS345")
@@ -36,6 +45,15 @@ func main() {
 	// Parse the flags
 	flag.Parse()
 
+	// Find the serial port using the USB serial number if not given.
+	if serialPort == "" {
+		port, err := vcp.FindPort(serialNumber)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serialPort = port
+	}
+
 	// Open virtual comm port.
 	log.Printf("Serial port = %s", serialPort)
 	vcp, err := vcp.NewVCP(serialPort)
@@ -44,8 +62,14 @@ func main() {
 	}
 
 	// Create a new GPIB controller using the aforementioned serial port and
-	// communicating with the instrument at the given address.
-	gpib, err := prologix.NewController(vcp, gpibAddress, false)
+	// communicating with the instrument at the given address. The DS345 drops
+	// input if commands arrive too quickly, so pace the commands.
+	gpib, err := prologix.NewController(
+		vcp,
+		gpibAddress,
+		false,
+		prologix.WithAddressPacing(gpibAddress, prologix.Pacing{MinGap: 250 * time.Millisecond}),
+	)
 	if err != nil {
 		log.Fatalf("NewController error: %s", err)
 	}
@@ -99,28 +123,13 @@ func main() {
 	}
 	log.Printf("query idn = %s", idn)
 
-	// Send commands to the function generator required to create a coded carrier
-	// operating at 100 Hz with 400 ms on time and 200 ms off time.
-	cmds := []string{
-		"MENA0",      // Disable modulation
-		"FUNC 0",     // Set output function to sine wave
-		"FREQ 100.0", // Set frequency to 100 Hz
-		"AMPL 0.5VP", // Set the amplitude to 0.5 Vpp
-		"OFFS 0.0",   // Set the offset to 0 Vdc
-		"PHSE 0.0",   // Set the phase to 0 degrees
-		"BCNT 40",    // Set burst count to 40 (rounded to nearest integer)
-		"TSRC1",      // Use internal trigger
-		"TRAT 1.667", // Set the trigger rate to 1.667 Hz
-		"MTYP5",      // Set the modulation to burst
-		"MENA1",      // Enable modulation
-	}
-	for _, cmd := range cmds {
-		log.Printf("Sending command: %s", cmd)
-		err = gpib.Command(cmd)
-		if err != nil {
-			log.Fatal(err)
-		}
-		time.Sleep(250 * time.Millisecond)
+	// Configure the function generator to create a coded carrier operating at
+	// 100 Hz with 400 ms on time and 200 ms off time.
+	fgen := ds345.New(gpib)
+	log.Println("Configuring coded carrier")
+	err = fgen.SetCodedCarrier(100, 0.5, 400*time.Millisecond, 200*time.Millisecond)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Return local control to the front panel.
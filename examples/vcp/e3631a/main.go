@@ -12,21 +12,29 @@ import (
 
 	"github.com/gotmc/prologix"
 	"github.com/gotmc/prologix/driver/vcp"
-	"github.com/gotmc/query"
+	"github.com/gotmc/prologix/instrument/e3631a"
 )
 
 var (
-	serialPort  string
-	gpibAddress int
+	serialPort   string
+	serialNumber string
+	gpibAddress  int
 )
 
 func init() {
-	// Get Virtual COM Port (VCP) serial port for Prologix.
+	// Get Virtual COM Port (VCP) serial port for Prologix either directly or
+	// using the USB serial number of the Prologix.
 	flag.StringVar(
 		&serialPort,
 		"port",
-		"/dev/tty.usbserial-PX8X3YR6",
-		"Serial port for Prologix VCP GPIB controller",
+		"",
+		"Serial port for Prologix VCP GPIB controller (overrides -serial)",
+	)
+	flag.StringVar(
+		&serialNumber,
+		"serial",
+		"PX8X3YR6",
+		"USB serial number of the Prologix VCP GPIB controller",
 	)
 
 	flag.IntVar(&gpibAddress, "gpib", 5, "GPIB address for the E3631A")
@@ -36,6 +44,15 @@ func main() {
 	// Parse the flags
 	flag.Parse()
 
+	// Find the serial port using the USB serial number if not given.
+	if serialPort == "" {
+		port, err := vcp.FindPort(serialNumber)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serialPort = port
+	}
+
 	// Open virtual comm port.
 	log.Printf("Serial port = %s", serialPort)
 	vcp, err := vcp.NewVCP(serialPort)
@@ -107,64 +124,38 @@ func main() {
 	}
 	log.Printf("query idn = %s", idn)
 
-	cmds := []string{
-		"outp off",
-	}
-
-	for _, cmd := range cmds {
-		err = gpib.Command(cmd)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	// Query the output state
-	state, err := query.Bool(gpib, "OUTP:STAT?")
-	if err != nil && err != io.EOF {
-		log.Fatalf("error querying serial port: %s", err)
-	}
-	if state {
-		log.Println("output is enabled")
-	} else {
-		log.Println("output is disabled")
-	}
-
-	cmds = []string{
-		"apply p6v,4.1,1.2",
-		"outp on",
+	// Use the E3631A driver to disable the outputs, set the voltage and current
+	// of the +6V output, and then enable the outputs.
+	psu := e3631a.New(gpib)
+	err = psu.SetOutputEnabled(false)
+	if err != nil {
+		log.Fatal(err)
 	}
+	logOutputState(psu)
 
-	for _, cmd := range cmds {
-		err = gpib.Command(cmd)
-		if err != nil {
-			log.Fatal(err)
-		}
+	err = psu.Apply(e3631a.P6V, 4.1, 1.2)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	// Query the voltage and current at the output
-	vc, err := gpib.Query("appl? p6v")
-	if err != nil && err != io.EOF {
-		log.Fatalf("error querying serial port: %s", err)
+	err = psu.SetOutputEnabled(true)
+	if err != nil {
+		log.Fatal(err)
 	}
-	log.Printf("voltage, current = %s", vc)
 
-	// Query the voltage at the output
-	volt, err := gpib.Query("meas? p6v")
-	if err != nil && err != io.EOF {
-		log.Fatalf("error querying serial port: %s", err)
+	// Query the voltage and current settings of the output.
+	v, c, err := psu.Applied(e3631a.P6V)
+	if err != nil {
+		log.Fatal(err)
 	}
-	log.Printf("voltage = %s", volt)
+	log.Printf("voltage, current = %g V, %g A", v, c)
 
-	// Query the output state
-	state, err = query.Bool(gpib, "OUTP:STAT?")
-	if err != nil && err != io.EOF {
-		log.Fatalf("error querying serial port: %s", err)
-	}
-	if state {
-		log.Println("output is enabled")
-	} else {
-		log.Println("output is disabled")
+	// Measure the voltage at the output.
+	volt, err := psu.MeasureVoltage(e3631a.P6V)
+	if err != nil {
+		log.Fatal(err)
 	}
+	log.Printf("voltage = %g V", volt)
+	logOutputState(psu)
 
 	// Query the identification of the function generator again.
 	idn, err = gpib.Query("*idn?")
@@ -189,3 +180,16 @@ func main() {
 		log.Printf("error closing serial port: %s", err)
 	}
 }
+
+// logOutputState queries and logs the output state of the E3631A.
+func logOutputState(psu *e3631a.E3631A) {
+	state, err := psu.OutputEnabled()
+	if err != nil {
+		log.Fatalf("error querying output state: %s", err)
+	}
+	if state {
+		log.Println("output is enabled")
+	} else {
+		log.Println("output is disabled")
+	}
+}
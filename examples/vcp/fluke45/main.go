@@ -6,19 +6,21 @@
 package main
 
 import (
-	"io"
 	"log"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gotmc/prologix"
 	"github.com/gotmc/prologix/driver/vcp"
+	"github.com/gotmc/prologix/instrument/fluke45"
 )
 
 func main() {
-	// Open virtual comm port.
-	serialPort := "/dev/tty.usbserial-PXFJL0WD"
+	// Find the serial port of the Prologix using its USB serial number and
+	// then open the virtual comm port.
+	serialPort, err := vcp.FindPort("PXFJL0WD")
+	if err != nil {
+		log.Fatal(err)
+	}
 	vcp, err := vcp.NewVCP(serialPort)
 	if err != nil {
 		log.Fatal(err)
@@ -80,19 +82,6 @@ func main() {
 	}
 	log.Printf("AssertEOI = %t", asserted)
 
-	// Set the GPIB termination
-	err = gpib.SetGPIBTermination(prologix.AppendCRLF)
-	if err != nil {
-		log.Printf("error setting the GPIB termination: %s", err)
-	}
-
-	// Determine GPIB termination
-	term, err := gpib.GPIBTermination()
-	if err != nil {
-		log.Printf("error determining GPIB termination: %s", err)
-	}
-	log.Printf("GPIB termination = %s", term)
-
 	// Send the Selected Device Clear (SDC) message
 	err = gpib.ClearDevice()
 	if err != nil {
@@ -100,64 +89,55 @@ func main() {
 	}
 	time.Sleep(time.Millisecond * 500)
 
-	cmds := []string{
-		"vac",
-		"vdc",
-		"ohms",
-		"rate s",
-		"range 1",
-	}
-
-	for _, cmd := range cmds {
-		_, err = gpib.WriteString(cmd)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	// Query the identification of the DMM
-	log.Printf("Querying with ReadAfterWrite disabled")
-	err = gpib.SetReadAfterWrite(false)
+	// Create the Fluke 45 driver, which sets the GPIB termination to CR+LF and
+	// enables read-after-write.
+	dmm, err := fluke45.New(gpib)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	// Query the identification of the DMM
 	idn, err := gpib.Query("*idn?")
-	if err != nil && err != io.EOF {
-		log.Fatalf("error querying serial port: %s", err)
-	} else if err == io.EOF {
-		log.Printf("received EOF")
+	if err != nil {
+		log.Fatalf("error querying identification: %s", err)
 	}
 	log.Printf("query idn = %s", idn)
 
-	// Query the identification of the DMM
-	log.Printf("Querying with ReadAfterWrite enabled")
-	err = gpib.SetReadAfterWrite(true)
-	if err != nil {
+	// Configure the DMM to measure resistance on the primary display and
+	// frequency on the secondary display.
+	if err := dmm.SetFunction(fluke45.Ohms); err != nil {
+		log.Fatal(err)
+	}
+	if err := dmm.SetRate(fluke45.Slow); err != nil {
 		log.Fatal(err)
 	}
-	idn, err = gpib.Query("*idn?")
-	if err != nil && err != io.EOF {
-		log.Fatalf("error querying serial port: %s", err)
-	} else if err == io.EOF {
-		log.Printf("received EOF")
+	if err := dmm.SetRange(fluke45.Range1); err != nil {
+		log.Fatal(err)
 	}
-	log.Printf("query idn = %s", idn)
 
 	// Measure the resistance
-	resString, err := gpib.Query("meas1?")
-	if err != nil && err != io.EOF {
-		log.Fatalf("error querying serial port: %s", err)
-	} else if err == io.EOF {
-		log.Printf("received EOF")
-	}
-	resString = strings.TrimSpace(resString)
-	res, err := strconv.ParseFloat(resString, 64)
+	res, err := dmm.Measure()
 	if err != nil {
-		log.Printf("error converting %s into a float64", resString)
+		log.Fatalf("error measuring resistance: %s", err)
 	}
-	log.Printf("resistance = %s ohms (string)", resString)
 	log.Printf("resistance = %f ohms", res)
 
+	// Measure DC voltage and frequency using both displays.
+	if err := dmm.SetFunction(fluke45.VDC); err != nil {
+		log.Fatal(err)
+	}
+	if err := dmm.SetSecondaryFunction(fluke45.Frequency); err != nil {
+		log.Fatal(err)
+	}
+	volts, freq, err := dmm.MeasureBoth()
+	if err != nil {
+		log.Fatalf("error measuring: %s", err)
+	}
+	log.Printf("voltage = %f V, frequency = %f Hz", volts, freq)
+	if err := dmm.ClearSecondary(); err != nil {
+		log.Printf("error clearing secondary display: %s", err)
+	}
+
 	// Return local control to the front panel.
 	err = gpib.FrontPanel(true)
 	if err != nil {
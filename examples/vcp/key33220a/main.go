@@ -13,20 +13,29 @@ import (
 
 	"github.com/gotmc/prologix"
 	"github.com/gotmc/prologix/driver/vcp"
+	"github.com/gotmc/prologix/instrument/key33220a"
 )
 
 var (
-	serialPort  string
-	gpibAddress int
+	serialPort   string
+	serialNumber string
+	gpibAddress  int
 )
 
 func init() {
-	// Get Virtual COM Port (VCP) serial port for Prologix.
+	// Get Virtual COM Port (VCP) serial port for Prologix either directly or
+	// using the USB serial number of the Prologix.
 	flag.StringVar(
 		&serialPort,
 		"port",
-		"/dev/tty.usbserial-PX8X3YR6",
-		"Serial port for Prologix VCP GPIB controller",
+		"",
+		"Serial port for Prologix VCP GPIB controller (overrides -serial)",
+	)
+	flag.StringVar(
+		&serialNumber,
+		"serial",
+		"PX8X3YR6",
+		"USB serial number of the Prologix VCP GPIB controller",
 	)
 
 	flag.IntVar(&gpibAddress, "gpib", 6, "GPIB address for the Keysight 33220A")
@@ -36,6 +45,15 @@ func main() {
 	// Parse the flags
 	flag.Parse()
 
+	// Find the serial port using the USB serial number if not given.
+	if serialPort == "" {
+		port, err := vcp.FindPort(serialNumber)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serialPort = port
+	}
+
 	// Open virtual comm port.
 	log.Printf("Serial port = %s", serialPort)
 	vcp, err := vcp.NewVCP(serialPort)
@@ -47,7 +65,12 @@ func main() {
 	// communicating with the instrument at the given GPIB address.
 	log.Printf("Create new Prolgoix controller using GPIB address %d",
 		gpibAddress)
-	gpib, err := prologix.NewController(vcp, gpibAddress, false)
+	gpib, err := prologix.NewController(
+		vcp,
+		gpibAddress,
+		false,
+		prologix.WithAddressPacing(gpibAddress, prologix.Pacing{MinGap: 250 * time.Millisecond}),
+	)
 	if err != nil {
 		log.Fatalf("NewController error: %s", err)
 	}
@@ -108,30 +131,36 @@ func main() {
 	}
 	log.Printf("query idn = %s", idn)
 
-	// Send commands to the function generator required to create a coded carrier
-	// operating at 100 Hz with 400 ms on time and 200 ms off time.
-	cmds := []string{
-		"SYST:REM", // Set the instrument state to remote
-		"OUTP OFF", // Disable the output
-		// "BURS:STAT OFF",        // Disable burst mode (i.e., modulation)
-		"APPL:SIN 100,0.5,0.0", // 100 Hz, 0.5 Vpp amp, 0.0Vdc offset
-		"BURS:MODE TRIG",       // Select the triggered burst mode
-		"BURS:NCYC 40",         // Set burst count to 40 (nearest integer)
-		"BURS:INT:PER 0.6",     // Set burst period to 600 ms
-		"BURS:PHAS 0",          // Set starting phase of burst to 0 degrees
-		"BURS:STAT ON",         // Enable burst mode (i.e., modulation)
-		"OUTP ON",              // Enable the output
-		"SYST:LOC",             // Set the instrument state to local
-	}
-	for _, cmd := range cmds {
-		log.Printf("Sending command: %s", cmd)
-		err = gpib.Command(cmd)
-		if err != nil {
-			log.Fatal(err)
-		}
-		// FIXME: I had to enter this to get the function generator to accept all
-		// commands.
-		time.Sleep(250 * time.Millisecond)
+	// Configure the function generator to create a coded carrier operating at
+	// 100 Hz with 400 ms on time and 200 ms off time.
+	fgen := key33220a.New(gpib)
+	log.Println("Configuring coded carrier")
+	if err := gpib.Command("SYST:REM"); err != nil {
+		log.Fatal(err)
+	}
+	if err := fgen.SetOutputEnabled(false); err != nil {
+		log.Fatal(err)
+	}
+	if err := fgen.Apply(key33220a.Sine, 100, 0.5, 0.0); err != nil {
+		log.Fatal(err)
+	}
+	burst := key33220a.Burst{
+		Mode:   key33220a.TriggeredBurst,
+		Cycles: 40,  // 400 ms at 100 Hz
+		Period: 0.6, // 600 ms
+		Phase:  0,
+	}
+	if err := fgen.SetBurst(burst); err != nil {
+		log.Fatal(err)
+	}
+	if err := fgen.SetBurstEnabled(true); err != nil {
+		log.Fatal(err)
+	}
+	if err := fgen.SetOutputEnabled(true); err != nil {
+		log.Fatal(err)
+	}
+	if err := gpib.Command("SYST:LOC"); err != nil {
+		log.Fatal(err)
 	}
 
 	// Return local control to the front panel.
@@ -3,12 +3,22 @@ module github.com/gotmc/prologix
 go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/gotmc/query v0.5.0
+	github.com/prometheus/client_golang v1.19.1
 	go.bug.st/serial v1.6.2
 	go.uber.org/multierr v1.11.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/creack/goselect v0.1.2 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
 	golang.org/x/sys v0.30.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
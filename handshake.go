@@ -0,0 +1,85 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// errNotPrologix is the underlying error when the response to `++ver` doesn't
+// look like it came from a Prologix controller or an AR488.
+var errNotPrologix = errors.New("not a Prologix or AR488 controller")
+
+// WithHandshake makes NewController verify that the transport is connected to
+// a Prologix controller or an AR488 before sending the init sequence. Stale
+// input is discarded and then the `++ver` command is sent. NewController
+// returns an error wrapping ErrTimeout if there is no response within the
+// given timeout or ErrUnexpectedResponse if the response doesn't look like a
+// Prologix or AR488 version string. The transport must support read
+// deadlines, such as a net.Conn or a VCP; otherwise ErrUnsupported is
+// returned.
+func WithHandshake(timeout time.Duration) ControllerOption {
+	return func(c *Controller) { c.handshakeTimeout = timeout }
+}
+
+// handshake discards stale input and verifies that the response to `++ver`
+// is from a Prologix controller or an AR488.
+func (c *Controller) handshake(timeout time.Duration) error {
+	ds, ok := c.rw.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return fmt.Errorf("%w: handshake requires read deadlines", ErrUnsupported)
+	}
+	defer ds.SetReadDeadline(time.Time{})
+
+	// Discard stale input, reading it if the transport can't be flushed.
+	buf := make([]byte, 256)
+	if err := c.Flush(); errors.Is(err, ErrUnsupported) {
+		if err := ds.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+			return err
+		}
+		for {
+			if _, err := c.rw.Read(buf); err != nil {
+				break
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	cmd := controllerCommand("ver")
+	if _, err := c.send(cmd); err != nil {
+		return err
+	}
+	if err := ds.SetReadDeadline(start.Add(timeout)); err != nil {
+		return err
+	}
+	var resp []byte
+	for !bytes.ContainsRune(resp, '\n') {
+		n, err := c.rw.Read(buf)
+		resp = append(resp, buf[:n]...)
+		if err = wrapReadError(err); err != nil {
+			c.logCommand("rx", cmd, string(resp), start, len(resp), err)
+			if errors.Is(err, ErrTimeout) && len(resp) == 0 {
+				return fmt.Errorf("%w: no response to ++ver within %s", err, timeout)
+			}
+			if errors.Is(err, ErrTimeout) {
+				return unexpectedResponse(cmd, string(resp), errNotPrologix)
+			}
+			return err
+		}
+	}
+	c.logCommand("rx", cmd, string(resp), start, len(resp), nil)
+	ver := strings.ToLower(string(resp))
+	if !strings.Contains(ver, "prologix") && !strings.Contains(ver, "ar488") {
+		return unexpectedResponse(cmd, string(resp), errNotPrologix)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gotmc/prologix/internal/emulator"
+)
+
+// otherDevice emulates a serial device that isn't a Prologix controller and
+// replies to every line with the given response.
+type otherDevice struct {
+	resp string
+	out  bytes.Buffer
+}
+
+func (d *otherDevice) Write(p []byte) (int, error) {
+	d.out.WriteString(d.resp)
+	return len(p), nil
+}
+
+func (d *otherDevice) Read(p []byte) (int, error) {
+	if d.out.Len() == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	return d.out.Read(p)
+}
+
+func (d *otherDevice) SetReadDeadline(time.Time) error { return nil }
+
+func TestHandshake(t *testing.T) {
+	adapter := emulator.New()
+	// Queue a stale response, which must be discarded.
+	adapter.Write([]byte("++addr\n"))
+	if _, err := NewController(adapter, 5, false, WithHandshake(time.Second)); err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	if got := adapter.Commands()[1]; got != "++ver" {
+		t.Errorf("first command after stale input got %s; want ++ver", got)
+	}
+
+	tests := []struct {
+		name    string
+		device  *otherDevice
+		wantErr error
+	}{
+		{"silent device", &otherDevice{}, ErrTimeout},
+		{"other device", &otherDevice{resp: "ERR 12\r\n"}, ErrUnexpectedResponse},
+		{"unterminated response", &otherDevice{resp: "garbage"}, ErrUnexpectedResponse},
+		{"ar488", &otherDevice{resp: "AR488 GPIB controller, ver. 0.51.18, 26/02/2023\r\n"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewController(test.device, 5, false, WithHandshake(10*time.Millisecond))
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got %v; want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandshakeUnsupported(t *testing.T) {
+	var f fakeAdapter
+	_, err := NewController(&f, 5, false, WithHandshake(time.Second))
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v; want ErrUnsupported", err)
+	}
+	if f.written.Len() != 0 {
+		t.Errorf("sent %q to an unverified device", f.written.String())
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gotmc/query"
+)
+
+// Instrument is the interface that instrument drivers, such as those in the
+// gotmc/ivi package, expect of the bus used to communicate with an instrument.
+type Instrument interface {
+	Read(p []byte) (n int, err error)
+	Write(p []byte) (n int, err error)
+	WriteString(s string) (n int, err error)
+	Command(format string, a ...any) error
+	Query(cmd string) (string, error)
+}
+
+// Bus is the interface the drivers in the instrument directory use to send
+// commands, queries, and binary data to an instrument. The responses are
+// parsed using the typed query helpers, such as QueryFloat, so all drivers
+// return the SCPI overload and not-a-number values as infinity and NaN.
+type Bus interface {
+	Command(format string, a ...any) error
+	Query(cmd string) (string, error)
+	QueryString(cmd string) (string, error)
+	QueryFloat(cmd string) (float64, error)
+	QueryFloats(cmd string) ([]float64, error)
+	QueryInt(cmd string) (int, error)
+	QueryBool(cmd string) (bool, error)
+	WriteBinary(p []byte) (n int, err error)
+}
+
+// Both the Controller and the per-address Device satisfy the interfaces
+// expected by the instrument drivers and the ivi and query packages.
+var (
+	_ Bus           = (*Controller)(nil)
+	_ Bus           = (*Device)(nil)
+	_ Instrument    = (*Controller)(nil)
+	_ Instrument    = (*Device)(nil)
+	_ query.Querier = (*Controller)(nil)
+	_ query.Querier = (*Device)(nil)
+)
+
+// Device is a handle for the instrument at one GPIB primary address of a
+// Controller. Each method selects the address if needed and holds the bus for
+// the duration of the call, so Devices for different addresses can be used
+// concurrently, such as by separate ivi drivers. While Devices are in use, the
+// Controller must not be used directly. Creating a Device disables saving the
+// configuration in the EEPROM of the Prologix controller, as described for
+// Controller.Device.
+type Device struct {
+	c                *Controller
+	addr             int
+	hasSecondaryAddr bool
+	secondaryAddr    int
+	eoi              bool
+	eos              GpibTerm
+	auto             bool
+	readTimeout      int
+}
+
+// DeviceOption applies an option to a Device.
+type DeviceOption func(*Device)
+
+// WithDeviceSecondaryAddress sets the secondary address of the instrument,
+// which must be in the range of 96 and 126, inclusive.
+func WithDeviceSecondaryAddress(addr int) DeviceOption {
+	return func(d *Device) {
+		d.hasSecondaryAddr = true
+		d.secondaryAddr = addr
+	}
+}
+
+// WithDeviceAssertEOI sets whether EOI is asserted with the last character
+// sent to the instrument.
+func WithDeviceAssertEOI(enable bool) DeviceOption {
+	return func(d *Device) { d.eoi = enable }
+}
+
+// WithDeviceGPIBTermination sets the GPIB terminator appended to data sent to
+// the instrument.
+func WithDeviceGPIBTermination(term GpibTerm) DeviceOption {
+	return func(d *Device) { d.eos = term }
+}
+
+// WithDeviceReadAfterWrite sets whether the Prologix controller automatically
+// addresses the instrument to talk after sending it a command.
+func WithDeviceReadAfterWrite(enable bool) DeviceOption {
+	return func(d *Device) { d.auto = enable }
+}
+
+// WithDeviceReadTimeout sets the Prologix controller's read timeout in
+// milliseconds used for the instrument, which must be between 1 and 3000
+// milliseconds.
+func WithDeviceReadTimeout(timeout int) DeviceOption {
+	return func(d *Device) { d.readTimeout = timeout }
+}
+
+// Device returns a handle for the instrument at the given GPIB primary
+// address. The EOI, GPIB termination, read-after-write, and read timeout
+// settings default to the settings of the Controller and can be set per instrument using the
+// DeviceOptions, in which case the Prologix controller is reconfigured when
+// switching between instruments with different settings. Since switching
+// changes the address and possibly other settings, the SaveConfigEnable policy
+// is replaced by SaveConfigDisable and `savecfg 0` is sent to the Prologix
+// controller, so that switching doesn't write the EEPROM. With the
+// SaveConfigUnchanged policy, the `savecfg` setting is left as is.
+func (c *Controller) Device(addr int, opts ...DeviceOption) (*Device, error) {
+	c.mu.Lock()
+	d := Device{
+		c:           c,
+		addr:        addr,
+		eoi:         c.eoi,
+		eos:         c.eos,
+		auto:        c.auto,
+		readTimeout: c.readTimeout,
+	}
+	c.mu.Unlock()
+	for _, opt := range opts {
+		opt(&d)
+	}
+	if !isPrimaryAddressValid(addr) {
+		return nil, fmt.Errorf("%w: primary address %d (must be 0-30)", ErrInvalidAddress, addr)
+	}
+	if d.hasSecondaryAddr && !isSecondaryAddressValid(d.secondaryAddr) {
+		return nil, fmt.Errorf("%w: secondary address %d (must be 96-126)", ErrInvalidAddress, d.secondaryAddr)
+	}
+	if !isReadTimeoutValid(d.readTimeout) {
+		return nil, fmt.Errorf("read timeout outside 1 to 3000 ms; attempted to set to %d", d.readTimeout)
+	}
+	if _, ok := gpibTermDesc[d.eos]; !ok {
+		return nil, fmt.Errorf("invalid GPIB termination %d (must be 0-3)", d.eos)
+	}
+	// Switching between Devices reconfigures the Prologix controller, which
+	// would write the EEPROM each time if saving were left enabled.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.saveConfig == SaveConfigEnable && !c.ar488 {
+		if err := c.CommandController("savecfg 0"); err != nil {
+			return nil, err
+		}
+		c.saveConfig = SaveConfigDisable
+	}
+	return &d, nil
+}
+
+// Do holds the bus and calls fn with the Controller, so that fn can use the
+// Prologix controller itself, such as to take a Snapshot, while Devices are in
+// use. fn must not use any Device of the Controller.
+func (c *Controller) Do(fn func(c *Controller) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fn(c)
+}
+
+// Address returns the GPIB primary address of the instrument.
+func (d *Device) Address() int {
+	return d.addr
+}
+
+// Read reads from the instrument into the given byte slice.
+func (d *Device) Read(p []byte) (n int, err error) {
+	err = d.do(func() error {
+		n, err = d.c.Read(p)
+		return err
+	})
+	return n, err
+}
+
+// Write writes the given data to the instrument.
+func (d *Device) Write(p []byte) (n int, err error) {
+	err = d.do(func() error {
+		n, err = d.c.Write(p)
+		return err
+	})
+	return n, err
+}
+
+// WriteBinary sends binary data to the instrument, escaping the characters
+// the Prologix would otherwise remove.
+func (d *Device) WriteBinary(p []byte) (n int, err error) {
+	err = d.do(func() error {
+		n, err = d.c.WriteBinary(p)
+		return err
+	})
+	return n, err
+}
+
+// WriteString writes a string to the instrument.
+func (d *Device) WriteString(s string) (n int, err error) {
+	err = d.do(func() error {
+		n, err = d.c.WriteString(s)
+		return err
+	})
+	return n, err
+}
+
+// Command formats according to a format specifier if provided and sends a
+// SCPI/ASCII command to the instrument.
+func (d *Device) Command(format string, a ...any) error {
+	return d.do(func() error {
+		return d.c.Command(format, a...)
+	})
+}
+
+// Query queries the instrument using the given SCPI/ASCII command.
+func (d *Device) Query(cmd string) (s string, err error) {
+	err = d.do(func() error {
+		s, err = d.c.Query(cmd)
+		return err
+	})
+	return s, err
+}
+
+// ReadResponse reads the response of the instrument to a previously sent
+// command.
+func (d *Device) ReadResponse() (s string, err error) {
+	err = d.do(func() error {
+		s, err = d.c.ReadResponse()
+		return err
+	})
+	return s, err
+}
+
+// QueryWithTimeout queries the instrument using the given SCPI/ASCII command,
+// waiting up to the given timeout for the response.
+func (d *Device) QueryWithTimeout(cmd string, timeout time.Duration) (s string, err error) {
+	err = d.do(func() error {
+		s, err = d.c.QueryWithTimeout(cmd, timeout)
+		return err
+	})
+	return s, err
+}
+
+// ReadResponseWithTimeout reads the response of the instrument to a
+// previously sent command, waiting up to the given timeout for the response.
+func (d *Device) ReadResponseWithTimeout(timeout time.Duration) (s string, err error) {
+	err = d.do(func() error {
+		s, err = d.c.ReadResponseWithTimeout(timeout)
+		return err
+	})
+	return s, err
+}
+
+// QueryString queries the instrument and returns the trimmed response, like
+// Controller.QueryString.
+func (d *Device) QueryString(cmd string) (s string, err error) {
+	err = d.do(func() error {
+		s, err = d.c.QueryString(cmd)
+		return err
+	})
+	return s, err
+}
+
+// QueryFloat queries the instrument and parses the response as a float64,
+// like Controller.QueryFloat.
+func (d *Device) QueryFloat(cmd string) (f float64, err error) {
+	err = d.do(func() error {
+		f, err = d.c.QueryFloat(cmd)
+		return err
+	})
+	return f, err
+}
+
+// QueryFloats queries the instrument and parses the response as comma
+// separated float64 values, like Controller.QueryFloats.
+func (d *Device) QueryFloats(cmd string) (values []float64, err error) {
+	err = d.do(func() error {
+		values, err = d.c.QueryFloats(cmd)
+		return err
+	})
+	return values, err
+}
+
+// QueryInt queries the instrument and parses the response as an int, like
+// Controller.QueryInt.
+func (d *Device) QueryInt(cmd string) (i int, err error) {
+	err = d.do(func() error {
+		i, err = d.c.QueryInt(cmd)
+		return err
+	})
+	return i, err
+}
+
+// QueryBool queries the instrument and parses the response as a bool, like
+// Controller.QueryBool.
+func (d *Device) QueryBool(cmd string) (b bool, err error) {
+	err = d.do(func() error {
+		b, err = d.c.QueryBool(cmd)
+		return err
+	})
+	return b, err
+}
+
+// SetGPIBTermination sets the GPIB terminator appended to data sent to the
+// instrument, like WithDeviceGPIBTermination.
+func (d *Device) SetGPIBTermination(term GpibTerm) error {
+	if _, ok := gpibTermDesc[term]; !ok {
+		return fmt.Errorf("invalid GPIB termination %d (must be 0-3)", term)
+	}
+	return d.do(func() error {
+		if err := d.c.SetGPIBTermination(term); err != nil {
+			return err
+		}
+		d.eos = term
+		return nil
+	})
+}
+
+// SetReadAfterWrite sets whether the Prologix controller automatically
+// addresses the instrument to talk after sending it a command, like
+// WithDeviceReadAfterWrite.
+func (d *Device) SetReadAfterWrite(enable bool) error {
+	return d.do(func() error {
+		if err := d.c.SetReadAfterWrite(enable); err != nil {
+			return err
+		}
+		d.auto = enable
+		return nil
+	})
+}
+
+// Do holds the bus, selects the address of the instrument, and then calls fn
+// with the Controller, for operations not provided by Device, such as
+// ClearDevice or SerialPoll.
+func (d *Device) Do(fn func(c *Controller) error) error {
+	return d.do(func() error { return fn(d.c) })
+}
+
+// do holds the bus, selects the address of the instrument and reconfigures
+// the Prologix controller if another instrument was used last, and then calls
+// fn.
+func (d *Device) do(fn func() error) error {
+	d.c.mu.Lock()
+	defer d.c.mu.Unlock()
+	if err := d.configure(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// configure sends the Prologix commands needed to match the address and
+// settings of the instrument.
+func (d *Device) configure() error {
+	c := d.c
+	if c.primaryAddr != d.addr || c.hasSecondaryAddr != d.hasSecondaryAddr ||
+		(d.hasSecondaryAddr && c.secondaryAddr != d.secondaryAddr) {
+		cmd := fmt.Sprintf("addr %d", d.addr)
+		if d.hasSecondaryAddr {
+			cmd = fmt.Sprintf("addr %d %d", d.addr, d.secondaryAddr)
+		}
+		if err := c.CommandController(cmd); err != nil {
+			return err
+		}
+		c.primaryAddr = d.addr
+		c.hasSecondaryAddr = d.hasSecondaryAddr
+		c.secondaryAddr = d.secondaryAddr
+	}
+	if c.eoi != d.eoi {
+		if err := c.SetAssertEOI(d.eoi); err != nil {
+			return err
+		}
+	}
+	if c.eos != d.eos {
+		if err := c.SetGPIBTermination(d.eos); err != nil {
+			return err
+		}
+	}
+	if c.auto != d.auto {
+		if err := c.SetReadAfterWrite(d.auto); err != nil {
+			return err
+		}
+	}
+	if c.readTimeout != d.readTimeout {
+		return c.SetReadTimeout(d.readTimeout)
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package ds345 controls the Stanford Research Systems DS345 function generator
+over GPIB using a prologix.Controller.
+
+Arbitrary waveforms are loaded using the DS345 `LDWF?` binary protocol: after
+the DS345 acknowledges the `LDWF?` query, the points are sent as 16-bit
+integers, low byte first, followed by a 16-bit checksum of the points. Since
+the Prologix removes unescaped CR, LF, ESC, and `+` characters, the points are
+sent using the escaping WriteBinary method of the prologix.Controller.
+*/
+package ds345
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/instrument/internal/param"
+)
+
+// Limits of the arbitrary waveform memory of the DS345 in point mode.
+const (
+	MaxPoint  = 2047
+	MaxPoints = 16300
+)
+
+// ErrWaveform is returned when an arbitrary waveform can't be loaded into the
+// DS345.
+var ErrWaveform = errors.New("ds345: invalid arbitrary waveform")
+
+// Function provides the type for the output functions.
+type Function int
+
+// Available output functions.
+const (
+	Sine Function = iota
+	Square
+	Triangle
+	Ramp
+	Noise
+	Arbitrary
+)
+
+var functionDesc = map[Function]string{
+	Sine:      "sine",
+	Square:    "square",
+	Triangle:  "triangle",
+	Ramp:      "ramp",
+	Noise:     "noise",
+	Arbitrary: "arbitrary",
+}
+
+func (f Function) String() string {
+	return functionDesc[f]
+}
+
+// AmplitudeUnit provides the type for the amplitude units.
+type AmplitudeUnit int
+
+// Available amplitude units.
+const (
+	Vpp AmplitudeUnit = iota
+	Vrms
+	DBm
+)
+
+var unitCmds = map[AmplitudeUnit]string{
+	Vpp:  "VP",
+	Vrms: "VR",
+	DBm:  "DB",
+}
+
+func (u AmplitudeUnit) String() string {
+	return unitCmds[u]
+}
+
+// TriggerSource provides the type for the trigger sources used by bursts and
+// sweeps.
+type TriggerSource int
+
+// Available trigger sources.
+const (
+	SingleTrigger TriggerSource = iota
+	InternalTrigger
+	ExternalRising
+	ExternalFalling
+	LineTrigger
+)
+
+// ModulationType provides the type for the modulation types.
+type ModulationType int
+
+// Available modulation types.
+const (
+	LinearSweep ModulationType = iota
+	LogSweep
+	AM
+	FM
+	PM
+	Burst
+)
+
+// DS345 models an SRS DS345 function generator.
+type DS345 struct {
+	bus prologix.Bus
+}
+
+// New creates a DS345 driver that communicates using the given Bus, such as a
+// prologix.Controller or prologix.Device. The GPIB address must already be
+// selected, for instance by passing it to prologix.NewController.
+func New(bus prologix.Bus) *DS345 {
+	return &DS345{bus: bus}
+}
+
+// SetFunction sets the output function.
+func (d *DS345) SetFunction(f Function) error {
+	if _, ok := functionDesc[f]; !ok {
+		return fmt.Errorf("invalid function %d", f)
+	}
+	return d.bus.Command("FUNC %d", f)
+}
+
+// Function queries the output function.
+func (d *DS345) Function() (Function, error) {
+	i, err := d.bus.QueryInt("FUNC?")
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := functionDesc[Function(i)]; !ok {
+		return 0, &prologix.UnexpectedResponseError{Command: "FUNC?", Raw: []byte(strconv.Itoa(i))}
+	}
+	return Function(i), nil
+}
+
+// SetFrequency sets the output frequency in Hz.
+func (d *DS345) SetFrequency(hz float64) error {
+	return d.bus.Command("FREQ %s", param.Float(hz))
+}
+
+// Frequency queries the output frequency in Hz.
+func (d *DS345) Frequency() (float64, error) {
+	return d.bus.QueryFloat("FREQ?")
+}
+
+// SetAmplitude sets the output amplitude in the given unit.
+func (d *DS345) SetAmplitude(amp float64, u AmplitudeUnit) error {
+	unit, ok := unitCmds[u]
+	if !ok {
+		return fmt.Errorf("invalid amplitude unit %d", u)
+	}
+	return d.bus.Command("AMPL %s%s", param.Float(amp), unit)
+}
+
+// Amplitude queries the output amplitude in the given unit.
+func (d *DS345) Amplitude(u AmplitudeUnit) (float64, error) {
+	unit, ok := unitCmds[u]
+	if !ok {
+		return 0, fmt.Errorf("invalid amplitude unit %d", u)
+	}
+	cmd := "AMPL? " + unit
+	s, err := d.bus.QueryString(cmd)
+	if err != nil {
+		return 0, err
+	}
+	// The response includes the unit, such as 0.50VP.
+	f, err := strconv.ParseFloat(strings.TrimSuffix(s, unit), 64)
+	if err != nil {
+		return 0, &prologix.UnexpectedResponseError{Command: cmd, Raw: []byte(s), Err: err}
+	}
+	return f, nil
+}
+
+// SetOffset sets the DC offset in V.
+func (d *DS345) SetOffset(volts float64) error {
+	return d.bus.Command("OFFS %s", param.Float(volts))
+}
+
+// Offset queries the DC offset in V.
+func (d *DS345) Offset() (float64, error) {
+	return d.bus.QueryFloat("OFFS?")
+}
+
+// SetPhase sets the output phase in degrees.
+func (d *DS345) SetPhase(degrees float64) error {
+	return d.bus.Command("PHSE %s", param.Float(degrees))
+}
+
+// SetBurstCount sets the number of cycles per burst, from 1 to 30000.
+func (d *DS345) SetBurstCount(n int) error {
+	if n < 1 || n > 30000 {
+		return fmt.Errorf("invalid burst count %d (must be 1-30000)", n)
+	}
+	return d.bus.Command("BCNT %d", n)
+}
+
+// SetTriggerSource sets the trigger source for bursts and sweeps.
+func (d *DS345) SetTriggerSource(src TriggerSource) error {
+	if src < SingleTrigger || src > LineTrigger {
+		return fmt.Errorf("invalid trigger source %d", src)
+	}
+	return d.bus.Command("TSRC %d", src)
+}
+
+// SetTriggerRate sets the rate of the internal trigger in Hz.
+func (d *DS345) SetTriggerRate(hz float64) error {
+	return d.bus.Command("TRAT %s", param.Float(hz))
+}
+
+// Trigger triggers a burst or sweep when the trigger source is SingleTrigger.
+func (d *DS345) Trigger() error {
+	return d.bus.Command("*TRG")
+}
+
+// SetModulationType sets the modulation type.
+func (d *DS345) SetModulationType(m ModulationType) error {
+	if m < LinearSweep || m > Burst {
+		return fmt.Errorf("invalid modulation type %d", m)
+	}
+	return d.bus.Command("MTYP %d", m)
+}
+
+// SetModulationEnabled enables or disables modulation.
+func (d *DS345) SetModulationEnabled(enable bool) error {
+	if enable {
+		return d.bus.Command("MENA 1")
+	}
+	return d.bus.Command("MENA 0")
+}
+
+// ModulationEnabled reports whether modulation is enabled.
+func (d *DS345) ModulationEnabled() (bool, error) {
+	return d.bus.QueryBool("MENA?")
+}
+
+// SetCodedCarrier configures a coded carrier: a sine wave burst at the given
+// frequency in Hz and amplitude in Vpp that is on for onTime and then off for
+// offTime, repeating indefinitely. The burst count is rounded to the nearest
+// whole number of cycles. For instance, a 100 Hz carrier with 400 ms on time
+// and 200 ms off time sends bursts of 40 cycles at a 1.667 Hz trigger rate.
+func (d *DS345) SetCodedCarrier(freq, amp float64, onTime, offTime time.Duration) error {
+	if freq <= 0 || onTime <= 0 || offTime < 0 {
+		return fmt.Errorf("invalid coded carrier %g Hz, %s on, %s off", freq, onTime, offTime)
+	}
+	cycles := int(math.Round(freq * onTime.Seconds()))
+	if cycles < 1 {
+		cycles = 1
+	}
+	rate := 1 / (onTime + offTime).Seconds()
+	steps := []func() error{
+		func() error { return d.SetModulationEnabled(false) },
+		func() error { return d.SetFunction(Sine) },
+		func() error { return d.SetFrequency(freq) },
+		func() error { return d.SetAmplitude(amp, Vpp) },
+		func() error { return d.SetOffset(0) },
+		func() error { return d.SetPhase(0) },
+		func() error { return d.SetBurstCount(cycles) },
+		func() error { return d.SetTriggerSource(InternalTrigger) },
+		func() error { return d.SetTriggerRate(math.Round(rate*1000) / 1000) },
+		func() error { return d.SetModulationType(Burst) },
+		func() error { return d.SetModulationEnabled(true) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetSampleRate sets the sampling frequency of arbitrary waveforms in Hz.
+func (d *DS345) SetSampleRate(hz float64) error {
+	return d.bus.Command("FSMP %s", param.Float(hz))
+}
+
+// LoadArbitrary loads an arbitrary waveform of 1 to 16300 points in the range
+// -2047 to +2047 using point mode and selects the Arbitrary function.
+func (d *DS345) LoadArbitrary(points []int16) error {
+	if len(points) < 1 || len(points) > MaxPoints {
+		return fmt.Errorf("%w: %d points, must be 1 to %d", ErrWaveform, len(points), MaxPoints)
+	}
+	for i, v := range points {
+		if v < -MaxPoint || v > MaxPoint {
+			return fmt.Errorf("%w: point %d is %d, outside ±%d", ErrWaveform, i, v, MaxPoint)
+		}
+	}
+	cmd := fmt.Sprintf("LDWF? 0,%d", len(points))
+	s, err := d.bus.QueryString(cmd)
+	if err != nil {
+		return err
+	}
+	if s != "1" {
+		return &prologix.UnexpectedResponseError{Command: cmd, Raw: []byte(s), Err: ErrWaveform}
+	}
+	if _, err := d.bus.WriteBinary(waveformData(points)); err != nil {
+		return err
+	}
+	return d.SetFunction(Arbitrary)
+}
+
+// waveformData returns the points followed by their checksum as 16-bit
+// integers, low byte first. The checksum is the sum of the points truncated
+// to 16 bits.
+func waveformData(points []int16) []byte {
+	buf := make([]byte, 0, 2*len(points)+2)
+	var sum uint16
+	for _, v := range points {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(v))
+		sum += uint16(v)
+	}
+	return binary.LittleEndian.AppendUint16(buf, sum)
+}
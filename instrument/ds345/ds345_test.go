@@ -0,0 +1,142 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package ds345
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gotmc/prologix/internal/emulatortest"
+)
+
+// generator emulates the subset of the DS345 commands used by the driver.
+type generator struct {
+	settings map[string]string
+	pending  int // Number of points expected after LDWF?.
+	points   []int16
+	checksum bool
+	log      []string
+}
+
+func newGenerator() *generator {
+	return &generator{settings: map[string]string{}}
+}
+
+func (g *generator) Handle(msg []byte) string {
+	if g.pending > 0 {
+		g.pending, g.checksum = 0, false
+		if len(msg) != 2*len(g.points)+2 {
+			return ""
+		}
+		var sum uint16
+		for i := range g.points {
+			g.points[i] = int16(binary.LittleEndian.Uint16(msg[2*i:]))
+			sum += uint16(g.points[i])
+		}
+		g.checksum = binary.LittleEndian.Uint16(msg[len(msg)-2:]) == sum
+		return ""
+	}
+	cmd := string(msg)
+	g.log = append(g.log, cmd)
+	name, arg, _ := strings.Cut(cmd, " ")
+	switch name {
+	case "LDWF?":
+		_, n, _ := strings.Cut(arg, ",")
+		g.pending, _ = strconv.Atoi(n)
+		g.points = make([]int16, g.pending)
+		return "1\n"
+	case "AMPL?":
+		return g.settings["AMPL"] + "\n"
+	}
+	if strings.HasSuffix(name, "?") {
+		return g.settings[strings.TrimSuffix(name, "?")] + "\n"
+	}
+	g.settings[name] = arg
+	return ""
+}
+
+func TestSettings(t *testing.T) {
+	g := newGenerator()
+	c, _ := emulatortest.NewController(t, 19, emulatortest.Instruments{19: g})
+	d := New(c)
+	if err := d.SetFunction(Triangle); err != nil {
+		t.Fatalf("SetFunction error: %s", err)
+	}
+	if f, err := d.Function(); err != nil || f != Triangle {
+		t.Errorf("Function got %s, %v; want triangle", f, err)
+	}
+	if err := d.SetAmplitude(-3.5, DBm); err != nil {
+		t.Fatalf("SetAmplitude error: %s", err)
+	}
+	if g.settings["AMPL"] != "-3.5DB" {
+		t.Errorf("AMPL got %q; want -3.5DB", g.settings["AMPL"])
+	}
+	if a, err := d.Amplitude(DBm); err != nil || a != -3.5 {
+		t.Errorf("Amplitude got %g, %v; want -3.5", a, err)
+	}
+	if err := d.SetBurstCount(0); err == nil {
+		t.Error("SetBurstCount(0) succeeded; want error")
+	}
+}
+
+func TestSetCodedCarrier(t *testing.T) {
+	g := newGenerator()
+	c, _ := emulatortest.NewController(t, 19, emulatortest.Instruments{19: g})
+	d := New(c)
+	if err := d.SetCodedCarrier(100, 0.5, 400*time.Millisecond, 200*time.Millisecond); err != nil {
+		t.Fatalf("SetCodedCarrier error: %s", err)
+	}
+	want := []string{
+		"MENA 0", "FUNC 0", "FREQ 100", "AMPL 0.5VP", "OFFS 0", "PHSE 0",
+		"BCNT 40", "TSRC 1", "TRAT 1.667", "MTYP 5", "MENA 1",
+	}
+	got := g.log[len(g.log)-len(want):]
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("command %d got %q; want %q", i, got[i], want[i])
+		}
+	}
+	if on, err := d.ModulationEnabled(); err != nil || !on {
+		t.Errorf("ModulationEnabled got %t, %v; want true", on, err)
+	}
+}
+
+func TestLoadArbitrary(t *testing.T) {
+	g := newGenerator()
+	c, _ := emulatortest.NewController(t, 19, emulatortest.Instruments{19: g})
+	d := New(c)
+	// The bytes of these points include CR, LF, ESC, and `+`, which must be
+	// escaped to pass through the Prologix.
+	points := []int16{0x020a, 0x070d, 0x071b, 0x2b, -1, MaxPoint, -MaxPoint}
+	if err := d.LoadArbitrary(points); err != nil {
+		t.Fatalf("LoadArbitrary error: %s", err)
+	}
+	if !g.checksum {
+		t.Errorf("invalid checksum for points %v", g.points)
+	}
+	for i := range points {
+		if g.points[i] != points[i] {
+			t.Errorf("point %d got %d; want %d", i, g.points[i], points[i])
+		}
+	}
+	if g.settings["FUNC"] != "5" {
+		t.Errorf("FUNC got %q; want 5", g.settings["FUNC"])
+	}
+
+	sent := len(g.log)
+	for _, points := range [][]int16{nil, make([]int16, MaxPoints+1), {MaxPoint + 1}} {
+		if err := d.LoadArbitrary(points); !errors.Is(err, ErrWaveform) {
+			t.Errorf("%d points got %v; want ErrWaveform", len(points), err)
+		}
+	}
+	if len(g.log) != sent {
+		t.Errorf("sent %v despite invalid waveforms", g.log[sent:])
+	}
+}
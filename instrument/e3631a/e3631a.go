@@ -0,0 +1,257 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package e3631a controls the Keysight (Agilent) E3631A triple output DC power
+supply over GPIB using a prologix.Controller.
+
+The E3631A has three outputs: P6V (0 to +6 V, 0 to 5 A), P25V (0 to +25 V, 0
+to 1 A), and N25V (0 to -25 V, 0 to 1 A). The E3631A doesn't provide
+programmable over-voltage or over-current protection, so the driver provides
+host-side voltage and current limits that reject settings exceeding them.
+*/
+package e3631a
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/instrument/internal/param"
+)
+
+// ErrLimit is returned when a voltage or current setting exceeds either the
+// rating of the output or a limit set using SetVoltageLimit or
+// SetCurrentLimit.
+var ErrLimit = errors.New("e3631a: setting exceeds limit")
+
+// Output provides the type for the outputs of the E3631A.
+type Output int
+
+// Available outputs of the E3631A.
+const (
+	P6V Output = iota
+	P25V
+	N25V
+)
+
+var outputNames = map[Output]string{
+	P6V:  "P6V",
+	P25V: "P25V",
+	N25V: "N25V",
+}
+
+func (out Output) String() string {
+	return outputNames[out]
+}
+
+// rating holds the maximum voltage magnitude and current of an output, which
+// are slightly above the nominal rating as allowed by the E3631A.
+type rating struct {
+	volts, amps float64
+}
+
+var ratings = map[Output]rating{
+	P6V:  {6.18, 5.15},
+	P25V: {25.75, 1.03},
+	N25V: {25.75, 1.03},
+}
+
+// E3631A models a Keysight E3631A power supply.
+type E3631A struct {
+	bus    prologix.Bus
+	limits map[Output]rating
+}
+
+// New creates an E3631A driver that communicates using the given Bus, such as
+// a prologix.Controller or prologix.Device. The GPIB address must already be
+// selected, for instance by passing it to prologix.NewController.
+func New(bus prologix.Bus) *E3631A {
+	limits := make(map[Output]rating, len(ratings))
+	for out, r := range ratings {
+		limits[out] = r
+	}
+	return &E3631A{bus: bus, limits: limits}
+}
+
+// SetVoltageLimit sets the host-side limit for the voltage magnitude of the
+// output. Subsequent voltage settings above the limit return ErrLimit.
+func (d *E3631A) SetVoltageLimit(out Output, volts float64) error {
+	if err := checkOutput(out); err != nil {
+		return err
+	}
+	r := d.limits[out]
+	r.volts = math.Min(math.Abs(volts), ratings[out].volts)
+	d.limits[out] = r
+	return nil
+}
+
+// SetCurrentLimit sets the host-side limit for the current of the output.
+// Subsequent current settings above the limit return ErrLimit.
+func (d *E3631A) SetCurrentLimit(out Output, amps float64) error {
+	if err := checkOutput(out); err != nil {
+		return err
+	}
+	r := d.limits[out]
+	r.amps = math.Min(math.Abs(amps), ratings[out].amps)
+	d.limits[out] = r
+	return nil
+}
+
+// Select selects the output programmed by subsequent voltage and current
+// commands.
+func (d *E3631A) Select(out Output) error {
+	if err := checkOutput(out); err != nil {
+		return err
+	}
+	return d.bus.Command("INST:SEL %s", out)
+}
+
+// Apply sets the voltage and current of the output. The voltage of the N25V
+// output is negative, but either sign is accepted.
+func (d *E3631A) Apply(out Output, volts, amps float64) error {
+	if err := d.checkVoltage(out, volts); err != nil {
+		return err
+	}
+	if err := d.checkCurrent(out, amps); err != nil {
+		return err
+	}
+	return d.bus.Command("APPL %s,%s,%s", out, formatVoltage(out, volts), param.Float(amps))
+}
+
+// Applied returns the voltage and current settings of the output using the
+// `APPL?` query.
+func (d *E3631A) Applied(out Output) (float64, float64, error) {
+	if err := checkOutput(out); err != nil {
+		return 0, 0, err
+	}
+	cmd := fmt.Sprintf("APPL? %s", out)
+	s, err := d.bus.QueryString(cmd)
+	if err != nil {
+		return 0, 0, err
+	}
+	// The response is quoted, such as "+4.100000E+00,+1.200000E+00".
+	fields := strings.Split(strings.Trim(s, `"`), ",")
+	if len(fields) != 2 {
+		return 0, 0, &prologix.UnexpectedResponseError{Command: cmd, Raw: []byte(s)}
+	}
+	volts, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+	if err != nil {
+		return 0, 0, &prologix.UnexpectedResponseError{Command: cmd, Raw: []byte(s), Err: err}
+	}
+	amps, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+	if err != nil {
+		return 0, 0, &prologix.UnexpectedResponseError{Command: cmd, Raw: []byte(s), Err: err}
+	}
+	return volts, amps, nil
+}
+
+// SetVoltage selects the output and sets its voltage.
+func (d *E3631A) SetVoltage(out Output, volts float64) error {
+	if err := d.checkVoltage(out, volts); err != nil {
+		return err
+	}
+	if err := d.Select(out); err != nil {
+		return err
+	}
+	return d.bus.Command("VOLT %s", formatVoltage(out, volts))
+}
+
+// SetCurrent selects the output and sets its current.
+func (d *E3631A) SetCurrent(out Output, amps float64) error {
+	if err := d.checkCurrent(out, amps); err != nil {
+		return err
+	}
+	if err := d.Select(out); err != nil {
+		return err
+	}
+	return d.bus.Command("CURR %s", param.Float(amps))
+}
+
+// MeasureVoltage measures the voltage at the output. An overload is returned
+// as positive or negative infinity.
+func (d *E3631A) MeasureVoltage(out Output) (float64, error) {
+	if err := checkOutput(out); err != nil {
+		return 0, err
+	}
+	return d.bus.QueryFloat(fmt.Sprintf("MEAS:VOLT? %s", out))
+}
+
+// MeasureCurrent measures the current at the output. An overload is returned
+// as positive or negative infinity.
+func (d *E3631A) MeasureCurrent(out Output) (float64, error) {
+	if err := checkOutput(out); err != nil {
+		return 0, err
+	}
+	return d.bus.QueryFloat(fmt.Sprintf("MEAS:CURR? %s", out))
+}
+
+// SetOutputEnabled enables or disables all three outputs.
+func (d *E3631A) SetOutputEnabled(enable bool) error {
+	return d.bus.Command("OUTP %s", param.OnOff(enable))
+}
+
+// OutputEnabled reports whether the outputs are enabled.
+func (d *E3631A) OutputEnabled() (bool, error) {
+	return d.bus.QueryBool("OUTP?")
+}
+
+// SetTracking enables or disables tracking mode, in which the N25V output
+// tracks the voltage of the P25V output.
+func (d *E3631A) SetTracking(enable bool) error {
+	return d.bus.Command("OUTP:TRAC %s", param.OnOff(enable))
+}
+
+// Tracking reports whether tracking mode is enabled.
+func (d *E3631A) Tracking() (bool, error) {
+	return d.bus.QueryBool("OUTP:TRAC?")
+}
+
+// checkOutput verifies that out is one of the three outputs.
+func checkOutput(out Output) error {
+	if _, ok := outputNames[out]; !ok {
+		return fmt.Errorf("invalid output %d", out)
+	}
+	return nil
+}
+
+// checkVoltage verifies the voltage against the limit of the output.
+func (d *E3631A) checkVoltage(out Output, volts float64) error {
+	if err := checkOutput(out); err != nil {
+		return err
+	}
+	if out != N25V && volts < 0 {
+		return fmt.Errorf("%w: %s voltage must be positive, was %g V", ErrLimit, out, volts)
+	}
+	if limit := d.limits[out].volts; math.Abs(volts) > limit {
+		return fmt.Errorf("%w: %s voltage %g V above %g V", ErrLimit, out, volts, limit)
+	}
+	return nil
+}
+
+// checkCurrent verifies the current against the limit of the output.
+func (d *E3631A) checkCurrent(out Output, amps float64) error {
+	if err := checkOutput(out); err != nil {
+		return err
+	}
+	if amps < 0 {
+		return fmt.Errorf("%w: %s current must be positive, was %g A", ErrLimit, out, amps)
+	}
+	if limit := d.limits[out].amps; amps > limit {
+		return fmt.Errorf("%w: %s current %g A above %g A", ErrLimit, out, amps, limit)
+	}
+	return nil
+}
+
+// formatVoltage formats the voltage with the sign required by the output.
+func formatVoltage(out Output, volts float64) string {
+	if out == N25V {
+		volts = -math.Abs(volts)
+	}
+	return param.Float(volts)
+}
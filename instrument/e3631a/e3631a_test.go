@@ -0,0 +1,169 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package e3631a
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gotmc/prologix/internal/emulatortest"
+)
+
+// supply emulates the subset of the E3631A commands used by the driver.
+type supply struct {
+	selected string
+	volts    map[string]float64
+	amps     map[string]float64
+	output   bool
+	tracking bool
+	log      []string
+}
+
+func newSupply() *supply {
+	return &supply{
+		selected: "P6V",
+		volts:    map[string]float64{},
+		amps:     map[string]float64{},
+	}
+}
+
+func (s *supply) Handle(msg []byte) string {
+	cmd := string(msg)
+	s.log = append(s.log, cmd)
+	name, arg, _ := strings.Cut(cmd, " ")
+	switch name {
+	case "INST:SEL":
+		s.selected = arg
+	case "APPL":
+		f := strings.Split(arg, ",")
+		s.volts[f[0]], _ = strconv.ParseFloat(f[1], 64)
+		s.amps[f[0]], _ = strconv.ParseFloat(f[2], 64)
+	case "APPL?":
+		return fmt.Sprintf("\"%+E,%+E\"\n", s.volts[arg], s.amps[arg])
+	case "VOLT":
+		s.volts[s.selected], _ = strconv.ParseFloat(arg, 64)
+	case "CURR":
+		s.amps[s.selected], _ = strconv.ParseFloat(arg, 64)
+	case "MEAS:VOLT?":
+		return fmt.Sprintf("%+E\n", s.volts[arg])
+	case "MEAS:CURR?":
+		return fmt.Sprintf("%+E\n", s.amps[arg]/10)
+	case "OUTP":
+		s.output = arg == "ON"
+	case "OUTP?":
+		return map[bool]string{true: "1\n", false: "0\n"}[s.output]
+	case "OUTP:TRAC":
+		s.tracking = arg == "ON"
+	case "OUTP:TRAC?":
+		return map[bool]string{true: "1\n", false: "0\n"}[s.tracking]
+	}
+	return ""
+}
+
+func TestApplyAndMeasure(t *testing.T) {
+	s := newSupply()
+	c, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: s})
+	d := New(c)
+	if err := d.Apply(P6V, 4.1, 1.2); err != nil {
+		t.Fatalf("Apply error: %s", err)
+	}
+	if err := d.Apply(N25V, 12, 0.5); err != nil {
+		t.Fatalf("Apply error: %s", err)
+	}
+	if got := s.log[len(s.log)-1]; got != "APPL N25V,-12,0.5" {
+		t.Errorf("got %q; want negative N25V voltage", got)
+	}
+	volts, amps, err := d.Applied(P6V)
+	if err != nil {
+		t.Fatalf("Applied error: %s", err)
+	}
+	if volts != 4.1 || amps != 1.2 {
+		t.Errorf("Applied got %g V, %g A; want 4.1 V, 1.2 A", volts, amps)
+	}
+	if v, err := d.MeasureVoltage(N25V); err != nil || v != -12 {
+		t.Errorf("MeasureVoltage got %g, %v; want -12", v, err)
+	}
+	if a, err := d.MeasureCurrent(P6V); err != nil || a != 0.12 {
+		t.Errorf("MeasureCurrent got %g, %v; want 0.12", a, err)
+	}
+
+	// The SCPI overload value is returned as infinity.
+	s.volts["P25V"] = 9.9e37
+	if v, err := d.MeasureVoltage(P25V); err != nil || !math.IsInf(v, 1) {
+		t.Errorf("MeasureVoltage got %g, %v; want +Inf", v, err)
+	}
+}
+
+func TestSetVoltageAndCurrent(t *testing.T) {
+	s := newSupply()
+	c, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: s})
+	d := New(c)
+	if err := d.SetVoltage(P25V, 15); err != nil {
+		t.Fatalf("SetVoltage error: %s", err)
+	}
+	if err := d.SetCurrent(P25V, 0.25); err != nil {
+		t.Fatalf("SetCurrent error: %s", err)
+	}
+	if s.selected != "P25V" || s.volts["P25V"] != 15 || s.amps["P25V"] != 0.25 {
+		t.Errorf("got %s %g V %g A; want P25V 15 V 0.25 A", s.selected, s.volts["P25V"], s.amps["P25V"])
+	}
+}
+
+func TestLimits(t *testing.T) {
+	s := newSupply()
+	c, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: s})
+	d := New(c)
+	if err := d.SetVoltageLimit(P6V, 3.3); err != nil {
+		t.Fatalf("SetVoltageLimit error: %s", err)
+	}
+	if err := d.SetCurrentLimit(P25V, 0.1); err != nil {
+		t.Fatalf("SetCurrentLimit error: %s", err)
+	}
+	sent := len(s.log)
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"above voltage limit", d.Apply(P6V, 5, 1)},
+		{"above current limit", d.SetCurrent(P25V, 0.2)},
+		{"above rating", d.SetVoltage(P25V, 30)},
+		{"negative voltage", d.SetVoltage(P6V, -1)},
+	}
+	for _, test := range tests {
+		if !errors.Is(test.err, ErrLimit) {
+			t.Errorf("%s: got %v; want ErrLimit", test.name, test.err)
+		}
+	}
+	if len(s.log) != sent {
+		t.Errorf("sent %v despite exceeding limits", s.log[sent:])
+	}
+	if err := d.Apply(P6V, 3.3, 5); err != nil {
+		t.Errorf("Apply at limit error: %s", err)
+	}
+}
+
+func TestOutputAndTracking(t *testing.T) {
+	c, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: newSupply()})
+	d := New(c)
+	for _, enable := range []bool{true, false} {
+		if err := d.SetOutputEnabled(enable); err != nil {
+			t.Fatalf("SetOutputEnabled error: %s", err)
+		}
+		if got, err := d.OutputEnabled(); err != nil || got != enable {
+			t.Errorf("OutputEnabled got %t, %v; want %t", got, err, enable)
+		}
+		if err := d.SetTracking(enable); err != nil {
+			t.Fatalf("SetTracking error: %s", err)
+		}
+		if got, err := d.Tracking(); err != nil || got != enable {
+			t.Errorf("Tracking got %t, %v; want %t", got, err, enable)
+		}
+	}
+}
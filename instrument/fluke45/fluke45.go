@@ -0,0 +1,344 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package fluke45 controls the Fluke 45 dual display multimeter over GPIB using a
+prologix.Controller.
+
+The Fluke 45 predates SCPI and uses its own command set, such as `VDC` to
+select the DC voltage function and `MEAS1?` to take a measurement using the
+primary display. The Fluke 45 requires CR+LF terminated commands, which New
+configures on the prologix.Controller or prologix.Device.
+*/
+package fluke45
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/gotmc/prologix"
+)
+
+// overload is the value returned by the Fluke 45 when a measurement is out of
+// range, instead of the SCPI overload value 9.9E37 handled by
+// prologix.Controller.QueryFloat.
+const overload = 1e9
+
+// configurer is implemented by a prologix.Controller and a prologix.Device.
+type configurer interface {
+	SetGPIBTermination(term prologix.GpibTerm) error
+	SetReadAfterWrite(enable bool) error
+}
+
+// Function provides the type for the measurement functions.
+type Function int
+
+// Available measurement functions. Continuity and diode test are only
+// available on the primary display.
+const (
+	VDC Function = iota
+	VAC
+	VACDC
+	ADC
+	AAC
+	AACDC
+	Ohms
+	Frequency
+	Continuity
+	Diode
+)
+
+var functionCmds = map[Function]string{
+	VDC:        "VDC",
+	VAC:        "VAC",
+	VACDC:      "VACDC",
+	ADC:        "ADC",
+	AAC:        "AAC",
+	AACDC:      "AACDC",
+	Ohms:       "OHMS",
+	Frequency:  "FREQ",
+	Continuity: "CONT",
+	Diode:      "DIODE",
+}
+
+func (f Function) String() string {
+	return functionCmds[f]
+}
+
+// Rate provides the type for the measurement rates.
+type Rate int
+
+// Available measurement rates, which trade resolution for speed.
+const (
+	Slow Rate = iota
+	Medium
+	Fast
+)
+
+var rateCmds = map[Rate]string{
+	Slow:   "S",
+	Medium: "M",
+	Fast:   "F",
+}
+
+func (r Rate) String() string {
+	return rateCmds[r]
+}
+
+// Range provides the type for the measurement ranges. The meaning of ranges
+// 1 through 7 depends on the function and rate; see the Fluke 45 manual.
+type Range int
+
+// Available measurement ranges.
+const (
+	AutoRange Range = iota
+	Range1
+	Range2
+	Range3
+	Range4
+	Range5
+	Range6
+	Range7
+)
+
+// MinMax provides the type for the minimum and maximum modifiers.
+type MinMax int
+
+// Available minimum and maximum modifiers.
+const (
+	MinMaxOff MinMax = iota
+	Minimum
+	Maximum
+)
+
+// TriggerSource provides the type for the trigger configurations.
+type TriggerSource int
+
+// Available trigger configurations.
+const (
+	InternalTrigger            TriggerSource = iota + 1 // Continuous readings.
+	ExternalTrigger                                     // External trigger, no settling delay.
+	ExternalTriggerDelay                                // External trigger with settling delay.
+	ExternalTriggerNoRear                               // As ExternalTrigger with the rear panel trigger disabled.
+	ExternalTriggerDelayNoRear                          // As ExternalTriggerDelay with the rear panel trigger disabled.
+)
+
+// Fluke45 models a Fluke 45 multimeter.
+type Fluke45 struct {
+	bus prologix.Bus
+}
+
+// New creates a Fluke 45 driver that communicates using the given Bus, such as
+// a prologix.Controller or prologix.Device. The GPIB address must already be
+// selected, for instance by passing it to prologix.NewController. The GPIB
+// termination is set to CR+LF and read-after-write is enabled, so the Bus must
+// also provide the SetGPIBTermination and SetReadAfterWrite methods.
+func New(bus prologix.Bus) (*Fluke45, error) {
+	c, ok := bus.(configurer)
+	if !ok {
+		return nil, fmt.Errorf("fluke45: %T can't set the GPIB termination and read-after-write", bus)
+	}
+	if err := c.SetGPIBTermination(prologix.AppendCRLF); err != nil {
+		return nil, err
+	}
+	if err := c.SetReadAfterWrite(true); err != nil {
+		return nil, err
+	}
+	return &Fluke45{bus: bus}, nil
+}
+
+// SetFunction sets the measurement function of the primary display.
+func (d *Fluke45) SetFunction(f Function) error {
+	cmd, ok := functionCmds[f]
+	if !ok {
+		return fmt.Errorf("invalid function %d", f)
+	}
+	return d.bus.Command(cmd)
+}
+
+// Function queries the measurement function of the primary display.
+func (d *Fluke45) Function() (Function, error) {
+	return d.queryFunction("FUNC1?")
+}
+
+// SetSecondaryFunction sets the measurement function of the secondary
+// display.
+func (d *Fluke45) SetSecondaryFunction(f Function) error {
+	cmd, ok := functionCmds[f]
+	if !ok || f == Continuity || f == Diode {
+		return fmt.Errorf("invalid secondary function %s", f)
+	}
+	return d.bus.Command(cmd + "2")
+}
+
+// SecondaryFunction queries the measurement function of the secondary
+// display.
+func (d *Fluke45) SecondaryFunction() (Function, error) {
+	return d.queryFunction("FUNC2?")
+}
+
+// ClearSecondary turns off the secondary display.
+func (d *Fluke45) ClearSecondary() error {
+	return d.bus.Command("CLR2")
+}
+
+// SetRate sets the measurement rate.
+func (d *Fluke45) SetRate(r Rate) error {
+	cmd, ok := rateCmds[r]
+	if !ok {
+		return fmt.Errorf("invalid rate %d", r)
+	}
+	return d.bus.Command("RATE %s", cmd)
+}
+
+// Rate queries the measurement rate.
+func (d *Fluke45) Rate() (Rate, error) {
+	s, err := d.bus.QueryString("RATE?")
+	if err != nil {
+		return 0, err
+	}
+	for r, cmd := range rateCmds {
+		if s == cmd {
+			return r, nil
+		}
+	}
+	return 0, &prologix.UnexpectedResponseError{Command: "RATE?", Raw: []byte(s)}
+}
+
+// SetRange sets the range of the primary display or enables autoranging.
+func (d *Fluke45) SetRange(r Range) error {
+	if r == AutoRange {
+		return d.bus.Command("AUTO")
+	}
+	if r < Range1 || r > Range7 {
+		return fmt.Errorf("invalid range %d", r)
+	}
+	return d.bus.Command("RANGE %d", r)
+}
+
+// Range queries the range of the primary display, which is AutoRange if
+// autoranging is enabled.
+func (d *Fluke45) Range() (Range, error) {
+	auto, err := d.bus.QueryBool("AUTO?")
+	if err != nil || auto {
+		return AutoRange, err
+	}
+	r, err := d.bus.QueryInt("RANGE1?")
+	if err != nil {
+		return 0, err
+	}
+	if r < int(Range1) || r > int(Range7) {
+		return 0, &prologix.UnexpectedResponseError{Command: "RANGE1?", Raw: []byte(strconv.Itoa(r))}
+	}
+	return Range(r), nil
+}
+
+// Measure takes a measurement using the primary display. An out of range
+// measurement is returned as positive infinity.
+func (d *Fluke45) Measure() (float64, error) {
+	return d.queryValue("MEAS1?")
+}
+
+// MeasureSecondary takes a measurement using the secondary display. An out of
+// range measurement is returned as positive infinity.
+func (d *Fluke45) MeasureSecondary() (float64, error) {
+	return d.queryValue("MEAS2?")
+}
+
+// MeasureBoth takes a measurement using both the primary and secondary
+// displays. An out of range measurement is returned as positive infinity.
+func (d *Fluke45) MeasureBoth() (float64, float64, error) {
+	values, err := d.bus.QueryFloats("MEAS?")
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(values) != 2 {
+		return 0, 0, &prologix.UnexpectedResponseError{
+			Command: "MEAS?",
+			Err:     fmt.Errorf("got %d values; want 2", len(values)),
+		}
+	}
+	return overloaded(values[0]), overloaded(values[1]), nil
+}
+
+// Value returns the value shown on the primary display without taking a new
+// measurement, such as after a trigger. An out of range value is returned as
+// positive infinity.
+func (d *Fluke45) Value() (float64, error) {
+	return d.queryValue("VAL1?")
+}
+
+// SetRelative enables or disables the relative modifier, which uses the
+// present reading as the offset for subsequent readings.
+func (d *Fluke45) SetRelative(enable bool) error {
+	if enable {
+		return d.bus.Command("REL")
+	}
+	return d.bus.Command("RELCLR")
+}
+
+// SetRelativeOffset enables the relative modifier using the given offset.
+func (d *Fluke45) SetRelativeOffset(offset float64) error {
+	return d.bus.Command("RELSET %s", strconv.FormatFloat(offset, 'G', -1, 64))
+}
+
+// SetMinMax enables the minimum or maximum modifier, or disables both.
+func (d *Fluke45) SetMinMax(m MinMax) error {
+	switch m {
+	case MinMaxOff:
+		return d.bus.Command("MMCLR")
+	case Minimum:
+		return d.bus.Command("MIN")
+	case Maximum:
+		return d.bus.Command("MAX")
+	}
+	return fmt.Errorf("invalid min/max modifier %d", m)
+}
+
+// SetTrigger sets the trigger configuration.
+func (d *Fluke45) SetTrigger(src TriggerSource) error {
+	if src < InternalTrigger || src > ExternalTriggerDelayNoRear {
+		return fmt.Errorf("invalid trigger source %d", src)
+	}
+	return d.bus.Command("TRIGGER %d", src)
+}
+
+// Trigger triggers a measurement when an external trigger is configured.
+func (d *Fluke45) Trigger() error {
+	return d.bus.Command("*TRG")
+}
+
+// queryFunction queries the function of the primary or secondary display.
+func (d *Fluke45) queryFunction(cmd string) (Function, error) {
+	s, err := d.bus.QueryString(cmd)
+	if err != nil {
+		return 0, err
+	}
+	for f, name := range functionCmds {
+		if s == name {
+			return f, nil
+		}
+	}
+	return 0, &prologix.UnexpectedResponseError{Command: cmd, Raw: []byte(s)}
+}
+
+// queryValue queries a measurement, converting the overload value +1E+9 into
+// positive infinity.
+func (d *Fluke45) queryValue(cmd string) (float64, error) {
+	f, err := d.bus.QueryFloat(cmd)
+	if err != nil {
+		return 0, err
+	}
+	return overloaded(f), nil
+}
+
+// overloaded returns positive infinity for the overload value +1E+9.
+func overloaded(f float64) float64 {
+	if f == overload {
+		return math.Inf(1)
+	}
+	return f
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package fluke45
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/emulator"
+	"github.com/gotmc/prologix/internal/emulatortest"
+)
+
+// meter emulates the subset of the Fluke 45 commands used by the driver.
+type meter struct {
+	primary, secondary string
+	rate               string
+	rng                string
+	readings           map[string]string
+	log                []string
+}
+
+func newMeter() *meter {
+	return &meter{
+		primary:   "VDC",
+		secondary: "",
+		rate:      "M",
+		rng:       "AUTO",
+		readings:  map[string]string{"VDC": "+1.2345E+0", "FREQ": "+6.000E+1", "OHMS": "+1E+9"},
+	}
+}
+
+func (m *meter) Handle(msg []byte) string {
+	cmd := string(msg)
+	m.log = append(m.log, cmd)
+	name, arg, _ := strings.Cut(cmd, " ")
+	switch name {
+	case "VDC", "VAC", "OHMS", "FREQ", "CONT":
+		m.primary = name
+	case "VDC2", "FREQ2":
+		m.secondary = strings.TrimSuffix(name, "2")
+	case "FUNC1?":
+		return m.primary + "\n"
+	case "FUNC2?":
+		return m.secondary + "\n"
+	case "RATE":
+		m.rate = arg
+	case "RATE?":
+		return m.rate + "\n"
+	case "AUTO":
+		m.rng = "AUTO"
+	case "RANGE":
+		m.rng = arg
+	case "AUTO?":
+		if m.rng == "AUTO" {
+			return "1\n"
+		}
+		return "0\n"
+	case "RANGE1?":
+		return m.rng + "\n"
+	case "MEAS1?":
+		return m.readings[m.primary] + "\n"
+	case "MEAS?":
+		return m.readings[m.primary] + "," + m.readings[m.secondary] + "\n"
+	}
+	return ""
+}
+
+// mustNew creates the driver, which configures the bus.
+func mustNew(t *testing.T, bus prologix.Bus) *Fluke45 {
+	t.Helper()
+	d, err := New(bus)
+	if err != nil {
+		t.Fatalf("New error: %s", err)
+	}
+	return d
+}
+
+func TestNewConfiguresController(t *testing.T) {
+	c, adapter := emulatortest.NewController(t, 10, emulatortest.Instruments{10: newMeter()}, prologix.WithGPIBTermination(prologix.AppendLF))
+	mustNew(t, c)
+	if got := adapter.Setting("eos"); got != "0" {
+		t.Errorf("eos = %s; want 0 (CR+LF)", got)
+	}
+	if got := adapter.Setting("auto"); got != "1" {
+		t.Errorf("auto = %s; want 1", got)
+	}
+}
+
+func TestNewConfiguresDevice(t *testing.T) {
+	c, adapter := emulatortest.NewController(t, 5, emulatortest.Instruments{
+		5:  emulator.InstrumentFunc(func(string) string { return "" }),
+		10: newMeter(),
+	}, prologix.WithGPIBTermination(prologix.AppendLF))
+	other, err := c.Device(5)
+	if err != nil {
+		t.Fatalf("Device error: %s", err)
+	}
+	dev, err := c.Device(10)
+	if err != nil {
+		t.Fatalf("Device error: %s", err)
+	}
+	d := mustNew(t, dev)
+	// Switching to the other instrument and back restores the settings of the
+	// Fluke 45.
+	if err := other.Command("*CLS"); err != nil {
+		t.Fatalf("Command error: %s", err)
+	}
+	if got := adapter.Setting("eos"); got != "2" {
+		t.Errorf("eos of the other instrument = %s; want 2 (LF)", got)
+	}
+	v, err := d.Measure()
+	if err != nil || v != 1.2345 {
+		t.Errorf("Measure got %g, %v; want 1.2345", v, err)
+	}
+	if got := adapter.Setting("eos"); got != "0" {
+		t.Errorf("eos = %s; want 0 (CR+LF)", got)
+	}
+	if got := adapter.Setting("auto"); got != "1" {
+		t.Errorf("auto = %s; want 1", got)
+	}
+}
+
+func TestFunctionsAndMeasure(t *testing.T) {
+	m := newMeter()
+	c, _ := emulatortest.NewController(t, 10, emulatortest.Instruments{10: m}, prologix.WithGPIBTermination(prologix.AppendLF))
+	d := mustNew(t, c)
+	if err := d.SetFunction(Frequency); err != nil {
+		t.Fatalf("SetFunction error: %s", err)
+	}
+	if f, err := d.Function(); err != nil || f != Frequency {
+		t.Errorf("Function got %s, %v; want FREQ", f, err)
+	}
+	if err := d.SetSecondaryFunction(VDC); err != nil {
+		t.Fatalf("SetSecondaryFunction error: %s", err)
+	}
+	if f, err := d.SecondaryFunction(); err != nil || f != VDC {
+		t.Errorf("SecondaryFunction got %s, %v; want VDC", f, err)
+	}
+	if err := d.SetSecondaryFunction(Diode); err == nil {
+		t.Error("SetSecondaryFunction(Diode) succeeded; want error")
+	}
+	primary, secondary, err := d.MeasureBoth()
+	if err != nil || primary != 60 || secondary != 1.2345 {
+		t.Errorf("MeasureBoth got %g, %g, %v; want 60, 1.2345", primary, secondary, err)
+	}
+	if err := d.SetFunction(Ohms); err != nil {
+		t.Fatalf("SetFunction error: %s", err)
+	}
+	if v, err := d.Measure(); err != nil || !math.IsInf(v, 1) {
+		t.Errorf("Measure of overload got %g, %v; want +Inf", v, err)
+	}
+	// The SCPI overload value is returned as infinity too.
+	m.readings["OHMS"] = "-9.9E+37"
+	if v, err := d.Measure(); err != nil || !math.IsInf(v, -1) {
+		t.Errorf("Measure of SCPI overload got %g, %v; want -Inf", v, err)
+	}
+	if err := d.SetFunction(Continuity); err != nil {
+		t.Fatalf("SetFunction error: %s", err)
+	}
+	_, err = d.Measure()
+	if !errors.Is(err, prologix.ErrUnexpectedResponse) {
+		t.Errorf("Measure of empty response got %v; want ErrUnexpectedResponse", err)
+	}
+}
+
+func TestRateAndRange(t *testing.T) {
+	m := newMeter()
+	c, _ := emulatortest.NewController(t, 10, emulatortest.Instruments{10: m}, prologix.WithGPIBTermination(prologix.AppendLF))
+	d := mustNew(t, c)
+	if err := d.SetRate(Fast); err != nil {
+		t.Fatalf("SetRate error: %s", err)
+	}
+	if r, err := d.Rate(); err != nil || r != Fast {
+		t.Errorf("Rate got %s, %v; want F", r, err)
+	}
+	if r, err := d.Range(); err != nil || r != AutoRange {
+		t.Errorf("Range got %d, %v; want AutoRange", r, err)
+	}
+	if err := d.SetRange(Range3); err != nil {
+		t.Fatalf("SetRange error: %s", err)
+	}
+	if r, err := d.Range(); err != nil || r != Range3 {
+		t.Errorf("Range got %d, %v; want 3", r, err)
+	}
+	sent := len(m.log)
+	if err := d.SetRange(Range7 + 1); err == nil {
+		t.Error("SetRange(8) succeeded; want error")
+	}
+	if err := d.SetTrigger(0); err == nil {
+		t.Error("SetTrigger(0) succeeded; want error")
+	}
+	if len(m.log) != sent {
+		t.Errorf("sent %v despite invalid settings", m.log[sent:])
+	}
+}
+
+func TestModifiers(t *testing.T) {
+	m := newMeter()
+	c, _ := emulatortest.NewController(t, 10, emulatortest.Instruments{10: m}, prologix.WithGPIBTermination(prologix.AppendLF))
+	d := mustNew(t, c)
+	steps := []struct {
+		call func() error
+		want string
+	}{
+		{func() error { return d.SetRelative(true) }, "REL"},
+		{func() error { return d.SetRelativeOffset(-0.25) }, "RELSET -0.25"},
+		{func() error { return d.SetRelative(false) }, "RELCLR"},
+		{func() error { return d.SetMinMax(Maximum) }, "MAX"},
+		{func() error { return d.SetMinMax(MinMaxOff) }, "MMCLR"},
+		{func() error { return d.SetTrigger(ExternalTriggerDelay) }, "TRIGGER 3"},
+		{d.Trigger, "*TRG"},
+	}
+	for _, step := range steps {
+		if err := step.call(); err != nil {
+			t.Fatalf("%s error: %s", step.want, err)
+		}
+		if got := m.log[len(m.log)-1]; got != step.want {
+			t.Errorf("sent %q; want %q", got, step.want)
+		}
+	}
+}
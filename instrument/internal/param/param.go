@@ -0,0 +1,24 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+// Package param formats the parameters of the commands sent by the instrument
+// drivers.
+package param
+
+import "strconv"
+
+// Float formats a float without an exponent when possible, such as 0.001
+// instead of 1E-03.
+func Float(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// OnOff formats a bool as ON or OFF.
+func OnOff(enable bool) string {
+	if enable {
+		return "ON"
+	}
+	return "OFF"
+}
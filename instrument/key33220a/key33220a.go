@@ -0,0 +1,412 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package key33220a controls the Keysight (Agilent) 33220A function/arbitrary
+waveform generator over GPIB using a prologix.Controller.
+
+Arbitrary waveforms are uploaded using `DATA:DAC` with an IEEE 488.2 definite
+length binary block. Since the Prologix removes unescaped CR, LF, ESC, and `+`
+characters, the block is sent using the escaping WriteBinary method of the
+prologix.Controller.
+*/
+package key33220a
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/instrument/internal/param"
+	"github.com/gotmc/prologix/scpi"
+)
+
+// Limits of the arbitrary waveform memory of the 33220A.
+const (
+	MaxDAC    = 8191
+	MaxPoints = 65536
+)
+
+// Volatile is the name of the volatile memory holding the arbitrary waveform
+// most recently uploaded.
+const Volatile = "VOLATILE"
+
+// copyTimeout is how long to wait for the 33220A to copy an arbitrary waveform
+// into non-volatile memory.
+const copyTimeout = 10 * time.Second
+
+// ErrWaveform is returned when an arbitrary waveform can't be uploaded to the
+// 33220A.
+var ErrWaveform = errors.New("key33220a: invalid arbitrary waveform")
+
+// Shape provides the type for the output waveform shapes.
+type Shape int
+
+// Available waveform shapes.
+const (
+	Sine Shape = iota
+	Square
+	Ramp
+	Pulse
+	Noise
+	DC
+	User
+)
+
+var shapeCmds = map[Shape]string{
+	Sine:   "SIN",
+	Square: "SQU",
+	Ramp:   "RAMP",
+	Pulse:  "PULS",
+	Noise:  "NOIS",
+	DC:     "DC",
+	User:   "USER",
+}
+
+func (s Shape) String() string {
+	return shapeCmds[s]
+}
+
+// BurstMode provides the type for the burst modes.
+type BurstMode int
+
+// Available burst modes.
+const (
+	TriggeredBurst BurstMode = iota
+	GatedBurst
+)
+
+// Burst configures burst mode.
+type Burst struct {
+	Mode   BurstMode
+	Cycles int     // Number of cycles per burst in triggered mode.
+	Period float64 // Burst period in seconds when internally triggered.
+	Phase  float64 // Starting phase in degrees.
+}
+
+// Spacing provides the type for the sweep spacing.
+type Spacing int
+
+// Available sweep spacings.
+const (
+	Linear Spacing = iota
+	Logarithmic
+)
+
+// Sweep configures frequency sweeps.
+type Sweep struct {
+	Start   float64 // Start frequency in Hz.
+	Stop    float64 // Stop frequency in Hz.
+	Time    float64 // Sweep time in seconds.
+	Spacing Spacing
+}
+
+// ModulationType provides the type for the modulation types.
+type ModulationType int
+
+// Available modulation types.
+const (
+	AM ModulationType = iota
+	FM
+	PM
+	FSK
+	PWM
+)
+
+var modulationCmds = map[ModulationType]string{
+	AM:  "AM",
+	FM:  "FM",
+	PM:  "PM",
+	FSK: "FSK",
+	PWM: "PWM",
+}
+
+// deviationCmds sets the amount of modulation of each type.
+var deviationCmds = map[ModulationType]string{
+	AM:  "AM:DEPT",
+	FM:  "FM:DEV",
+	PM:  "PM:DEV",
+	FSK: "FSK:FREQ",
+	PWM: "PWM:DEV",
+}
+
+func (m ModulationType) String() string {
+	return modulationCmds[m]
+}
+
+// Modulation configures modulation using the internal modulating source.
+type Modulation struct {
+	Type ModulationType
+	// Frequency is the modulating frequency in Hz, or the rate at which the
+	// output shifts between frequencies in FSK.
+	Frequency float64
+	// Deviation is the depth in percent for AM, the frequency deviation in Hz
+	// for FM, the phase deviation in degrees for PM, the hop frequency in Hz
+	// for FSK, or the pulse width deviation in seconds for PWM.
+	Deviation float64
+}
+
+// Key33220A models a Keysight 33220A function generator.
+type Key33220A struct {
+	bus prologix.Bus
+}
+
+// New creates a 33220A driver that communicates using the given Bus, such as a
+// prologix.Controller or prologix.Device. The GPIB address must already be
+// selected, for instance by passing it to prologix.NewController.
+func New(bus prologix.Bus) *Key33220A {
+	return &Key33220A{bus: bus}
+}
+
+// Apply sets the shape, frequency in Hz, amplitude in Vpp, and DC offset in V
+// in a single command.
+func (d *Key33220A) Apply(s Shape, freq, amp, offset float64) error {
+	cmd, ok := shapeCmds[s]
+	if !ok {
+		return fmt.Errorf("invalid shape %d", s)
+	}
+	return d.bus.Command("APPL:%s %s,%s,%s", cmd, param.Float(freq), param.Float(amp), param.Float(offset))
+}
+
+// SetShape sets the output waveform shape.
+func (d *Key33220A) SetShape(s Shape) error {
+	cmd, ok := shapeCmds[s]
+	if !ok {
+		return fmt.Errorf("invalid shape %d", s)
+	}
+	return d.bus.Command("FUNC %s", cmd)
+}
+
+// Shape queries the output waveform shape.
+func (d *Key33220A) Shape() (Shape, error) {
+	s, err := d.bus.QueryString("FUNC?")
+	if err != nil {
+		return 0, err
+	}
+	for shape, cmd := range shapeCmds {
+		if s == cmd {
+			return shape, nil
+		}
+	}
+	return 0, &prologix.UnexpectedResponseError{Command: "FUNC?", Raw: []byte(s)}
+}
+
+// SetFrequency sets the output frequency in Hz.
+func (d *Key33220A) SetFrequency(hz float64) error {
+	return d.bus.Command("FREQ %s", param.Float(hz))
+}
+
+// Frequency queries the output frequency in Hz.
+func (d *Key33220A) Frequency() (float64, error) {
+	return d.bus.QueryFloat("FREQ?")
+}
+
+// SetAmplitude sets the output amplitude in Vpp.
+func (d *Key33220A) SetAmplitude(vpp float64) error {
+	return d.bus.Command("VOLT %s", param.Float(vpp))
+}
+
+// Amplitude queries the output amplitude in Vpp.
+func (d *Key33220A) Amplitude() (float64, error) {
+	return d.bus.QueryFloat("VOLT?")
+}
+
+// SetOffset sets the DC offset in V.
+func (d *Key33220A) SetOffset(volts float64) error {
+	return d.bus.Command("VOLT:OFFS %s", param.Float(volts))
+}
+
+// Offset queries the DC offset in V.
+func (d *Key33220A) Offset() (float64, error) {
+	return d.bus.QueryFloat("VOLT:OFFS?")
+}
+
+// SetOutputLoad sets the expected load in ohms, which scales the displayed
+// amplitude and offset. Use math.Inf(1) for a high impedance load.
+func (d *Key33220A) SetOutputLoad(ohms float64) error {
+	if math.IsInf(ohms, 1) {
+		return d.bus.Command("OUTP:LOAD INF")
+	}
+	return d.bus.Command("OUTP:LOAD %s", param.Float(ohms))
+}
+
+// OutputLoad queries the expected load in ohms, which is positive infinity
+// for a high impedance load, reported by the 33220A as the SCPI overload
+// value 9.9E37.
+func (d *Key33220A) OutputLoad() (float64, error) {
+	return d.bus.QueryFloat("OUTP:LOAD?")
+}
+
+// SetOutputEnabled enables or disables the output.
+func (d *Key33220A) SetOutputEnabled(enable bool) error {
+	return d.bus.Command("OUTP %s", param.OnOff(enable))
+}
+
+// OutputEnabled reports whether the output is enabled.
+func (d *Key33220A) OutputEnabled() (bool, error) {
+	return d.bus.QueryBool("OUTP?")
+}
+
+// SetBurst configures burst mode without enabling it.
+func (d *Key33220A) SetBurst(b Burst) error {
+	cmds := []string{"BURS:MODE TRIG"}
+	switch b.Mode {
+	case TriggeredBurst:
+		if b.Cycles < 1 {
+			return fmt.Errorf("invalid burst count %d", b.Cycles)
+		}
+		cmds = append(cmds,
+			fmt.Sprintf("BURS:NCYC %d", b.Cycles),
+			fmt.Sprintf("BURS:INT:PER %s", param.Float(b.Period)),
+		)
+	case GatedBurst:
+		cmds[0] = "BURS:MODE GAT"
+	default:
+		return fmt.Errorf("invalid burst mode %d", b.Mode)
+	}
+	cmds = append(cmds, fmt.Sprintf("BURS:PHAS %s", param.Float(b.Phase)))
+	return d.commands(cmds)
+}
+
+// SetBurstEnabled enables or disables burst mode.
+func (d *Key33220A) SetBurstEnabled(enable bool) error {
+	return d.bus.Command("BURS:STAT %s", param.OnOff(enable))
+}
+
+// SetSweep configures frequency sweeps without enabling them.
+func (d *Key33220A) SetSweep(s Sweep) error {
+	spacing := "LIN"
+	switch s.Spacing {
+	case Linear:
+	case Logarithmic:
+		spacing = "LOG"
+	default:
+		return fmt.Errorf("invalid sweep spacing %d", s.Spacing)
+	}
+	return d.commands([]string{
+		fmt.Sprintf("FREQ:STAR %s", param.Float(s.Start)),
+		fmt.Sprintf("FREQ:STOP %s", param.Float(s.Stop)),
+		fmt.Sprintf("SWE:TIME %s", param.Float(s.Time)),
+		fmt.Sprintf("SWE:SPAC %s", spacing),
+	})
+}
+
+// SetSweepEnabled enables or disables frequency sweeps.
+func (d *Key33220A) SetSweepEnabled(enable bool) error {
+	return d.bus.Command("SWE:STAT %s", param.OnOff(enable))
+}
+
+// SetModulation configures and enables modulation using the internal
+// modulating source.
+func (d *Key33220A) SetModulation(m Modulation) error {
+	name, ok := modulationCmds[m.Type]
+	if !ok {
+		return fmt.Errorf("invalid modulation type %d", m.Type)
+	}
+	freqCmd := fmt.Sprintf("%s:INT:FREQ %s", name, param.Float(m.Frequency))
+	if m.Type == FSK {
+		freqCmd = fmt.Sprintf("FSK:INT:RATE %s", param.Float(m.Frequency))
+	}
+	return d.commands([]string{
+		fmt.Sprintf("%s:SOUR INT", name),
+		freqCmd,
+		fmt.Sprintf("%s %s", deviationCmds[m.Type], param.Float(m.Deviation)),
+		fmt.Sprintf("%s:STAT ON", name),
+	})
+}
+
+// DisableModulation disables the given type of modulation.
+func (d *Key33220A) DisableModulation(t ModulationType) error {
+	name, ok := modulationCmds[t]
+	if !ok {
+		return fmt.Errorf("invalid modulation type %d", t)
+	}
+	return d.bus.Command("%s:STAT OFF", name)
+}
+
+// DACValues converts samples in the range -1 to +1 into the DAC values used by
+// UploadArbitrary.
+func DACValues(samples []float64) ([]int16, error) {
+	dac := make([]int16, len(samples))
+	for i, s := range samples {
+		if math.IsNaN(s) || s < -1 || s > 1 {
+			return nil, fmt.Errorf("%w: sample %d is %g, outside -1 to +1", ErrWaveform, i, s)
+		}
+		dac[i] = int16(math.Round(s * MaxDAC))
+	}
+	return dac, nil
+}
+
+// validName matches the names allowed for arbitrary waveforms.
+var validName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,11}$`)
+
+// UploadArbitrary uploads an arbitrary waveform of 1 to 65536 DAC values in
+// the range -8191 to +8191 to volatile memory using a binary block. Unless
+// name is Volatile, the waveform is then copied to non-volatile memory with the
+// given name, which must start with a letter and have at most 12 letters,
+// digits, or underscores.
+func (d *Key33220A) UploadArbitrary(name string, dac []int16) error {
+	name = strings.ToUpper(name)
+	if !validName.MatchString(name) {
+		return fmt.Errorf("%w: invalid name %q", ErrWaveform, name)
+	}
+	if len(dac) < 1 || len(dac) > MaxPoints {
+		return fmt.Errorf("%w: %d points, must be 1 to %d", ErrWaveform, len(dac), MaxPoints)
+	}
+	for i, v := range dac {
+		if v < -MaxDAC || v > MaxDAC {
+			return fmt.Errorf("%w: point %d is %d, outside ±%d", ErrWaveform, i, v, MaxDAC)
+		}
+	}
+	if err := d.bus.Command("FORM:BORD NORM"); err != nil {
+		return err
+	}
+	if _, err := d.bus.WriteBinary(dacBlock(dac)); err != nil {
+		return err
+	}
+	if name == Volatile {
+		return nil
+	}
+	if err := d.bus.Command("DATA:COPY %s, VOLATILE", name); err != nil {
+		return err
+	}
+	return scpi.New(d.bus).WaitOPC(copyTimeout)
+}
+
+// SelectArbitrary selects the arbitrary waveform with the given name and sets
+// the shape to User.
+func (d *Key33220A) SelectArbitrary(name string) error {
+	if err := d.bus.Command("FUNC:USER %s", strings.ToUpper(name)); err != nil {
+		return err
+	}
+	return d.SetShape(User)
+}
+
+// dacBlock returns the `DATA:DAC` command with the DAC values as a definite
+// length binary block of big-endian 16-bit integers.
+func dacBlock(dac []int16) []byte {
+	n := strconv.Itoa(2 * len(dac))
+	buf := []byte(fmt.Sprintf("DATA:DAC VOLATILE, #%d%s", len(n), n))
+	for _, v := range dac {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(v))
+	}
+	return buf
+}
+
+// commands sends the commands in order, stopping at the first error.
+func (d *Key33220A) commands(cmds []string) error {
+	for _, cmd := range cmds {
+		if err := d.bus.Command(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
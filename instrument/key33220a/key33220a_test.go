@@ -0,0 +1,194 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package key33220a
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gotmc/prologix/internal/emulatortest"
+)
+
+// generator emulates the subset of the 33220A commands used by the driver.
+type generator struct {
+	settings map[string]string
+	volatile []int16
+	stored   map[string][]int16
+	log      []string
+}
+
+func newGenerator() *generator {
+	return &generator{settings: map[string]string{}, stored: map[string][]int16{}}
+}
+
+func (g *generator) Handle(msg []byte) string {
+	if block, ok := bytes.CutPrefix(msg, []byte("DATA:DAC VOLATILE, #")); ok {
+		g.log = append(g.log, "DATA:DAC")
+		digits := int(block[0] - '0')
+		n, _ := strconv.Atoi(string(block[1 : 1+digits]))
+		data := block[1+digits:]
+		if len(data) != n {
+			return ""
+		}
+		g.volatile = make([]int16, n/2)
+		for i := range g.volatile {
+			g.volatile[i] = int16(binary.BigEndian.Uint16(data[2*i:]))
+		}
+		return ""
+	}
+	cmd := string(msg)
+	g.log = append(g.log, cmd)
+	name, arg, _ := strings.Cut(cmd, " ")
+	switch {
+	case name == "DATA:COPY":
+		dest, _, _ := strings.Cut(arg, ",")
+		g.stored[dest] = g.volatile
+	case name == "*OPC?":
+		return "1\n"
+	case strings.HasSuffix(name, "?"):
+		return g.settings[strings.TrimSuffix(name, "?")] + "\n"
+	default:
+		g.settings[name] = arg
+	}
+	return ""
+}
+
+func TestSettings(t *testing.T) {
+	g := newGenerator()
+	c, _ := emulatortest.NewController(t, 10, emulatortest.Instruments{10: g})
+	d := New(c)
+	if err := d.SetShape(Square); err != nil {
+		t.Fatalf("SetShape error: %s", err)
+	}
+	if s, err := d.Shape(); err != nil || s != Square {
+		t.Errorf("Shape got %s, %v; want SQU", s, err)
+	}
+	if err := d.SetFrequency(1.5e3); err != nil {
+		t.Fatalf("SetFrequency error: %s", err)
+	}
+	if f, err := d.Frequency(); err != nil || f != 1500 {
+		t.Errorf("Frequency got %g, %v; want 1500", f, err)
+	}
+	if err := d.SetOutputLoad(math.Inf(1)); err != nil {
+		t.Fatalf("SetOutputLoad error: %s", err)
+	}
+	g.settings["OUTP:LOAD"] = "+9.900000000000000E+37"
+	if ohms, err := d.OutputLoad(); err != nil || !math.IsInf(ohms, 1) {
+		t.Errorf("OutputLoad got %g, %v; want +Inf", ohms, err)
+	}
+	if err := d.Apply(Sine, 100, 0.5, 0); err != nil {
+		t.Fatalf("Apply error: %s", err)
+	}
+	if got := g.settings["APPL:SIN"]; got != "100,0.5,0" {
+		t.Errorf("APPL:SIN got %q; want 100,0.5,0", got)
+	}
+}
+
+func TestBurstSweepAndModulation(t *testing.T) {
+	g := newGenerator()
+	c, _ := emulatortest.NewController(t, 10, emulatortest.Instruments{10: g})
+	d := New(c)
+	if err := d.SetBurst(Burst{Mode: TriggeredBurst, Cycles: 40, Period: 0.6}); err != nil {
+		t.Fatalf("SetBurst error: %s", err)
+	}
+	if err := d.SetSweep(Sweep{Start: 100, Stop: 1e3, Time: 2, Spacing: Logarithmic}); err != nil {
+		t.Fatalf("SetSweep error: %s", err)
+	}
+	if err := d.SetModulation(Modulation{Type: FSK, Frequency: 10, Deviation: 200}); err != nil {
+		t.Fatalf("SetModulation error: %s", err)
+	}
+	want := map[string]string{
+		"BURS:MODE":    "TRIG",
+		"BURS:NCYC":    "40",
+		"BURS:INT:PER": "0.6",
+		"BURS:PHAS":    "0",
+		"FREQ:STAR":    "100",
+		"FREQ:STOP":    "1000",
+		"SWE:TIME":     "2",
+		"SWE:SPAC":     "LOG",
+		"FSK:SOUR":     "INT",
+		"FSK:INT:RATE": "10",
+		"FSK:FREQ":     "200",
+		"FSK:STAT":     "ON",
+	}
+	for cmd, arg := range want {
+		if got := g.settings[cmd]; got != arg {
+			t.Errorf("%s got %q; want %q", cmd, got, arg)
+		}
+	}
+	if err := d.SetBurst(Burst{Mode: TriggeredBurst}); err == nil {
+		t.Error("SetBurst with zero cycles succeeded; want error")
+	}
+}
+
+func TestUploadArbitrary(t *testing.T) {
+	g := newGenerator()
+	c, _ := emulatortest.NewController(t, 10, emulatortest.Instruments{10: g})
+	d := New(c)
+	// The big-endian bytes of these values include CR, LF, ESC, and `+`,
+	// which must be escaped to pass through the Prologix.
+	dac := []int16{0x0a0d, 0x1b2b, -1, 10, MaxDAC, -MaxDAC}
+	if err := d.UploadArbitrary("pulse_1", dac); err != nil {
+		t.Fatalf("UploadArbitrary error: %s", err)
+	}
+	got := g.stored["PULSE_1"]
+	if len(got) != len(dac) {
+		t.Fatalf("stored %v; want %v", got, dac)
+	}
+	for i := range dac {
+		if got[i] != dac[i] {
+			t.Errorf("point %d got %d; want %d", i, got[i], dac[i])
+		}
+	}
+	if err := d.SelectArbitrary("pulse_1"); err != nil {
+		t.Fatalf("SelectArbitrary error: %s", err)
+	}
+	if g.settings["FUNC:USER"] != "PULSE_1" || g.settings["FUNC"] != "USER" {
+		t.Errorf("got FUNC:USER %s, FUNC %s; want PULSE_1, USER", g.settings["FUNC:USER"], g.settings["FUNC"])
+	}
+
+	sent := len(g.log)
+	tests := []struct {
+		name string
+		wf   string
+		dac  []int16
+	}{
+		{"no points", Volatile, nil},
+		{"too many points", Volatile, make([]int16, MaxPoints+1)},
+		{"out of range", Volatile, []int16{MaxDAC + 1}},
+		{"bad name", "1PULSE", []int16{0}},
+		{"long name", "ABCDEFGHIJKLM", []int16{0}},
+	}
+	for _, test := range tests {
+		if err := d.UploadArbitrary(test.wf, test.dac); !errors.Is(err, ErrWaveform) {
+			t.Errorf("%s: got %v; want ErrWaveform", test.name, err)
+		}
+	}
+	if len(g.log) != sent {
+		t.Errorf("sent %v despite invalid waveforms", g.log[sent:])
+	}
+}
+
+func TestDACValues(t *testing.T) {
+	got, err := DACValues([]float64{-1, 0, 0.5, 1})
+	if err != nil {
+		t.Fatalf("DACValues error: %s", err)
+	}
+	want := []int16{-MaxDAC, 0, 4096, MaxDAC}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("value %d got %d; want %d", i, got[i], want[i])
+		}
+	}
+	if _, err := DACValues([]float64{1.1}); !errors.Is(err, ErrWaveform) {
+		t.Errorf("got %v; want ErrWaveform", err)
+	}
+}
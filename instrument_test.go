@@ -0,0 +1,168 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gotmc/prologix/internal/emulator"
+	"github.com/gotmc/query"
+)
+
+// dmm is a minimal driver written the way ivi drivers are, using only the
+// Instrument interface and the query package.
+type dmm struct {
+	inst Instrument
+}
+
+func (d dmm) measure() (float64, error) {
+	if err := d.inst.Command("CONF:VOLT:DC"); err != nil {
+		return 0, err
+	}
+	return query.Float64(d.inst, "READ?")
+}
+
+// voltmeter always reads the given voltage.
+func voltmeter(volts float64) emulator.InstrumentFunc {
+	return func(cmd string) string {
+		if cmd == "READ?" {
+			return fmt.Sprintf("%+E\n", volts)
+		}
+		return ""
+	}
+}
+
+func TestDevicesShareController(t *testing.T) {
+	adapter := emulator.New()
+	adapter.Attach(5, voltmeter(1.5))
+	adapter.Attach(10, voltmeter(-2.25))
+	c, err := NewController(adapter, 5, false)
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	want := map[int]float64{5: 1.5, 10: -2.25}
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(want))
+	for addr, volts := range want {
+		dev, err := c.Device(addr)
+		if err != nil {
+			t.Fatalf("Device error: %s", err)
+		}
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func(d dmm, volts float64) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					got, err := d.measure()
+					if err != nil {
+						errs <- err
+						return
+					}
+					if got != volts {
+						errs <- fmt.Errorf("got %g V; want %g V", got, volts)
+						return
+					}
+				}
+			}(dmm{dev}, volts)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestDeviceInvalidAddress(t *testing.T) {
+	c, err := NewController(emulator.New(), 5, false)
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	if _, err := c.Device(31); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("got %v; want ErrInvalidAddress", err)
+	}
+}
+
+func TestDeviceSettings(t *testing.T) {
+	adapter := emulator.New()
+	adapter.Attach(5, voltmeter(1.5))
+	adapter.Attach(10, voltmeter(-2.25))
+	c, err := NewController(adapter, 5, false)
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	psu, err := c.Device(5)
+	if err != nil {
+		t.Fatalf("Device error: %s", err)
+	}
+	scanner, err := c.Device(10,
+		WithDeviceSecondaryAddress(96),
+		WithDeviceGPIBTermination(AppendLF),
+		WithDeviceAssertEOI(false),
+		WithDeviceReadTimeout(2000),
+	)
+	if err != nil {
+		t.Fatalf("Device error: %s", err)
+	}
+
+	tests := []struct {
+		dev  *Device
+		want map[string]string
+	}{
+		{scanner, map[string]string{"addr": "10 96", "eos": "2", "eoi": "0", "read_tmo_ms": "2000"}},
+		{psu, map[string]string{"addr": "5", "eos": "0", "eoi": "1", "read_tmo_ms": "500"}},
+	}
+	for _, tc := range tests {
+		if _, err := tc.dev.Query("READ?"); err != nil {
+			t.Fatalf("Query error: %s", err)
+		}
+		for name, want := range tc.want {
+			if got := adapter.Setting(name); got != want {
+				t.Errorf("address %d: %s = %q; want %q", tc.dev.Address(), name, got, want)
+			}
+		}
+	}
+
+	if _, err := c.Device(10, WithDeviceSecondaryAddress(95)); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("got %v; want ErrInvalidAddress", err)
+	}
+	if _, err := c.Device(10, WithDeviceReadTimeout(0)); err == nil {
+		t.Error("got nil error for read timeout of 0 ms")
+	}
+}
+
+func TestDeviceDisablesSaveConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy SaveConfig
+		want   string
+	}{
+		{"enable", SaveConfigEnable, "0"},
+		{"disable", SaveConfigDisable, "0"},
+		{"unchanged", SaveConfigUnchanged, "1"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			adapter := emulator.New()
+			c, err := NewController(adapter, 5, false, WithSaveConfig(tc.policy))
+			if err != nil {
+				t.Fatalf("NewController error: %s", err)
+			}
+			if _, err := c.Device(10); err != nil {
+				t.Fatalf("Device error: %s", err)
+			}
+			if got := adapter.Setting("savecfg"); got != tc.want {
+				t.Errorf("savecfg = %q; want %q", got, tc.want)
+			}
+			if c.Config().SaveConfig {
+				t.Error("Config().SaveConfig = true; want false")
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package emulator emulates a Prologix GPIB-USB controller and the instruments
+attached to its GPIB bus, so that the prologix package and the instrument
+drivers can be tested without hardware.
+
+The Adapter implements io.ReadWriter. Data written to the Adapter is parsed
+the same way the Prologix controller does: unescaped CR and LF characters
+terminate a message, ESC escapes the following character, and messages
+starting with an unescaped `++` are Prologix commands. All other messages are
+sent to the instrument at the current GPIB address. Reading from the Adapter
+when no data is available returns os.ErrDeadlineExceeded, which emulates a
+read timeout.
+*/
+package emulator
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the response to the `++ver` command.
+const Version = "Prologix GPIB-USB Controller version 6.101"
+
+const esc = 27
+
+// Instrument handles the messages sent to an emulated GPIB instrument. Handle
+// receives each message without its terminator and returns the response the
+// instrument queues for when it is next addressed to talk. Return an empty
+// string if the message doesn't produce a response.
+type Instrument interface {
+	Handle(msg []byte) string
+}
+
+// InstrumentFunc adapts an ordinary function to the Instrument interface.
+type InstrumentFunc func(msg string) string
+
+// Handle calls f(string(msg)).
+func (f InstrumentFunc) Handle(msg []byte) string { return f(string(msg)) }
+
+// Clearer is implemented by instruments that respond to the Selected Device
+// Clear (SDC) message.
+type Clearer interface {
+	Clear()
+}
+
+// Poller is implemented by instruments that respond to a serial poll.
+type Poller interface {
+	StatusByte() byte
+}
+
+// Triggerer is implemented by instruments that respond to the Group Execute
+// Trigger (GET) message.
+type Triggerer interface {
+	Trigger()
+}
+
+// Talker is implemented by instruments that produce their response only when
+// addressed to talk, such as a slow instrument that isn't ready yet. Talk
+// returns an empty string if the instrument doesn't respond before the
+// Prologix read timeout.
+type Talker interface {
+	Talk() string
+}
+
+// Adapter emulates a Prologix GPIB controller in controller mode.
+type Adapter struct {
+	mu          sync.Mutex
+	instruments map[int]Instrument
+	pending     map[int]string // responses queued by each instrument
+	in          []byte         // partial message received from the host
+	escaped     bool
+	out         bytes.Buffer // data waiting to be read by the host
+	commands    []string
+	srq         bool
+	local       map[int]bool
+
+	settings map[string]string
+}
+
+// New creates an emulated Prologix controller with its power-on settings.
+func New() *Adapter {
+	return &Adapter{
+		instruments: make(map[int]Instrument),
+		pending:     make(map[int]string),
+		local:       make(map[int]bool),
+		settings: map[string]string{
+			"addr":        "0",
+			"auto":        "0",
+			"eoi":         "1",
+			"eos":         "0",
+			"eot_enable":  "0",
+			"eot_char":    "0",
+			"mode":        "1",
+			"read_tmo_ms": "500",
+			"savecfg":     "1",
+			"verbose":     "0",
+		},
+	}
+}
+
+// Attach connects the instrument to the emulated GPIB bus at the given
+// primary address.
+func (a *Adapter) Attach(addr int, inst Instrument) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.instruments[addr] = inst
+}
+
+// Commands returns the Prologix `++` commands received so far.
+func (a *Adapter) Commands() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.commands...)
+}
+
+// Setting returns the current value of the given Prologix setting, such as
+// "addr" or "read_tmo_ms".
+func (a *Adapter) Setting(name string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.settings[name]
+}
+
+// SetSetting changes a Prologix setting behind the back of the host, for
+// instance to emulate a power cycle of the Prologix controller.
+func (a *Adapter) SetSetting(name, value string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.settings[name] = value
+}
+
+// SetSRQ sets the state of the emulated GPIB SRQ line.
+func (a *Adapter) SetSRQ(asserted bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.srq = asserted
+}
+
+// Local reports whether the instrument at the given address was last put into
+// local mode using `++loc`.
+func (a *Adapter) Local(addr int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.local[addr]
+}
+
+// Write parses the data sent by the host.
+func (a *Adapter) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, b := range p {
+		switch {
+		case a.escaped:
+			a.in = append(a.in, b)
+			a.escaped = false
+		case b == esc:
+			// The escape character itself is kept so that `++` commands can
+			// be distinguished from escaped plus signs.
+			a.in = append(a.in, b)
+			a.escaped = true
+		case b == '\n' || b == '\r':
+			a.handle(a.in)
+			a.in = a.in[:0]
+		default:
+			a.in = append(a.in, b)
+		}
+	}
+	return len(p), nil
+}
+
+// Read reads the data queued for the host. If no data is queued,
+// os.ErrDeadlineExceeded is returned to emulate a read timeout.
+func (a *Adapter) Read(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.out.Len() == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	return a.out.Read(p)
+}
+
+// SetReadDeadline is a no-op, since reads never block.
+func (a *Adapter) SetReadDeadline(time.Time) error { return nil }
+
+// Flush discards the data queued for the host.
+func (a *Adapter) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.out.Reset()
+	return nil
+}
+
+// handle processes a complete message received from the host.
+func (a *Adapter) handle(raw []byte) {
+	if len(raw) == 0 {
+		return
+	}
+	if bytes.HasPrefix(raw, []byte("++")) {
+		a.command(strings.TrimSpace(string(raw[2:])))
+		return
+	}
+	// Remove the escape characters from the message for the instrument.
+	msg := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == esc && i+1 < len(raw) {
+			i++
+		}
+		msg = append(msg, raw[i])
+	}
+	addr := a.addr()
+	inst, ok := a.instruments[addr]
+	if !ok {
+		return
+	}
+	a.local[addr] = false
+	if resp := inst.Handle(msg); resp != "" {
+		a.pending[addr] += resp
+	}
+	if a.settings["auto"] == "1" {
+		a.talk(addr)
+	}
+}
+
+// command processes a Prologix command without the leading `++`.
+func (a *Adapter) command(cmd string) {
+	a.commands = append(a.commands, "++"+cmd)
+	name, arg, _ := strings.Cut(cmd, " ")
+	arg = strings.TrimSpace(arg)
+	addr := a.addr()
+	switch name {
+	case "ver":
+		a.reply(Version)
+	case "read":
+		a.talk(addr)
+	case "clr":
+		if c, ok := a.instruments[addr].(Clearer); ok {
+			c.Clear()
+		}
+		delete(a.pending, addr)
+	case "trg":
+		if t, ok := a.instruments[addr].(Triggerer); ok {
+			t.Trigger()
+		}
+	case "loc":
+		a.local[addr] = true
+	case "llo":
+		a.local[addr] = false
+	case "ifc", "rst":
+	case "srq":
+		if a.srq {
+			a.reply("1")
+		} else {
+			a.reply("0")
+		}
+	case "spoll":
+		target := addr
+		if arg != "" {
+			target, _ = strconv.Atoi(strings.Fields(arg)[0])
+		}
+		var stb byte
+		if p, ok := a.instruments[target].(Poller); ok {
+			stb = p.StatusByte()
+		}
+		a.reply(strconv.Itoa(int(stb)))
+	default:
+		if _, ok := a.settings[name]; !ok {
+			return
+		}
+		if arg == "" {
+			a.reply(a.settings[name])
+			return
+		}
+		a.settings[name] = arg
+	}
+}
+
+// addr returns the current primary GPIB address.
+func (a *Adapter) addr() int {
+	addr, _ := strconv.Atoi(strings.Fields(a.settings["addr"] + " 0")[0])
+	return addr
+}
+
+// talk addresses the instrument to talk and queues its pending response for
+// the host, appending the EOT character if enabled.
+func (a *Adapter) talk(addr int) {
+	resp, ok := a.pending[addr]
+	if !ok {
+		t, isTalker := a.instruments[addr].(Talker)
+		if !isTalker {
+			return
+		}
+		if resp = t.Talk(); resp == "" {
+			return
+		}
+	}
+	delete(a.pending, addr)
+	a.out.WriteString(resp)
+	if a.settings["eot_enable"] == "1" {
+		char, _ := strconv.Atoi(a.settings["eot_char"])
+		a.out.WriteByte(byte(char))
+	}
+}
+
+// reply queues the response to a Prologix command.
+func (a *Adapter) reply(s string) {
+	fmt.Fprintf(&a.out, "%s\r\n", s)
+}
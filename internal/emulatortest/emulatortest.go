@@ -0,0 +1,62 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+// Package emulatortest provides the emulator wiring shared by the tests of
+// the instrument drivers and the servers: a prologix.Controller of emulated
+// instruments and servers listening on a loopback TCP port.
+package emulatortest
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/emulator"
+)
+
+// Instruments maps GPIB primary addresses to emulated instruments.
+type Instruments map[int]emulator.Instrument
+
+// NewAdapter returns a new emulated Prologix controller with the instruments
+// attached.
+func NewAdapter(instruments Instruments) *emulator.Adapter {
+	adapter := emulator.New()
+	for addr, inst := range instruments {
+		adapter.Attach(addr, inst)
+	}
+	return adapter
+}
+
+// NewController attaches the instruments to a new emulated Prologix
+// controller and returns a Controller for it with the instrument at addr
+// selected, along with the emulated Prologix controller.
+func NewController(
+	t testing.TB,
+	addr int,
+	instruments Instruments,
+	opts ...prologix.ControllerOption,
+) (*prologix.Controller, *emulator.Adapter) {
+	t.Helper()
+	adapter := NewAdapter(instruments)
+	c, err := prologix.NewController(adapter, addr, false, opts...)
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	return c, adapter
+}
+
+// Serve calls serve with a listener on a free loopback TCP port in its own
+// goroutine and returns the address of the listener. The server is closed
+// using close when the test finishes.
+func Serve(t testing.TB, serve func(l net.Listener) error, close func() error) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %s", err)
+	}
+	go serve(l)
+	t.Cleanup(func() { close() })
+	return l.Addr().String()
+}
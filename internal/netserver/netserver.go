@@ -0,0 +1,130 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+// Package netserver provides the accept loop shared by the TCP servers, which
+// tracks the listeners and connections so they can be closed together, and the
+// logger the Controller and the servers use until one is given.
+package netserver
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+
+	"go.uber.org/multierr"
+)
+
+// Group serves connections from any number of listeners.
+type Group struct {
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// Serve accepts connections on the listener and calls handle for each one in
+// its own goroutine. The connection is closed when handle returns. Serve
+// blocks until the Group is closed, after which it returns nil. The listener
+// is closed when Serve returns.
+func (g *Group) Serve(l net.Listener, handle func(net.Conn)) error {
+	defer l.Close()
+	if !g.track(l) {
+		return nil
+	}
+	defer g.untrack(l)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if g.Closed() {
+				return nil
+			}
+			return err
+		}
+		if !g.track(conn) {
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer g.untrack(conn)
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+// Close stops all listeners, closes all connections, and waits for the
+// connection handlers to return.
+func (g *Group) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	var err error
+	for l := range g.listeners {
+		err = multierr.Append(err, l.Close())
+	}
+	for conn := range g.conns {
+		conn.Close()
+	}
+	g.mu.Unlock()
+	g.wg.Wait()
+	return err
+}
+
+// Closed reports whether the Group has been closed.
+func (g *Group) Closed() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closed
+}
+
+// track records a listener or connection so that Close can close it and wait
+// for the connection to be handled. It returns false if the Group is already
+// closed.
+func (g *Group) track(c any) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return false
+	}
+	if g.listeners == nil {
+		g.listeners = make(map[net.Listener]struct{})
+		g.conns = make(map[net.Conn]struct{})
+	}
+	switch v := c.(type) {
+	case net.Listener:
+		g.listeners[v] = struct{}{}
+	case net.Conn:
+		g.conns[v] = struct{}{}
+		g.wg.Add(1)
+	}
+	return true
+}
+
+func (g *Group) untrack(c any) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch v := c.(type) {
+	case net.Listener:
+		delete(g.listeners, v)
+	case net.Conn:
+		delete(g.conns, v)
+		g.wg.Done()
+	}
+}
+
+// DiscardLogger returns a logger that discards all log records, so that the
+// Controller and the servers are silent by default.
+func DiscardLogger() *slog.Logger {
+	return slog.New(DiscardHandler{})
+}
+
+// DiscardHandler is a slog.Handler that discards all log records.
+type DiscardHandler struct{}
+
+func (DiscardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (DiscardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h DiscardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h DiscardHandler) WithGroup(string) slog.Handler           { return h }
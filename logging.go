@@ -0,0 +1,71 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// logCommand logs a command sent to (tx) or a response received from (rx) the
+// Prologix controller.
+func (c *Controller) logCommand(
+	direction, cmd, resp string,
+	start time.Time,
+	n int,
+	err error,
+) {
+	attrs := []slog.Attr{slog.String("command", cmd)}
+	if direction == "rx" {
+		attrs = append(attrs, slog.String("response", resp))
+	}
+	c.logIO(direction, start, n, err, attrs...)
+}
+
+// logTraffic logs raw data written to or read from the instrument, which is
+// possibly binary and therefore only the number of bytes is logged.
+func (c *Controller) logTraffic(direction string, start time.Time, n int, err error) {
+	c.logIO(direction, start, n, err)
+}
+
+// logIO logs the direction, GPIB address, number of bytes, latency, and error,
+// if any, along with the given attributes. Errors are logged at least at the
+// warn level. The number of bytes and any timeout are also recorded in the
+// metrics.
+func (c *Controller) logIO(
+	direction string,
+	start time.Time,
+	n int,
+	err error,
+	attrs ...slog.Attr,
+) {
+	c.metrics.Bytes(c.primaryAddr, direction, n)
+	if errors.Is(err, ErrTimeout) {
+		c.metrics.Timeout(c.primaryAddr)
+	}
+	ctx := context.Background()
+	level := c.logLevel
+	if err != nil && level < slog.LevelWarn {
+		level = slog.LevelWarn
+	}
+	if !c.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs = append([]slog.Attr{
+		slog.String("direction", direction),
+		slog.Int("address", c.primaryAddr),
+	}, attrs...)
+	attrs = append(attrs,
+		slog.Int("bytes", n),
+		slog.Duration("latency", time.Since(start)),
+	)
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	c.logger.LogAttrs(ctx, level, "prologix "+direction, attrs...)
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/gotmc/prologix/internal/emulator"
+	"github.com/gotmc/prologix/internal/netserver"
+)
+
+// recordHandler is a slog.Handler that captures the log records at or above
+// its level.
+type recordHandler struct {
+	level   slog.Level
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler      { return h }
+
+// find returns the first record with the given message and command, if any.
+func (h *recordHandler) find(msg, cmd string) (slog.Record, map[string]slog.Value, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range h.records {
+		attrs := make(map[string]slog.Value)
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value
+			return true
+		})
+		if r.Message == msg && (cmd == "" || attrs["command"].String() == cmd) {
+			return r, attrs, true
+		}
+	}
+	return slog.Record{}, nil, false
+}
+
+func newLoggingAdapter() *emulator.Adapter {
+	adapter := emulator.New()
+	adapter.Attach(5, emulator.InstrumentFunc(func(msg string) string {
+		if msg == "*IDN?" {
+			return "ACME,METER,1,1.0\n"
+		}
+		return ""
+	}))
+	return adapter
+}
+
+func TestLoggingSilentByDefault(t *testing.T) {
+	c, err := NewController(newLoggingAdapter(), 5, false)
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	if _, ok := c.logger.Handler().(netserver.DiscardHandler); !ok {
+		t.Errorf("default handler = %T; want netserver.DiscardHandler", c.logger.Handler())
+	}
+	if c.logger.Enabled(context.Background(), slog.LevelError) {
+		t.Error("default logger enabled at error level")
+	}
+}
+
+func TestLoggingAttributes(t *testing.T) {
+	h := &recordHandler{level: slog.LevelDebug}
+	c, err := NewController(newLoggingAdapter(), 5, false, WithLogger(slog.New(h)))
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	if _, err := c.Query("*IDN?"); err != nil {
+		t.Fatalf("Query error: %s", err)
+	}
+
+	r, attrs, ok := h.find("prologix rx", "*IDN?")
+	if !ok {
+		t.Fatal("no rx record for the query response")
+	}
+	if r.Level != slog.LevelDebug {
+		t.Errorf("level = %s; want DEBUG", r.Level)
+	}
+	want := map[string]string{
+		"direction": "rx",
+		"address":   "5",
+		"response":  "ACME,METER,1,1.0\n",
+		"bytes":     "17",
+	}
+	for key, value := range want {
+		if got := attrs[key].String(); got != value {
+			t.Errorf("%s = %q; want %q", key, got, value)
+		}
+	}
+	if _, ok := attrs["latency"]; !ok {
+		t.Error("missing latency attribute")
+	}
+	if _, _, ok := h.find("prologix tx", "++addr 5"); !ok {
+		t.Error("no tx record for the init sequence")
+	}
+}
+
+func TestLoggingLevels(t *testing.T) {
+	h := &recordHandler{level: slog.LevelInfo}
+	c, err := NewController(newLoggingAdapter(), 5, false, WithLogger(slog.New(h)))
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	if _, _, ok := h.find("prologix tx", ""); ok {
+		t.Error("traffic logged at info level; want debug by default")
+	}
+
+	// Errors are logged at the warn level even though traffic is logged at the
+	// debug level.
+	c.SetReadTimeout(1)
+	if _, err := c.Query("*OPC?"); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Query error = %v; want ErrTimeout", err)
+	}
+	r, attrs, ok := h.find("prologix rx", "")
+	if !ok {
+		t.Fatal("no record for the timeout")
+	}
+	if r.Level != slog.LevelWarn {
+		t.Errorf("level = %s; want WARN", r.Level)
+	}
+	if _, ok := attrs["error"]; !ok {
+		t.Error("missing error attribute")
+	}
+
+	h = &recordHandler{level: slog.LevelInfo}
+	if _, err := NewController(newLoggingAdapter(), 5, false,
+		WithLogger(slog.New(h)), WithLogLevel(slog.LevelInfo)); err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	if r, _, ok := h.find("prologix tx", "++addr 5"); !ok || r.Level != slog.LevelInfo {
+		t.Error("traffic not logged at the level set by WithLogLevel")
+	}
+}
+
+func TestLoggingWithDebug(t *testing.T) {
+	h := &recordHandler{level: slog.LevelInfo}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(h))
+	defer slog.SetDefault(defaultLogger)
+
+	if _, err := NewController(newLoggingAdapter(), 5, false, WithDebug()); err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	if r, _, ok := h.find("prologix tx", "++addr 5"); !ok || r.Level != slog.LevelInfo {
+		t.Error("WithDebug didn't log traffic at the info level using the default logger")
+	}
+}
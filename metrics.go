@@ -0,0 +1,59 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import "time"
+
+// Metrics receives measurements of the traffic and health of a Controller,
+// such as the Prometheus exporter in the metrics/prometheus package. The
+// methods are called while the Controller holds the bus, so they must return
+// quickly, and they may be called concurrently by several Controllers.
+type Metrics interface {
+	// Command is called for each command sent to the instrument at the given
+	// GPIB address using Command.
+	Command(addr int)
+	// Query is called when a query of the instrument at the given GPIB address
+	// using Query, QueryWithTimeout, or ReadResponse completes, with the time
+	// taken and the error, if any.
+	Query(addr int, latency time.Duration, err error)
+	// Bytes is called for each write to ("tx") or read from ("rx") the
+	// Prologix controller while the given GPIB address is selected, including
+	// the Prologix commands.
+	Bytes(addr int, direction string, n int)
+	// Timeout is called when the instrument at the given GPIB address or the
+	// Prologix controller doesn't respond in time.
+	Timeout(addr int)
+	// Reconnect is called after each attempt to reconnect a lost transport,
+	// with the error if the attempt failed.
+	Reconnect(err error)
+	// ServiceRequest is called when ServiceRequest finds the GPIB SRQ line
+	// asserted.
+	ServiceRequest()
+	// StateMismatch is called by Verify for each setting of the Prologix
+	// controller that differs from the setting cached in the Controller.
+	StateMismatch(setting string)
+}
+
+// WithMetrics sets the Metrics that receive the measurements of the
+// Controller. By default no measurements are recorded.
+func WithMetrics(m Metrics) ControllerOption {
+	return func(c *Controller) {
+		if m != nil {
+			c.metrics = m
+		}
+	}
+}
+
+// nopMetrics discards all measurements.
+type nopMetrics struct{}
+
+func (nopMetrics) Command(int)                     {}
+func (nopMetrics) Query(int, time.Duration, error) {}
+func (nopMetrics) Bytes(int, string, int)          {}
+func (nopMetrics) Timeout(int)                     {}
+func (nopMetrics) Reconnect(error)                 {}
+func (nopMetrics) ServiceRequest()                 {}
+func (nopMetrics) StateMismatch(string)            {}
@@ -0,0 +1,201 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package prometheus exports the metrics of prologix Controllers to Prometheus.
+
+Create the Metrics, register them, and pass them to the Controller:
+
+	m := prometheus.New(prometheus.WithConstLabels(map[string]string{"adapter": "bench1"}))
+	registry.MustRegister(m)
+	gpib, err := prologix.NewController(rw, 5, false, prologix.WithMetrics(m))
+
+The following metrics are exported, where the address label is the primary
+GPIB address:
+
+	prologix_commands_total{address}
+	prologix_queries_total{address, result="ok"|"error"}
+	prologix_query_duration_seconds{address}
+	prologix_bytes_total{address, direction="tx"|"rx"}
+	prologix_timeouts_total{address}
+	prologix_reconnects_total{result="ok"|"error"}
+	prologix_service_requests_total
+	prologix_state_mismatches_total{setting}
+*/
+package prometheus
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gotmc/prologix"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultBuckets are the default buckets of the query duration histogram in
+// seconds, which range from fast USB queries to slow instruments queried using
+// QueryWithTimeout.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Metrics implements prologix.Metrics using Prometheus metrics. It is a
+// prometheus.Collector, which can be registered with a registry. One Metrics
+// can be used by several Controllers; to tell them apart, use a Metrics with
+// distinct constant labels for each Controller.
+type Metrics struct {
+	commands        *prom.CounterVec
+	queries         *prom.CounterVec
+	queryDuration   *prom.HistogramVec
+	bytes           *prom.CounterVec
+	timeouts        *prom.CounterVec
+	reconnects      *prom.CounterVec
+	serviceRequests prom.Counter
+	mismatches      *prom.CounterVec
+}
+
+var _ prologix.Metrics = (*Metrics)(nil)
+var _ prom.Collector = (*Metrics)(nil)
+
+type options struct {
+	namespace   string
+	constLabels prom.Labels
+	buckets     []float64
+}
+
+// Option applies an option to the Metrics.
+type Option func(*options)
+
+// WithNamespace sets the namespace prefixed to the metric names, which is
+// "prologix" by default.
+func WithNamespace(namespace string) Option {
+	return func(o *options) { o.namespace = namespace }
+}
+
+// WithConstLabels sets labels added to every metric, such as the name of the
+// adapter when several Prologix controllers are monitored.
+func WithConstLabels(labels map[string]string) Option {
+	return func(o *options) { o.constLabels = labels }
+}
+
+// WithBuckets sets the buckets of the query duration histogram in seconds.
+func WithBuckets(buckets []float64) Option {
+	return func(o *options) { o.buckets = buckets }
+}
+
+// New creates the Metrics.
+func New(opts ...Option) *Metrics {
+	o := options{namespace: "prologix", buckets: DefaultBuckets}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	counter := func(name, help string, labels ...string) *prom.CounterVec {
+		return prom.NewCounterVec(prom.CounterOpts{
+			Namespace:   o.namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: o.constLabels,
+		}, labels)
+	}
+	return &Metrics{
+		commands: counter("commands_total",
+			"Commands sent to instruments.", "address"),
+		queries: counter("queries_total",
+			"Queries of instruments by result.", "address", "result"),
+		queryDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace:   o.namespace,
+			Name:        "query_duration_seconds",
+			Help:        "Time taken by queries of instruments.",
+			ConstLabels: o.constLabels,
+			Buckets:     o.buckets,
+		}, []string{"address"}),
+		bytes: counter("bytes_total",
+			"Bytes written to (tx) or read from (rx) the Prologix controller.", "address", "direction"),
+		timeouts: counter("timeouts_total",
+			"Timeouts waiting for instruments or the Prologix controller.", "address"),
+		reconnects: counter("reconnects_total",
+			"Attempts to reconnect a lost transport by result.", "result"),
+		serviceRequests: prom.NewCounter(prom.CounterOpts{
+			Namespace:   o.namespace,
+			Name:        "service_requests_total",
+			Help:        "Checks that found the GPIB SRQ line asserted.",
+			ConstLabels: o.constLabels,
+		}),
+		mismatches: counter("state_mismatches_total",
+			"Prologix settings found to differ from the cached settings.", "setting"),
+	}
+}
+
+// Command implements prologix.Metrics.
+func (m *Metrics) Command(addr int) {
+	m.commands.WithLabelValues(strconv.Itoa(addr)).Inc()
+}
+
+// Query implements prologix.Metrics.
+func (m *Metrics) Query(addr int, latency time.Duration, err error) {
+	a := strconv.Itoa(addr)
+	m.queries.WithLabelValues(a, result(err)).Inc()
+	m.queryDuration.WithLabelValues(a).Observe(latency.Seconds())
+}
+
+// Bytes implements prologix.Metrics.
+func (m *Metrics) Bytes(addr int, direction string, n int) {
+	if n > 0 {
+		m.bytes.WithLabelValues(strconv.Itoa(addr), direction).Add(float64(n))
+	}
+}
+
+// Timeout implements prologix.Metrics.
+func (m *Metrics) Timeout(addr int) {
+	m.timeouts.WithLabelValues(strconv.Itoa(addr)).Inc()
+}
+
+// Reconnect implements prologix.Metrics.
+func (m *Metrics) Reconnect(err error) {
+	m.reconnects.WithLabelValues(result(err)).Inc()
+}
+
+// ServiceRequest implements prologix.Metrics.
+func (m *Metrics) ServiceRequest() {
+	m.serviceRequests.Inc()
+}
+
+// StateMismatch implements prologix.Metrics.
+func (m *Metrics) StateMismatch(setting string) {
+	m.mismatches.WithLabelValues(setting).Inc()
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prom.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prom.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (m *Metrics) collectors() []prom.Collector {
+	return []prom.Collector{
+		m.commands,
+		m.queries,
+		m.queryDuration,
+		m.bytes,
+		m.timeouts,
+		m.reconnects,
+		m.serviceRequests,
+		m.mismatches,
+	}
+}
+
+// result returns the value of the result label for the error.
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prometheus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/emulator"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestControllerMetrics(t *testing.T) {
+	adapter := emulator.New()
+	adapter.Attach(5, emulator.InstrumentFunc(func(msg string) string {
+		if msg == "*IDN?" {
+			return "ACME,METER,1,1.0\n"
+		}
+		return ""
+	}))
+	m := New(WithConstLabels(map[string]string{"adapter": "bench1"}))
+	reg := prom.NewPedanticRegistry()
+	if err := reg.Register(m); err != nil {
+		t.Fatalf("Register error: %s", err)
+	}
+	c, err := prologix.NewController(adapter, 5, false, prologix.WithMetrics(m))
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+
+	if err := c.Command("*RST"); err != nil {
+		t.Fatalf("Command error: %s", err)
+	}
+	if _, err := c.WriteBinary([]byte("DATA #13\n+\r")); err != nil {
+		t.Fatalf("WriteBinary error: %s", err)
+	}
+	if _, err := c.Query("*IDN?"); err != nil {
+		t.Fatalf("Query error: %s", err)
+	}
+	unsupported, err := prologix.NewController(emulator.New(), 5, false,
+		prologix.WithEOTEnable(false), prologix.WithMetrics(m))
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	if _, err := unsupported.QueryWithTimeout("*OPC?", time.Second); !errors.Is(err, prologix.ErrUnsupported) {
+		t.Fatalf("QueryWithTimeout error = %v; want ErrUnsupported", err)
+	}
+	adapter.SetSRQ(true)
+	if _, err := c.ServiceRequest(); err != nil {
+		t.Fatalf("ServiceRequest error: %s", err)
+	}
+	adapter.SetSetting("eoi", "0")
+	var mismatch *prologix.StateMismatchError
+	if err := c.Verify(); !errors.As(err, &mismatch) {
+		t.Fatalf("Verify error = %v; want StateMismatchError", err)
+	}
+
+	tests := []struct {
+		name string
+		c    prom.Collector
+		want float64
+	}{
+		{"commands", m.commands.WithLabelValues("5"), 2},
+		{"queries", m.queries.WithLabelValues("5", "ok"), 1},
+		{"failed queries", m.queries.WithLabelValues("5", "error"), 1},
+		{"service requests", m.serviceRequests, 1},
+		{"state mismatches", m.mismatches.WithLabelValues("eoi"), 1},
+		{"timeouts", m.timeouts.WithLabelValues("5"), 0},
+	}
+	for _, tc := range tests {
+		if got := testutil.ToFloat64(tc.c); got != tc.want {
+			t.Errorf("%s = %g; want %g", tc.name, got, tc.want)
+		}
+	}
+	if got := testutil.ToFloat64(m.bytes.WithLabelValues("5", "rx")); got < float64(len("ACME,METER,1,1.0\n")) {
+		t.Errorf("bytes read = %g; want at least the query response", got)
+	}
+	if n := testutil.CollectAndCount(m, "prologix_query_duration_seconds"); n != 1 {
+		t.Errorf("query duration series = %d; want 1", n)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Errorf("Gather error: %s", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"strings"
+	"time"
+)
+
+// Pacing configures the delays the Controller enforces between the commands
+// written to an instrument, for instruments that drop input when commands
+// arrive too quickly. The delays are kept per GPIB primary address, so a long
+// delay after resetting one instrument doesn't delay the others on the bus.
+type Pacing struct {
+	MinGap     time.Duration // Minimum time between writes to the instrument.
+	AfterClear time.Duration // Delay after the Selected Device Clear (SDC) message.
+	AfterReset time.Duration // Delay after the `*RST` command.
+	Rules      []PacingRule  // Delays after specific commands.
+}
+
+// PacingRule delays the next write to the instrument after a command starting
+// with the given prefix, which is compared case-insensitively.
+type PacingRule struct {
+	Prefix string
+	Delay  time.Duration
+}
+
+// WithPacing sets the pacing used for all instruments that don't have their
+// own pacing set using WithAddressPacing.
+func WithPacing(p Pacing) ControllerOption {
+	return func(c *Controller) { c.pacing = p }
+}
+
+// WithAddressPacing sets the pacing used for the instrument at the given
+// primary GPIB address.
+func WithAddressPacing(addr int, p Pacing) ControllerOption {
+	return func(c *Controller) {
+		if c.addrPacing == nil {
+			c.addrPacing = make(map[int]Pacing)
+		}
+		c.addrPacing[addr] = p
+	}
+}
+
+// delay returns how long to wait after the given command before the next
+// write to the instrument.
+func (p Pacing) delay(cmd string) time.Duration {
+	d := p.MinGap
+	upper := strings.ToUpper(strings.TrimSpace(cmd))
+	switch {
+	case upper == "++CLR":
+		d = max(d, p.AfterClear)
+	case strings.HasPrefix(upper, "*RST"):
+		d = max(d, p.AfterReset)
+	}
+	for _, rule := range p.Rules {
+		if strings.HasPrefix(upper, strings.ToUpper(rule.Prefix)) {
+			d = max(d, rule.Delay)
+		}
+	}
+	return d
+}
+
+// isPaced reports whether the given command is sent to the instrument, as
+// opposed to being handled by the Prologix controller itself. The Selected
+// Device Clear (SDC) message is also paced.
+func isPaced(cmd string) bool {
+	return !strings.HasPrefix(cmd, "++") || strings.EqualFold(cmd, "++clr")
+}
+
+// pace waits until the delay required by the previous write to the
+// instrument at the current address has passed. Writes to other instruments
+// aren't delayed.
+func (c *Controller) pace() {
+	if d := time.Until(c.nextWrite[c.primaryAddr]); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// schedule records when the next write to the instrument may happen after
+// writing the given command.
+func (c *Controller) schedule(cmd string) {
+	p, ok := c.addrPacing[c.primaryAddr]
+	if !ok {
+		p = c.pacing
+	}
+	if d := p.delay(cmd); d > 0 {
+		if c.nextWrite == nil {
+			c.nextWrite = make(map[int]time.Time)
+		}
+		c.nextWrite[c.primaryAddr] = time.Now().Add(d)
+	}
+}
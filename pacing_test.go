@@ -0,0 +1,97 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacingDelay(t *testing.T) {
+	p := Pacing{
+		MinGap:     10 * time.Millisecond,
+		AfterClear: 500 * time.Millisecond,
+		AfterReset: time.Second,
+		Rules: []PacingRule{
+			{"mena", 50 * time.Millisecond},
+			{"M", 5 * time.Millisecond},
+		},
+	}
+	tests := []struct {
+		cmd  string
+		want time.Duration
+	}{
+		{"FREQ 100.0", 10 * time.Millisecond},
+		{"MENA1", 50 * time.Millisecond},
+		{"MTYP5", 10 * time.Millisecond},
+		{"*rst", time.Second},
+		{"++clr", 500 * time.Millisecond},
+		{"", 10 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.cmd, func(t *testing.T) {
+			if got := p.delay(test.cmd); got != test.want {
+				t.Errorf("got %s; want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPacingEnforced(t *testing.T) {
+	const gap = 20 * time.Millisecond
+	var f fakeAdapter
+	c, err := NewController(&f, 6, false,
+		WithPacing(Pacing{MinGap: time.Hour}),
+		WithAddressPacing(6, Pacing{MinGap: gap}),
+	)
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	start := time.Now()
+	for _, cmd := range []string{"FUNC 0", "FREQ 100.0", "AMPL 0.5VP"} {
+		if err := c.Command(cmd); err != nil {
+			t.Fatalf("Command error: %s", err)
+		}
+		// Prologix commands are not paced.
+		if _, err := c.send("++ver"); err != nil {
+			t.Fatalf("send error: %s", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 2*gap || elapsed > time.Minute {
+		t.Errorf("three commands took %s; want at least %s", elapsed, 2*gap)
+	}
+}
+
+func TestPacingPerAddress(t *testing.T) {
+	var f fakeAdapter
+	c, err := NewController(&f, 6, false, WithAddressPacing(6, Pacing{AfterReset: time.Hour}))
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	gen, err := c.Device(6)
+	if err != nil {
+		t.Fatalf("Device error: %s", err)
+	}
+	dmm, err := c.Device(7)
+	if err != nil {
+		t.Fatalf("Device error: %s", err)
+	}
+	if err := gen.Command("*RST"); err != nil {
+		t.Fatalf("Command error: %s", err)
+	}
+	// The delay after resetting the instrument at address 6 must not delay the
+	// writes to the instrument at address 7.
+	done := make(chan error, 1)
+	go func() { done <- dmm.Command("*CLS") }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Command error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write to address 7 delayed by the pacing of address 6")
+	}
+}
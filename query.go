@@ -0,0 +1,137 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// SCPI represents an overload, such as an out of range measurement, as 9.9E37
+// and not-a-number as 9.91E37.
+const (
+	scpiOverload = 9.9e37
+	scpiNaN      = 9.91e37
+)
+
+// QueryString queries the instrument and returns the response with the
+// leading and trailing whitespace, terminators, and EOT characters removed.
+func (c *Controller) QueryString(cmd string) (string, error) {
+	s, err := c.Query(cmd)
+	if err != nil {
+		return "", err
+	}
+	return c.trimResponse(s), nil
+}
+
+// QueryFloat queries the instrument and parses the response as a float64.
+// The SCPI overload value 9.9E37 is returned as positive or negative
+// infinity and the SCPI not-a-number value 9.91E37 as NaN. If the response
+// can't be parsed, an *UnexpectedResponseError with the raw response is
+// returned.
+func (c *Controller) QueryFloat(cmd string) (float64, error) {
+	s, err := c.Query(cmd)
+	if err != nil {
+		return 0, err
+	}
+	f, err := parseFloat(c.trimResponse(s))
+	if err != nil {
+		return 0, unexpectedResponse(cmd, s, err)
+	}
+	return f, nil
+}
+
+// QueryFloats queries the instrument and parses the response as comma
+// separated float64 values, such as the readings from a multimeter. The SCPI
+// overload and not-a-number values are handled as in QueryFloat.
+func (c *Controller) QueryFloats(cmd string) ([]float64, error) {
+	s, err := c.Query(cmd)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := c.trimResponse(s)
+	if trimmed == "" {
+		return []float64{}, nil
+	}
+	fields := strings.Split(trimmed, ",")
+	values := make([]float64, len(fields))
+	for i, field := range fields {
+		values[i], err = parseFloat(strings.TrimSpace(field))
+		if err != nil {
+			return nil, unexpectedResponse(cmd, s, fmt.Errorf("value %d: %w", i, err))
+		}
+	}
+	return values, nil
+}
+
+// QueryInt queries the instrument and parses the response as an int. Integer
+// values formatted in scientific notation, such as `+1.00000E+01`, are
+// accepted.
+func (c *Controller) QueryInt(cmd string) (int, error) {
+	s, err := c.Query(cmd)
+	if err != nil {
+		return 0, err
+	}
+	trimmed := c.trimResponse(s)
+	i, err := strconv.Atoi(trimmed)
+	if err == nil {
+		return i, nil
+	}
+	f, ferr := strconv.ParseFloat(trimmed, 64)
+	if ferr != nil || f != math.Trunc(f) || math.Abs(f) > math.MaxInt32 {
+		return 0, unexpectedResponse(cmd, s, err)
+	}
+	return int(f), nil
+}
+
+// QueryBool queries the instrument and parses a response of 1, 0, ON, or OFF
+// as a bool.
+func (c *Controller) QueryBool(cmd string) (bool, error) {
+	s, err := c.Query(cmd)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToUpper(c.trimResponse(s)) {
+	case "1", "ON", "+1":
+		return true, nil
+	case "0", "OFF", "+0":
+		return false, nil
+	}
+	return false, unexpectedResponse(cmd, s, nil)
+}
+
+// trimResponse removes the leading and trailing whitespace and EOT
+// characters from the response.
+func (c *Controller) trimResponse(s string) string {
+	trim := func(b byte) bool {
+		return b == c.eotChar || b == ' ' || b == '\t' || b == '\r' || b == '\n'
+	}
+	for len(s) > 0 && trim(s[0]) {
+		s = s[1:]
+	}
+	for len(s) > 0 && trim(s[len(s)-1]) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// parseFloat parses a SCPI numeric value, converting the SCPI overload and
+// not-a-number values.
+func parseFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	switch math.Abs(f) {
+	case scpiNaN:
+		return math.NaN(), nil
+	case scpiOverload:
+		return math.Inf(int(math.Copysign(1, f))), nil
+	}
+	return f, nil
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/gotmc/prologix/internal/emulator"
+)
+
+// newEchoController returns a Controller whose instrument responds to every
+// query with the query itself, without the trailing question mark.
+func newEchoController(t *testing.T, opts ...ControllerOption) *Controller {
+	t.Helper()
+	adapter := emulator.New()
+	adapter.Attach(1, emulator.InstrumentFunc(func(msg string) string {
+		return msg[:len(msg)-1] + "\r\n"
+	}))
+	c, err := NewController(adapter, 1, false, opts...)
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	return c
+}
+
+func TestQueryFloat(t *testing.T) {
+	tests := []struct {
+		given   string
+		want    float64
+		wantErr bool
+	}{
+		{"+1.23456E+02", 123.456, false},
+		{"  -0.5 ", -0.5, false},
+		{"+9.90000000E+37", math.Inf(1), false},
+		{"-9.9E37", math.Inf(-1), false},
+		{"9.91E37", math.NaN(), false},
+		{"OL", 0, true},
+	}
+	c := newEchoController(t)
+	for _, test := range tests {
+		t.Run(test.given, func(t *testing.T) {
+			got, err := c.QueryFloat(test.given + "?")
+			if test.wantErr {
+				var unexpected *UnexpectedResponseError
+				if !errors.As(err, &unexpected) || string(unexpected.Raw) != test.given+"\r\n" {
+					t.Errorf("got %v; want UnexpectedResponseError with raw response", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("QueryFloat error: %s", err)
+			}
+			if got != test.want && !(math.IsNaN(got) && math.IsNaN(test.want)) {
+				t.Errorf("got %g; want %g", got, test.want)
+			}
+		})
+	}
+}
+
+func TestQueryFloats(t *testing.T) {
+	c := newEchoController(t)
+	got, err := c.QueryFloats("+1.0E+00,-2.5E-03, 9.9E37?")
+	if err != nil {
+		t.Fatalf("QueryFloats error: %s", err)
+	}
+	want := []float64{1, -0.0025, math.Inf(1)}
+	if len(got) != len(want) {
+		t.Fatalf("got %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v; want %v", got, want)
+		}
+	}
+	if _, err := c.QueryFloats("1.0,x?"); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("got %v; want ErrUnexpectedResponse", err)
+	}
+}
+
+func TestQueryIntBoolString(t *testing.T) {
+	c := newEchoController(t, WithEOTChar('#'))
+	if got, err := c.QueryInt("+1.00000E+01?"); err != nil || got != 10 {
+		t.Errorf("QueryInt got %d, %v; want 10", got, err)
+	}
+	if got, err := c.QueryInt("-42?"); err != nil || got != -42 {
+		t.Errorf("QueryInt got %d, %v; want -42", got, err)
+	}
+	if _, err := c.QueryInt("1.5?"); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("QueryInt got %v; want ErrUnexpectedResponse", err)
+	}
+	if got, err := c.QueryBool("ON?"); err != nil || !got {
+		t.Errorf("QueryBool got %t, %v; want true", got, err)
+	}
+	if got, err := c.QueryBool("0?"); err != nil || got {
+		t.Errorf("QueryBool got %t, %v; want false", got, err)
+	}
+	if _, err := c.QueryBool("maybe?"); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("QueryBool got %v; want ErrUnexpectedResponse", err)
+	}
+	if got, err := c.QueryString("DS345?"); err != nil || got != "DS345" {
+		t.Errorf("QueryString got %q, %v; want DS345", got, err)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reconnector is implemented by transports that can reopen the connection to
+// the Prologix controller after an I/O error, such as reconnect.Conn.
+type Reconnector interface {
+	// Connected reports whether the transport is connected.
+	Connected() bool
+	// Reconnect reopens the connection.
+	Reconnect() error
+}
+
+// recoverIO is called with every I/O error. If the transport is a Reconnector
+// that lost its connection, the transport is reconnected and the Prologix
+// controller reconfigured using the init sequence, which also addresses the
+// last addressed instrument. The returned error wraps ErrReconnected if the
+// recovery succeeded.
+func (c *Controller) recoverIO(err error) error {
+	if err == nil || c.reconnecting || errors.Is(err, ErrTimeout) {
+		return err
+	}
+	rc, ok := c.rw.(Reconnector)
+	if !ok || rc.Connected() {
+		return err
+	}
+	c.reconnecting = true
+	defer func() { c.reconnecting = false }()
+	c.logger.Warn("prologix reconnecting", "address", c.primaryAddr, "error", err)
+	if rerr := rc.Reconnect(); rerr != nil {
+		c.metrics.Reconnect(rerr)
+		return fmt.Errorf("%w (reconnect failed: %w)", err, rerr)
+	}
+	// Replay the init sequence without enabling savecfg again, so that
+	// frequent reconnects don't wear out the EEPROM.
+	for _, cmd := range c.initCommands() {
+		if cmd == "savecfg 1" {
+			continue
+		}
+		if _, rerr := c.send(controllerCommand(cmd)); rerr != nil {
+			c.metrics.Reconnect(rerr)
+			return fmt.Errorf("%w (reconfiguring after reconnect failed: %w)", err, rerr)
+		}
+	}
+	c.metrics.Reconnect(nil)
+	c.logger.Info("prologix reconnected", "address", c.primaryAddr)
+	return fmt.Errorf("%w: %w", ErrReconnected, err)
+}
@@ -0,0 +1,315 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package scpi provides the IEEE 488.2 common commands and the SCPI error queue
+handling shared by most GPIB instruments, built on top of a
+prologix.Controller or any other type that can send commands and queries.
+
+In checked mode, the instrument's error queue is drained using `SYST:ERR?`
+after every command and query, and any errors found are returned as Go errors
+of type *Error.
+*/
+package scpi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"go.uber.org/multierr"
+)
+
+// Commander sends commands and queries to an instrument, such as a
+// prologix.Controller.
+type Commander interface {
+	Command(format string, a ...any) error
+	Query(cmd string) (string, error)
+}
+
+// timeoutQuerier is implemented by a prologix.Controller to wait for
+// responses longer than the Prologix read timeout.
+type timeoutQuerier interface {
+	QueryWithTimeout(cmd string, timeout time.Duration) (string, error)
+}
+
+// maxErrors limits the number of errors read from the error queue, in case
+// the instrument never reports that the queue is empty.
+const maxErrors = 32
+
+// Instrument is an IEEE 488.2 instrument that understands the SCPI
+// `SYST:ERR?` query.
+type Instrument struct {
+	bus     Commander
+	checked bool
+}
+
+// Option applies an option to the Instrument.
+type Option func(*Instrument)
+
+// WithChecked enables checked mode, which drains the error queue after every
+// command and query and returns the instrument errors as Go errors.
+func WithChecked() Option {
+	return func(i *Instrument) { i.checked = true }
+}
+
+// New creates an Instrument that communicates using the given Commander.
+func New(bus Commander, opts ...Option) *Instrument {
+	i := Instrument{bus: bus}
+	for _, opt := range opts {
+		opt(&i)
+	}
+	return &i
+}
+
+// Command formats according to a format specifier if provided and sends the
+// command to the instrument. In checked mode, the error queue is drained
+// afterwards.
+func (i *Instrument) Command(format string, a ...any) error {
+	if err := i.bus.Command(format, a...); err != nil {
+		return err
+	}
+	return i.check()
+}
+
+// Query sends the query to the instrument and returns the response. In
+// checked mode, the error queue is drained afterwards.
+func (i *Instrument) Query(cmd string) (string, error) {
+	s, err := i.bus.Query(cmd)
+	if err != nil {
+		return s, err
+	}
+	return s, i.check()
+}
+
+// check drains the error queue in checked mode.
+func (i *Instrument) check() error {
+	if !i.checked {
+		return nil
+	}
+	errs, err := i.Errors()
+	if err != nil {
+		return err
+	}
+	return multierr.Combine(errs...)
+}
+
+// Identity holds the fields of the response to the `*IDN?` query.
+type Identity struct {
+	Manufacturer string
+	Model        string
+	SerialNumber string
+	Firmware     string
+}
+
+func (id Identity) String() string {
+	return strings.Join([]string{id.Manufacturer, id.Model, id.SerialNumber, id.Firmware}, ",")
+}
+
+// IDN queries the identification of the instrument using `*IDN?`.
+func (i *Instrument) IDN() (Identity, error) {
+	s, err := i.Query("*IDN?")
+	if err != nil {
+		return Identity{}, err
+	}
+	return ParseIdentity(s)
+}
+
+// ParseIdentity parses the response to the `*IDN?` query, which consists of
+// four comma separated fields. Some older instruments omit the last fields,
+// which are then left empty.
+func ParseIdentity(s string) (Identity, error) {
+	raw := s
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Identity{}, &prologix.UnexpectedResponseError{
+			Command: "*IDN?",
+			Raw:     []byte(raw),
+		}
+	}
+	fields := strings.SplitN(s, ",", 4)
+	for len(fields) < 4 {
+		fields = append(fields, "")
+	}
+	for j := range fields {
+		fields[j] = strings.TrimSpace(fields[j])
+	}
+	return Identity{
+		Manufacturer: fields[0],
+		Model:        fields[1],
+		SerialNumber: fields[2],
+		Firmware:     fields[3],
+	}, nil
+}
+
+// Reset resets the instrument using `*RST`.
+func (i *Instrument) Reset() error {
+	return i.Command("*RST")
+}
+
+// Clear clears the status registers and the error queue using `*CLS`.
+func (i *Instrument) Clear() error {
+	return i.Command("*CLS")
+}
+
+// WaitOPC waits until all pending operations are complete using `*OPC?`. If
+// the Commander is a prologix.Controller, the query waits up to the given
+// timeout, which may be longer than the Prologix read timeout; otherwise the
+// timeout of the Commander applies.
+func (i *Instrument) WaitOPC(timeout time.Duration) error {
+	var s string
+	var err error
+	if tq, ok := i.bus.(timeoutQuerier); ok {
+		s, err = tq.QueryWithTimeout("*OPC?", timeout)
+	} else {
+		s, err = i.bus.Query("*OPC?")
+	}
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(s) != "1" {
+		return &prologix.UnexpectedResponseError{Command: "*OPC?", Raw: []byte(s)}
+	}
+	return i.check()
+}
+
+// ESR queries and clears the Standard Event Status Register using `*ESR?`.
+func (i *Instrument) ESR() (EventStatus, error) {
+	s, err := i.bus.Query("*ESR?")
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || v < 0 || v > 255 {
+		return 0, &prologix.UnexpectedResponseError{Command: "*ESR?", Raw: []byte(s), Err: err}
+	}
+	return EventStatus(v), nil
+}
+
+// Errors drains the error queue using `SYST:ERR?` and returns the errors in
+// the order they occurred.
+func (i *Instrument) Errors() ([]error, error) {
+	var errs []error
+	for j := 0; j < maxErrors; j++ {
+		s, err := i.bus.Query("SYST:ERR?")
+		if err != nil {
+			return errs, err
+		}
+		e, err := ParseError(s)
+		if err != nil {
+			return errs, err
+		}
+		if e.Code == 0 {
+			return errs, nil
+		}
+		errs = append(errs, e)
+	}
+	return errs, fmt.Errorf("error queue not empty after reading %d errors", maxErrors)
+}
+
+// Error is an error reported by the instrument in its error queue.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("instrument error %d: %s", e.Code, e.Message)
+}
+
+// ParseError parses the response to the `SYST:ERR?` query, such as
+// `-113,"Undefined header"`. A code of zero means the error queue is empty.
+func ParseError(s string) (*Error, error) {
+	code, msg, _ := strings.Cut(strings.TrimSpace(s), ",")
+	c, err := strconv.Atoi(strings.TrimSpace(code))
+	if err != nil {
+		return nil, &prologix.UnexpectedResponseError{Command: "SYST:ERR?", Raw: []byte(s), Err: err}
+	}
+	return &Error{Code: c, Message: strings.Trim(strings.TrimSpace(msg), `"`)}, nil
+}
+
+// EventStatus holds the bits of the IEEE 488.2 Standard Event Status Register.
+type EventStatus byte
+
+// Bits of the Standard Event Status Register.
+const (
+	OperationComplete EventStatus = 1 << iota
+	RequestControl
+	QueryError
+	DeviceError
+	ExecutionError
+	CommandError
+	UserRequest
+	PowerOn
+)
+
+var eventStatusDesc = []string{
+	"operation complete",
+	"request control",
+	"query error",
+	"device dependent error",
+	"execution error",
+	"command error",
+	"user request",
+	"power on",
+}
+
+// HasErrors reports whether any of the query, device dependent, execution, or
+// command error bits are set.
+func (esr EventStatus) HasErrors() bool {
+	return esr&(QueryError|DeviceError|ExecutionError|CommandError) != 0
+}
+
+func (esr EventStatus) String() string {
+	var names []string
+	for bit, desc := range eventStatusDesc {
+		if esr&(1<<bit) != 0 {
+			names = append(names, desc)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// IsQuery reports whether the program message contains a query, including
+// compound messages such as `VOLT 1;*OPC?`. A `?` inside a quoted string, such
+// as `DISP:TEXT "READY?"`, or inside an IEEE 488.2 arbitrary block, such as
+// `DATA #13a?b`, isn't a query.
+func IsQuery(msg string) bool {
+	for i := 0; i < len(msg); i++ {
+		switch msg[i] {
+		case '?':
+			return true
+		case '"', '\'':
+			// A doubled quote inside a string is read as two strings.
+			end := strings.IndexByte(msg[i+1:], msg[i])
+			if end < 0 {
+				return false
+			}
+			i += end + 1
+		case '#':
+			if i+1 >= len(msg) || msg[i+1] < '0' || msg[i+1] > '9' {
+				// Non-decimal numbers, such as #H1F, aren't blocks.
+				continue
+			}
+			// An indefinite length block, #0, lasts until the end of the
+			// message.
+			digits := int(msg[i+1] - '0')
+			if digits == 0 || i+2+digits > len(msg) {
+				return false
+			}
+			n, err := strconv.Atoi(msg[i+2 : i+2+digits])
+			if err != nil {
+				return false
+			}
+			i += 1 + digits + n
+		}
+	}
+	return false
+}
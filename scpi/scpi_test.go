@@ -0,0 +1,167 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package scpi
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/emulator"
+	"go.uber.org/multierr"
+)
+
+// dmm emulates an IEEE 488.2 instrument with an error queue, which reports an
+// error for every command it doesn't know.
+type dmm struct {
+	errors []string
+}
+
+func (d *dmm) Handle(msg []byte) string {
+	switch string(msg) {
+	case "*IDN?":
+		return "KEYSIGHT TECHNOLOGIES,34465A,MY12345678,A.02.14-02.40-02.14-00.49-01-01\n"
+	case "*OPC?":
+		return "1\n"
+	case "*ESR?":
+		return "33\n"
+	case "SYST:ERR?":
+		if len(d.errors) == 0 {
+			return "+0,\"No error\"\n"
+		}
+		e := d.errors[0]
+		d.errors = d.errors[1:]
+		return e + "\n"
+	case "*RST", "*CLS", "CONF:VOLT:DC":
+		return ""
+	}
+	d.errors = append(d.errors, `-113,"Undefined header"`)
+	return ""
+}
+
+func newInstrument(t *testing.T, opts ...Option) (*Instrument, *dmm) {
+	t.Helper()
+	adapter := emulator.New()
+	d := &dmm{}
+	adapter.Attach(22, d)
+	c, err := prologix.NewController(adapter, 22, false)
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	return New(c, opts...), d
+}
+
+func TestIDN(t *testing.T) {
+	inst, _ := newInstrument(t)
+	got, err := inst.IDN()
+	if err != nil {
+		t.Fatalf("IDN error: %s", err)
+	}
+	want := Identity{"KEYSIGHT TECHNOLOGIES", "34465A", "MY12345678", "A.02.14-02.40-02.14-00.49-01-01"}
+	if got != want {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestParseIdentity(t *testing.T) {
+	tests := []struct {
+		given string
+		want  Identity
+	}{
+		{"FLUKE, 45, 9215022, 2.0 D2.0\r\n", Identity{"FLUKE", "45", "9215022", "2.0 D2.0"}},
+		{"StanfordResearchSystems,DS345,12345,1.04\n", Identity{"StanfordResearchSystems", "DS345", "12345", "1.04"}},
+		{"HP3478A\n", Identity{Manufacturer: "HP3478A"}},
+	}
+	for _, test := range tests {
+		got, err := ParseIdentity(test.given)
+		if err != nil {
+			t.Errorf("ParseIdentity(%q) error: %s", test.given, err)
+		}
+		if got != test.want {
+			t.Errorf("ParseIdentity(%q)\n\tgot  %+v\n\twant %+v", test.given, got, test.want)
+		}
+	}
+	if _, err := ParseIdentity("\n"); !errors.Is(err, prologix.ErrUnexpectedResponse) {
+		t.Errorf("got %v; want ErrUnexpectedResponse", err)
+	}
+}
+
+func TestCheckedMode(t *testing.T) {
+	inst, d := newInstrument(t, WithChecked())
+	if err := inst.Command("CONF:VOLT:DC"); err != nil {
+		t.Errorf("Command error: %s", err)
+	}
+	err := inst.Command("BOGUS")
+	var instErr *Error
+	if !errors.As(err, &instErr) || instErr.Code != -113 || instErr.Message != "Undefined header" {
+		t.Errorf("got %v; want -113 Undefined header", err)
+	}
+	if len(d.errors) != 0 {
+		t.Errorf("error queue not drained: %v", d.errors)
+	}
+
+	// Multiple errors are combined.
+	d.errors = []string{`-222,"Data out of range"`, `-113,"Undefined header"`}
+	if err := inst.Clear(); len(multierr.Errors(err)) != 2 {
+		t.Errorf("got %v; want 2 errors", err)
+	}
+}
+
+func TestUncheckedMode(t *testing.T) {
+	inst, d := newInstrument(t)
+	if err := inst.Command("BOGUS"); err != nil {
+		t.Errorf("Command error: %s", err)
+	}
+	if len(d.errors) != 1 {
+		t.Errorf("got %d queued errors; want 1", len(d.errors))
+	}
+	errs, err := inst.Errors()
+	if err != nil || len(errs) != 1 {
+		t.Errorf("Errors got %v, %v; want 1 error", errs, err)
+	}
+}
+
+func TestWaitOPCAndESR(t *testing.T) {
+	inst, _ := newInstrument(t)
+	if err := inst.WaitOPC(time.Second); err != nil {
+		t.Errorf("WaitOPC error: %s", err)
+	}
+	esr, err := inst.ESR()
+	if err != nil {
+		t.Fatalf("ESR error: %s", err)
+	}
+	if esr != OperationComplete|CommandError || !esr.HasErrors() {
+		t.Errorf("got %s; want operation complete and command error", esr)
+	}
+	if got, want := esr.String(), "operation complete, command error"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestIsQuery(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want bool
+	}{
+		{"*IDN?", true},
+		{"VOLT 1;*OPC?", true},
+		{"*RST", false},
+		{`DISP:TEXT "READY?"`, false},
+		{`DISP:TEXT 'A ''?'' B'`, false},
+		{`DISP:TEXT "READY?";:DISP:TEXT?`, true},
+		{"DATA #13a?b", false},
+		{"DATA #13a?b;*OPC?", true},
+		{"DATA #0a?b", false},
+		{"DATA #15a?", false},
+		{"STAT:QUES:ENAB #H1F;ENAB?", true},
+	}
+	for _, tc := range tests {
+		if got := IsQuery(tc.msg); got != tc.want {
+			t.Errorf("IsQuery(%q) = %t; want %t", tc.msg, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package ethernet shares a Prologix GPIB-USB controller over the network by
+emulating the TCP interface of the Prologix GPIB-ETHERNET controller, so that
+clients written for the GPIB-ETHERNET, such as a prologix.Controller using a
+net.Conn, can use the GPIB-USB remotely.
+
+Each client has its own copy of the `addr`, `auto`, `eoi`, `eos`,
+`eot_enable`, `eot_char`, and `read_tmo_ms` settings, which are answered
+locally when queried. Before each message of a client is forwarded to the
+GPIB-USB, the settings of the GPIB-USB that differ from those of the client
+are restored, and any response is read and returned to that client only, so
+any number of clients can share the GPIB bus.
+
+To keep the shared GPIB-USB in controller mode, the `mode`, `savecfg`, and
+`verbose` commands are acknowledged but not forwarded, and `rst` is ignored.
+
+The end of an instrument's response is detected using the EOT character when
+the client enables `eot_enable`. Otherwise, the response is read until the
+read timeout of the client expires, which makes queries slower.
+*/
+package ethernet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/netserver"
+)
+
+// Port is the TCP port used by the Prologix GPIB-ETHERNET controller.
+const Port = 1234
+
+// esc is the Prologix escape character.
+const esc = 0x1b
+
+// Timing of reads from the GPIB-USB.
+const (
+	// readMargin is the extra time allowed beyond the Prologix read timeout
+	// for the response to reach the server.
+	readMargin = 200 * time.Millisecond
+	// eotGrace is how long to keep reading after the EOT character in case
+	// it was part of the data.
+	eotGrace = 20 * time.Millisecond
+)
+
+// Transport is the connection to the GPIB-USB, such as a *vcp.VCP.
+type Transport interface {
+	io.ReadWriter
+	SetReadDeadline(t time.Time) error
+}
+
+// flusher is implemented by transports that can discard unread data.
+type flusher interface {
+	Flush() error
+}
+
+// settings holds the per client Prologix settings.
+type settings struct {
+	addr        int
+	secondary   int // Zero if there is no secondary address.
+	auto        bool
+	eoi         bool
+	eos         int
+	eotEnable   bool
+	eotChar     byte
+	readTimeout int // Read timeout in milliseconds.
+}
+
+// defaults are the settings of a newly connected client, which match the
+// factory defaults of the GPIB-ETHERNET.
+var defaults = settings{
+	eoi:         true,
+	readTimeout: 500,
+}
+
+// commands returns the Prologix commands needed to change the settings of the
+// GPIB-USB from s to want.
+func (s settings) commands(want settings) []string {
+	var cmds []string
+	if s.addr != want.addr || s.secondary != want.secondary {
+		if want.secondary == 0 {
+			cmds = append(cmds, fmt.Sprintf("++addr %d", want.addr))
+		} else {
+			cmds = append(cmds, fmt.Sprintf("++addr %d %d", want.addr, want.secondary))
+		}
+	}
+	if s.auto != want.auto {
+		cmds = append(cmds, fmt.Sprintf("++auto %d", btoi(want.auto)))
+	}
+	if s.eoi != want.eoi {
+		cmds = append(cmds, fmt.Sprintf("++eoi %d", btoi(want.eoi)))
+	}
+	if s.eos != want.eos {
+		cmds = append(cmds, fmt.Sprintf("++eos %d", want.eos))
+	}
+	if s.eotEnable != want.eotEnable {
+		cmds = append(cmds, fmt.Sprintf("++eot_enable %d", btoi(want.eotEnable)))
+	}
+	if s.eotChar != want.eotChar {
+		cmds = append(cmds, fmt.Sprintf("++eot_char %d", want.eotChar))
+	}
+	if s.readTimeout != want.readTimeout {
+		cmds = append(cmds, fmt.Sprintf("++read_tmo_ms %d", want.readTimeout))
+	}
+	return cmds
+}
+
+// Server serves the Prologix GPIB-ETHERNET protocol using a GPIB-USB.
+type Server struct {
+	rw      Transport
+	logger  *slog.Logger
+	mu      sync.Mutex // Serializes access to the GPIB-USB.
+	adapter settings   // Settings of the GPIB-USB.
+	group   netserver.Group
+}
+
+// Option applies an option to the Server.
+type Option func(*Server)
+
+// WithLogger sets the logger used to log connections and errors, which are
+// discarded by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) { s.logger = logger }
+}
+
+// New creates a Server sharing the GPIB-USB connected using the given
+// transport. The GPIB-USB is switched to controller mode and set to the
+// default settings, without saving them in its EEPROM.
+func New(rw Transport, opts ...Option) (*Server, error) {
+	s := Server{
+		rw:      rw,
+		logger:  netserver.DiscardLogger(),
+		adapter: defaults,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	// Send all settings, since the GPIB-USB may not be at its defaults.
+	cmds := []string{
+		"++savecfg 0",
+		"++mode 1",
+		fmt.Sprintf("++addr %d", defaults.addr),
+		fmt.Sprintf("++auto %d", btoi(defaults.auto)),
+		fmt.Sprintf("++eoi %d", btoi(defaults.eoi)),
+		fmt.Sprintf("++eos %d", defaults.eos),
+		fmt.Sprintf("++eot_enable %d", btoi(defaults.eotEnable)),
+		fmt.Sprintf("++eot_char %d", defaults.eotChar),
+		fmt.Sprintf("++read_tmo_ms %d", defaults.readTimeout),
+	}
+	if err := s.write(cmds, nil); err != nil {
+		return nil, fmt.Errorf("error configuring GPIB-USB: %w", err)
+	}
+	return &s, nil
+}
+
+// ListenAndServe listens on the TCP network address, such as ":1234", and
+// serves clients until the Server is closed.
+func (s *Server) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the listener and serves clients until the
+// Server is closed, after which it returns nil.
+func (s *Server) Serve(l net.Listener) error {
+	s.logger.Info("serving GPIB-USB", "listen", l.Addr().String())
+	return s.group.Serve(l, s.serveConn)
+}
+
+// Close stops all listeners, closes all client connections, and waits for the
+// connections to finish.
+func (s *Server) Close() error {
+	return s.group.Close()
+}
+
+// readKind determines what is read from the GPIB-USB after a message.
+type readKind int
+
+const (
+	readNone     readKind = iota
+	readLine              // A reply of the Prologix, terminated by LF.
+	readResponse          // A response of an instrument.
+)
+
+// serveConn parses the messages of one client, which are terminated by an
+// unescaped CR or LF.
+func (s *Server) serveConn(conn net.Conn) {
+	log := s.logger.With("client", conn.RemoteAddr().String())
+	log.Info("client connected")
+	defer log.Info("client disconnected")
+
+	client := defaults
+	r := bufio.NewReader(conn)
+	var msg []byte
+	escaped := false
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err != io.EOF && !s.group.Closed() {
+				log.Warn("read from client failed", "error", err)
+			}
+			return
+		}
+		switch {
+		case escaped:
+			msg = append(msg, b)
+			escaped = false
+			continue
+		case b == esc:
+			msg = append(msg, b)
+			escaped = true
+			continue
+		case b != '\r' && b != '\n':
+			msg = append(msg, b)
+			continue
+		}
+		if len(msg) == 0 {
+			continue
+		}
+		resp, err := s.handle(&client, msg)
+		msg = msg[:0]
+		if err != nil {
+			log.Warn("GPIB-USB transaction failed", "error", err)
+		}
+		if len(resp) == 0 {
+			continue
+		}
+		if _, err := conn.Write(resp); err != nil {
+			log.Warn("write to client failed", "error", err)
+			return
+		}
+	}
+}
+
+// handle processes one message of the client and returns the reply.
+func (s *Server) handle(client *settings, msg []byte) ([]byte, error) {
+	if len(msg) < 2 || msg[0] != '+' || msg[1] != '+' {
+		kind := readNone
+		if client.auto {
+			kind = readResponse
+		}
+		return s.transact(client, msg, kind)
+	}
+	cmd := strings.TrimSpace(string(msg[2:]))
+	name, arg, _ := strings.Cut(cmd, " ")
+	name = strings.ToLower(name)
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "addr":
+		if arg == "" {
+			if client.secondary == 0 {
+				return reply(client.addr), nil
+			}
+			return []byte(fmt.Sprintf("%d %d\r\n", client.addr, client.secondary)), nil
+		}
+		setAddr(client, arg)
+	case "auto":
+		return setBool(&client.auto, arg), nil
+	case "eoi":
+		return setBool(&client.eoi, arg), nil
+	case "eot_enable":
+		return setBool(&client.eotEnable, arg), nil
+	case "eos":
+		return setInt(&client.eos, arg, 0, 3), nil
+	case "eot_char":
+		v := int(client.eotChar)
+		resp := setInt(&v, arg, 0, 255)
+		client.eotChar = byte(v)
+		return resp, nil
+	case "read_tmo_ms":
+		return setInt(&client.readTimeout, arg, 1, 3000), nil
+	case "mode":
+		if arg == "" {
+			return reply(1), nil
+		}
+	case "savecfg":
+		if arg == "" {
+			return reply(0), nil
+		}
+	case "verbose", "rst":
+	case "ver", "spoll", "srq":
+		return s.transact(client, msg, readLine)
+	case "read":
+		return s.transact(client, msg, readResponse)
+	default:
+		return s.transact(client, msg, readNone)
+	}
+	return nil, nil
+}
+
+// transact restores the settings of the client, forwards the message to the
+// GPIB-USB, and reads the reply of the given kind.
+func (s *Server) transact(client *settings, msg []byte, kind readKind) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.rw.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	cmds := s.adapter.commands(*client)
+	if err := s.write(cmds, msg); err != nil {
+		return nil, err
+	}
+	s.adapter = *client
+	switch kind {
+	case readLine:
+		return s.read(client.readTimeout, true, '\n')
+	case readResponse:
+		return s.read(client.readTimeout, client.eotEnable, client.eotChar)
+	}
+	return nil, nil
+}
+
+// write sends the Prologix commands followed by the message, if any, in a
+// single write.
+func (s *Server) write(cmds []string, msg []byte) error {
+	var buf []byte
+	for _, cmd := range cmds {
+		buf = append(buf, cmd...)
+		buf = append(buf, '\n')
+	}
+	if msg != nil {
+		buf = append(buf, msg...)
+		buf = append(buf, '\n')
+	}
+	_, err := s.rw.Write(buf)
+	return err
+}
+
+// read reads until the read timeout expires or, if useEOT is true, shortly
+// after the data ends with the EOT character.
+func (s *Server) read(timeoutMS int, useEOT bool, eot byte) ([]byte, error) {
+	defer s.rw.SetReadDeadline(time.Time{})
+	deadline := time.Now().Add(time.Duration(timeoutMS)*time.Millisecond + readMargin)
+	var data []byte
+	buf := make([]byte, 4096)
+	for time.Now().Before(deadline) {
+		if err := s.rw.SetReadDeadline(deadline); err != nil {
+			return data, err
+		}
+		n, err := s.rw.Read(buf)
+		data = append(data, buf[:n]...)
+		if n > 0 && useEOT && buf[n-1] == eot {
+			deadline = time.Now().Add(eotGrace)
+		}
+		if err != nil {
+			if isTimeout(err) {
+				break
+			}
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// isTimeout reports whether the error is a read timeout of the transport.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, prologix.ErrTimeout) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// setAddr sets the primary and optional secondary address, ignoring invalid
+// addresses like the Prologix does.
+func setAddr(client *settings, arg string) {
+	fields := strings.Fields(arg)
+	addr, err := strconv.Atoi(fields[0])
+	if err != nil || addr < 0 || addr > 30 {
+		return
+	}
+	secondary := 0
+	if len(fields) > 1 {
+		secondary, err = strconv.Atoi(fields[1])
+		if err != nil || secondary < 96 || secondary > 126 {
+			return
+		}
+	}
+	client.addr, client.secondary = addr, secondary
+}
+
+// setBool sets the boolean setting or, if arg is empty, returns its value.
+func setBool(v *bool, arg string) []byte {
+	i := btoi(*v)
+	resp := setInt(&i, arg, 0, 1)
+	*v = i == 1
+	return resp
+}
+
+// setInt sets the integer setting if it's within range or, if arg is empty,
+// returns its value.
+func setInt(v *int, arg string, lo, hi int) []byte {
+	if arg == "" {
+		return reply(*v)
+	}
+	if i, err := strconv.Atoi(arg); err == nil && i >= lo && i <= hi {
+		*v = i
+	}
+	return nil
+}
+
+// reply formats a reply of the Prologix.
+func reply(v int) []byte {
+	return []byte(strconv.Itoa(v) + "\r\n")
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package ethernet
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/emulator"
+	"github.com/gotmc/prologix/internal/emulatortest"
+)
+
+// echo emulates an instrument that answers `ADDR?` with its address and
+// echoes any other query.
+func echo(addr int) emulator.InstrumentFunc {
+	return func(cmd string) string {
+		switch {
+		case cmd == "ADDR?":
+			return fmt.Sprintf("%d\n", addr)
+		case strings.HasSuffix(cmd, "?"):
+			return cmd + "\n"
+		}
+		return ""
+	}
+}
+
+func TestControllersShareAdapter(t *testing.T) {
+	adapter := emulatortest.NewAdapter(emulatortest.Instruments{5: echo(5), 10: echo(10)})
+	s, err := New(adapter)
+	if err != nil {
+		t.Fatalf("New error: %s", err)
+	}
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	tests := []struct {
+		addr int
+		opts []prologix.ControllerOption
+	}{
+		{5, []prologix.ControllerOption{prologix.WithReadAfterWrite(true)}},
+		{10, nil},
+		{10, []prologix.ControllerOption{prologix.WithGPIBTermination(prologix.AppendLF)}},
+	}
+	var wg sync.WaitGroup
+	for _, test := range tests {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("Dial error: %s", err)
+		}
+		defer conn.Close()
+		c, err := prologix.NewController(conn, test.addr, false, test.opts...)
+		if err != nil {
+			t.Fatalf("NewController error: %s", err)
+		}
+		wg.Add(1)
+		go func(c *prologix.Controller, addr int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				got, err := c.QueryInt("ADDR?")
+				if err != nil {
+					t.Errorf("address %d query error: %s", addr, err)
+					return
+				}
+				if got != addr {
+					t.Errorf("got address %d; want %d", got, addr)
+				}
+			}
+		}(c, test.addr)
+	}
+	wg.Wait()
+	if got := adapter.Setting("savecfg"); got != "0" {
+		t.Errorf("savecfg = %s; want 0", got)
+	}
+}
+
+func TestLocalSettings(t *testing.T) {
+	adapter := emulatortest.NewAdapter(emulatortest.Instruments{5: echo(5), 10: echo(10)})
+	s, err := New(adapter)
+	if err != nil {
+		t.Fatalf("New error: %s", err)
+	}
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	steps := []struct {
+		send string
+		want string
+	}{
+		{"++addr 7 96\n++addr\n", "7 96\r\n"},
+		{"++eos 9\r++eos\r", "0\r\n"},
+		{"++read_tmo_ms 1200\n++read_tmo_ms\n", "1200\r\n"},
+		{"++mode 0\n++mode\n", "1\r\n"},
+		{"++ver\n", emulator.Version + "\r\n"},
+	}
+	for _, step := range steps {
+		fmt.Fprint(conn, step.send)
+		got, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%q: read error: %s", step.send, err)
+		}
+		if got != step.want {
+			t.Errorf("%q: got %q; want %q", step.send, got, step.want)
+		}
+	}
+	// The settings of the client are applied before forwarding ++ver.
+	if got := adapter.Setting("addr"); got != "7 96" {
+		t.Errorf("adapter addr = %q; want 7 96", got)
+	}
+	if got := adapter.Setting("mode"); got != "1" {
+		t.Errorf("adapter mode = %q; want 1", got)
+	}
+}
@@ -0,0 +1,336 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package gateway provides an HTTP/JSON API for the GPIB instruments of a
+prologix.Controller, so that dashboards and scripts can use the instruments
+without linking Go or serial drivers.
+
+The Server is an http.Handler providing the following endpoints, where {addr}
+is the primary GPIB address of the instrument:
+
+	POST /gpib/{addr}/command  {"command": "VOLT 1.5"}
+	POST /gpib/{addr}/query    {"query": "MEAS:VOLT?", "timeout": "10s"}
+	GET  /gpib/{addr}/stb
+	POST /gpib/{addr}/clear
+	GET  /controller
+
+A command or clear returns 204 No Content. A query returns the response of
+the instrument without its trailing newline, such as {"response": "1.499"}.
+The optional timeout of a query, in the format of time.ParseDuration, allows
+slow instruments to respond after the read timeout of the Prologix. The stb
+endpoint serial polls the instrument and returns its status byte, such as
+{"stb": 80}. The controller endpoint returns the version and settings of the
+Prologix controller.
+
+Errors are returned as {"error": "..."} with the status 400 Bad Request for an
+invalid request, 504 Gateway Timeout if the instrument or Prologix controller
+timed out, and 502 Bad Gateway for other errors communicating over GPIB.
+Access to the GPIB bus is serialized using a prologix.Device per address, so
+any number of requests can be served concurrently.
+*/
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/netserver"
+)
+
+// maxBody limits the size of a request body.
+const maxBody = 1 << 20
+
+// Server serves the HTTP/JSON API for the instruments of a Controller.
+type Server struct {
+	c       *prologix.Controller
+	logger  *slog.Logger
+	mu      sync.Mutex
+	devices map[int]*prologix.Device
+}
+
+// Option applies an option to the Server.
+type Option func(*Server)
+
+// WithLogger sets the logger used to log failed requests, which are discarded
+// by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) { s.logger = logger }
+}
+
+// New creates a Server for the instruments of the given Controller. While the
+// Server is in use, the Controller must not be used directly.
+func New(c *prologix.Controller, opts ...Option) *Server {
+	s := Server{
+		c:       c,
+		logger:  netserver.DiscardLogger(),
+		devices: make(map[int]*prologix.Device),
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return &s
+}
+
+// CommandRequest is the body of a command request.
+type CommandRequest struct {
+	Command string `json:"command"`
+}
+
+// QueryRequest is the body of a query request. Timeout is optional and uses
+// the format of time.ParseDuration, such as "10s".
+type QueryRequest struct {
+	Query   string `json:"query"`
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// QueryResponse is the result of a query request.
+type QueryResponse struct {
+	Response string `json:"response"`
+}
+
+// StatusByteResponse is the result of a stb request.
+type StatusByteResponse struct {
+	StatusByte byte `json:"stb"`
+}
+
+// ControllerResponse is the result of a controller request.
+type ControllerResponse struct {
+	Version          string `json:"version"`
+	Address          int    `json:"address"`
+	SecondaryAddress int    `json:"secondary_address,omitempty"`
+	Mode             string `json:"mode"`
+	ReadAfterWrite   bool   `json:"read_after_write"`
+	AssertEOI        bool   `json:"assert_eoi"`
+	GPIBTermination  int    `json:"gpib_termination"`
+	EOTEnable        bool   `json:"eot_enable"`
+	EOTChar          int    `json:"eot_char"`
+	ReadTimeout      int    `json:"read_timeout_ms"`
+	SaveConfig       bool   `json:"savecfg"`
+}
+
+// ErrorResponse is the body of an error response.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// errBadRequest marks errors caused by an invalid request.
+var errBadRequest = errors.New("bad request")
+
+// ServeHTTP serves the API.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "controller" {
+		if allow(w, r, http.MethodGet) {
+			s.controller(w, r)
+		}
+		return
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || parts[0] != "gpib" {
+		http.NotFound(w, r)
+		return
+	}
+	var handle func(http.ResponseWriter, *http.Request, *prologix.Device)
+	var method string
+	switch parts[2] {
+	case "command":
+		handle, method = s.command, http.MethodPost
+	case "query":
+		handle, method = s.query, http.MethodPost
+	case "stb":
+		handle, method = s.statusByte, http.MethodGet
+	case "clear":
+		handle, method = s.clear, http.MethodPost
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if !allow(w, r, method) {
+		return
+	}
+	dev, err := s.device(parts[1])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	handle(w, r, dev)
+}
+
+func (s *Server) command(w http.ResponseWriter, r *http.Request, dev *prologix.Device) {
+	var req CommandRequest
+	if err := decode(r, &req); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if req.Command == "" {
+		s.fail(w, r, fmt.Errorf("%w: missing command", errBadRequest))
+		return
+	}
+	if err := dev.Command(req.Command); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) query(w http.ResponseWriter, r *http.Request, dev *prologix.Device) {
+	var req QueryRequest
+	if err := decode(r, &req); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if req.Query == "" {
+		s.fail(w, r, fmt.Errorf("%w: missing query", errBadRequest))
+		return
+	}
+	var resp string
+	var err error
+	if req.Timeout == "" {
+		resp, err = dev.Query(req.Query)
+	} else {
+		timeout, perr := time.ParseDuration(req.Timeout)
+		if perr != nil || timeout <= 0 {
+			s.fail(w, r, fmt.Errorf("%w: invalid timeout %q", errBadRequest, req.Timeout))
+			return
+		}
+		resp, err = dev.QueryWithTimeout(req.Query, timeout)
+	}
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	reply(w, http.StatusOK, QueryResponse{Response: strings.TrimRight(resp, "\r\n")})
+}
+
+func (s *Server) statusByte(w http.ResponseWriter, r *http.Request, dev *prologix.Device) {
+	var stb byte
+	err := dev.Do(func(c *prologix.Controller) (err error) {
+		stb, err = c.SerialPoll()
+		return err
+	})
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	reply(w, http.StatusOK, StatusByteResponse{StatusByte: stb})
+}
+
+func (s *Server) clear(w http.ResponseWriter, r *http.Request, dev *prologix.Device) {
+	if err := dev.Do((*prologix.Controller).ClearDevice); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) controller(w http.ResponseWriter, r *http.Request) {
+	var ver string
+	var cfg prologix.Config
+	err := s.c.Do(func(c *prologix.Controller) (err error) {
+		if ver, err = c.Version(); err != nil {
+			return err
+		}
+		cfg, err = c.Snapshot()
+		return err
+	})
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	resp := ControllerResponse{
+		Version:         strings.TrimSpace(ver),
+		Address:         cfg.PrimaryAddr,
+		Mode:            "device",
+		ReadAfterWrite:  cfg.ReadAfterWrite,
+		AssertEOI:       cfg.AssertEOI,
+		GPIBTermination: int(cfg.GPIBTermination),
+		EOTEnable:       cfg.EOTEnable,
+		EOTChar:         int(cfg.EOTChar),
+		ReadTimeout:     cfg.ReadTimeout,
+		SaveConfig:      cfg.SaveConfig,
+	}
+	if cfg.HasSecondaryAddr {
+		resp.SecondaryAddress = cfg.SecondaryAddr
+	}
+	if cfg.Mode == prologix.ControllerMode {
+		resp.Mode = "controller"
+	}
+	reply(w, http.StatusOK, resp)
+}
+
+// device returns the Device for the GPIB address in the path, creating it if
+// needed.
+func (s *Server) device(addr string) (*prologix.Device, error) {
+	n, err := strconv.Atoi(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid GPIB address %q", errBadRequest, addr)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dev, ok := s.devices[n]; ok {
+		return dev, nil
+	}
+	dev, err := s.c.Device(n)
+	if err != nil {
+		return nil, err
+	}
+	s.devices[n] = dev
+	return dev, nil
+}
+
+// fail replies with the error, using the status code for its cause.
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusBadGateway
+	switch {
+	case errors.Is(err, errBadRequest), errors.Is(err, prologix.ErrInvalidAddress):
+		status = http.StatusBadRequest
+	case errors.Is(err, prologix.ErrTimeout):
+		status = http.StatusGatewayTimeout
+	}
+	if status != http.StatusBadRequest {
+		s.logger.Warn("request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	}
+	reply(w, status, ErrorResponse{Error: err.Error()})
+}
+
+// allow replies with 405 Method Not Allowed unless the request uses the given
+// method.
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	reply(w, http.StatusMethodNotAllowed, ErrorResponse{
+		Error: fmt.Sprintf("method %s not allowed", r.Method),
+	})
+	return false
+}
+
+// decode decodes the JSON request body into v.
+func decode(r *http.Request, v any) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: invalid JSON body: %s", errBadRequest, err)
+	}
+	return nil
+}
+
+// reply writes v as the JSON response body with the given status code.
+func reply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
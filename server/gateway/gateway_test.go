@@ -0,0 +1,121 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gotmc/prologix/internal/emulator"
+	"github.com/gotmc/prologix/internal/emulatortest"
+)
+
+// supply emulates a power supply with a voltage setting and a status byte.
+type supply struct {
+	mu      sync.Mutex
+	volt    string
+	cleared bool
+}
+
+func (p *supply) Handle(msg []byte) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cmd := string(msg)
+	switch {
+	case strings.HasPrefix(cmd, "VOLT "):
+		p.volt = strings.TrimPrefix(cmd, "VOLT ")
+	case cmd == "VOLT?":
+		return p.volt + "\n"
+	}
+	return ""
+}
+
+func (p *supply) Clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cleared = true
+}
+
+func (p *supply) StatusByte() byte { return 0x10 }
+
+func TestServer(t *testing.T) {
+	psu := &supply{}
+	c, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: psu})
+	srv := httptest.NewServer(New(c))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{"command", "POST", "/gpib/5/command", `{"command": "VOLT 1.5"}`, 204, ""},
+		{"query", "POST", "/gpib/5/query", `{"query": "VOLT?"}`, 200, `{"response":"1.5"}`},
+		{"query with timeout", "POST", "/gpib/5/query", `{"query": "VOLT?", "timeout": "5s"}`, 200,
+			`{"response":"1.5"}`},
+		{"stb", "GET", "/gpib/5/stb", "", 200, `{"stb":16}`},
+		{"clear", "POST", "/gpib/5/clear", "", 204, ""},
+		{"invalid address", "GET", "/gpib/31/stb", "", 400, ""},
+		{"non-numeric address", "GET", "/gpib/dmm/stb", "", 400, ""},
+		{"missing command", "POST", "/gpib/5/command", `{}`, 400, ""},
+		{"invalid JSON", "POST", "/gpib/5/query", `{"query": 5}`, 400, ""},
+		{"invalid timeout", "POST", "/gpib/5/query", `{"query": "VOLT?", "timeout": "x"}`, 400, ""},
+		{"wrong method", "GET", "/gpib/5/command", "", 405, ""},
+		{"unknown endpoint", "GET", "/gpib/5/idn", "", 404, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tc.status {
+				t.Errorf("status = %d; want %d (%s)", resp.StatusCode, tc.status, body)
+			}
+			if tc.want != "" && strings.TrimSpace(string(body)) != tc.want {
+				t.Errorf("body = %s; want %s", body, tc.want)
+			}
+		})
+	}
+	psu.mu.Lock()
+	defer psu.mu.Unlock()
+	if !psu.cleared {
+		t.Error("instrument not cleared")
+	}
+}
+
+func TestController(t *testing.T) {
+	c, _ := emulatortest.NewController(t, 5, nil)
+	srv := httptest.NewServer(New(c))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got ControllerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if got.Version != emulator.Version || got.Address != 5 || got.Mode != "controller" {
+		t.Errorf("controller = %+v", got)
+	}
+}
@@ -0,0 +1,752 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package hislip exposes the GPIB instruments of a prologix.Controller as HiSLIP
+(IVI-6.1) instruments, so that VISA software can use them as
+TCPIP::host::hislipN::INSTR resources without custom drivers.
+
+The sub-address of a session selects the GPIB address of its instrument:
+"hislipN" and "gpib0,N" both select primary GPIB address N. Each session uses
+a synchronous and an asynchronous channel, both served on the same port.
+
+The sub-address "hislip0", which VISA uses when a resource string such as
+TCPIP::host::INSTR doesn't give one, selects the default GPIB address instead
+of GPIB address 0, which is normally the address of the Prologix controller
+itself. The default is the address of the Controller when the Server is
+created and can be set using WithDefaultAddress. Use "gpib0,0" to select an
+instrument at GPIB address 0.
+
+Every Data and DataEnd message completed by DataEnd is sent to the instrument
+using Controller.WriteBinary, without its trailing newline, which the Prologix
+replaces with the GPIB termination and EOI. Like the SCPI-RAW server, messages
+containing a `?` outside quoted strings and binary blocks are treated as
+queries and the response of the instrument is returned to the client. A GPIB
+instrument can't otherwise indicate that it has a response to send.
+
+The Server also supports trigger, device clear, status queries using a serial
+poll, remote/local control, and exclusive and shared locks. While the GPIB SRQ
+line is asserted, the instruments with sessions are serial polled and an
+AsyncServiceRequest message is sent to the sessions of each instrument
+requesting service.
+
+The Server implements version 1.0 of the protocol in synchronized mode. The
+credit based flow control and encryption of version 2.0 are not supported.
+*/
+package hislip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/netserver"
+	"github.com/gotmc/prologix/scpi"
+)
+
+// Port is the IANA registered TCP port of HiSLIP.
+const Port = 4880
+
+const (
+	// protocolVersion is the HiSLIP version 1.0 implemented by the Server.
+	protocolVersion = 0x0100
+	// vendorID is the two character vendor ID of the Server.
+	vendorID = 'P'<<8 | 'X'
+	// maxMessageSize is the largest payload accepted from a client.
+	maxMessageSize = 1 << 20
+	// maxBuffered limits the length of a message sent in several Data
+	// messages.
+	maxBuffered = 16 << 20
+	// rqs is the request service bit of the status byte.
+	rqs = 0x40
+)
+
+// AsyncLockResponse control codes.
+const (
+	lockFailure        = 0
+	lockSuccess        = 1
+	lockReleasedShared = 2
+	lockError          = 3
+)
+
+// AsyncRemoteLocalControl request codes.
+const (
+	disableRemote      = 0
+	goToLocalDisable   = 2
+	enableLocalLockout = 4
+	goToRemoteLockout  = 5
+	goToLocal          = 6
+)
+
+// DefaultSRQPollInterval is the default interval at which the GPIB SRQ line is
+// checked.
+const DefaultSRQPollInterval = 250 * time.Millisecond
+
+// Server serves HiSLIP sessions for the instruments of a Controller.
+type Server struct {
+	c         *prologix.Controller
+	logger    *slog.Logger
+	srqPoll   time.Duration
+	defAddr   int
+	mu        sync.Mutex
+	cond      *sync.Cond // Signaled when a lock is released or a session closed.
+	devices   map[int]*prologix.Device
+	sessions  map[uint16]*session
+	locks     map[int]*lock
+	lastID    uint16
+	group     netserver.Group
+	startOnce sync.Once
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// session is a HiSLIP session, which consists of a synchronous and an
+// asynchronous channel. The fields other than asyncMu are guarded by the
+// Server's mutex.
+type session struct {
+	id        uint16
+	dev       *prologix.Device
+	syncConn  net.Conn
+	asyncConn net.Conn
+	asyncMu   sync.Mutex // Serializes messages sent on the asynchronous channel.
+	maxSize   uint64     // Largest payload accepted by the client.
+	closed    bool
+}
+
+// lock is the lock state of an instrument, which is either locked exclusively
+// by one session or shared by the sessions using the same lock string.
+type lock struct {
+	exclusive *session
+	shared    string
+	sharers   map[*session]bool
+}
+
+// Option applies an option to the Server.
+type Option func(*Server)
+
+// WithLogger sets the logger used to log connections and errors, which are
+// discarded by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) { s.logger = logger }
+}
+
+// WithSRQPollInterval sets the interval at which the GPIB SRQ line is checked
+// while any session is open. An interval of zero disables SRQ forwarding.
+func WithSRQPollInterval(interval time.Duration) Option {
+	return func(s *Server) { s.srqPoll = interval }
+}
+
+// WithDefaultAddress sets the GPIB address selected by the sub-address
+// "hislip0", which defaults to the address of the Controller when the Server
+// is created.
+func WithDefaultAddress(addr int) Option {
+	return func(s *Server) { s.defAddr = addr }
+}
+
+// New creates a Server for the instruments of the given Controller. While the
+// Server is in use, the Controller must not be used directly.
+func New(c *prologix.Controller, opts ...Option) *Server {
+	s := Server{
+		c:        c,
+		logger:   netserver.DiscardLogger(),
+		srqPoll:  DefaultSRQPollInterval,
+		defAddr:  c.Config().PrimaryAddr,
+		devices:  make(map[int]*prologix.Device),
+		sessions: make(map[uint16]*session),
+		locks:    make(map[int]*lock),
+		done:     make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return &s
+}
+
+// ListenAndServe listens on the TCP network address, such as ":4880", and
+// serves HiSLIP sessions. It blocks until the Server is closed.
+func (s *Server) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the listener and serves HiSLIP sessions. It
+// blocks until the Server is closed, after which it returns nil. The listener
+// is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.startOnce.Do(func() { go s.pollSRQ() })
+	s.logger.Info("serving HiSLIP", "listen", l.Addr().String())
+	return s.group.Serve(l, s.serveConn)
+}
+
+// Close stops all listeners, closes all sessions, and waits for the
+// connections to finish.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return s.group.Close()
+}
+
+// serveConn serves a connection, which is the synchronous or asynchronous
+// channel of a session depending on its first message.
+func (s *Server) serveConn(conn net.Conn) {
+	log := s.logger.With("client", conn.RemoteAddr().String())
+	m, err := readMessage(conn, maxMessageSize)
+	switch {
+	case errors.Is(err, errBadHeader):
+		fatal(conn, fatalBadHeader, err.Error())
+	case err != nil:
+		log.Warn("read from client failed", "error", err)
+	case m.typ == msgInitialize:
+		s.serveSync(log, conn, m)
+	case m.typ == msgAsyncInitialize:
+		s.serveAsync(log, conn, m)
+	default:
+		fatal(conn, fatalInvalidInitialize, "expected Initialize or AsyncInitialize")
+	}
+}
+
+// serveSync serves the synchronous channel of a new session.
+func (s *Server) serveSync(log *slog.Logger, conn net.Conn, init message) {
+	addr, err := parseSubAddress(string(init.payload), s.defAddr)
+	if err != nil {
+		fatal(conn, fatalInvalidInitialize, err.Error())
+		return
+	}
+	sess, err := s.open(addr, conn)
+	if err != nil {
+		fatal(conn, fatalInvalidInitialize, err.Error())
+		return
+	}
+	defer s.closeSession(sess)
+	log = log.With("session", sess.id, "address", addr)
+	log.Info("session opened", "sub-address", string(init.payload))
+	defer log.Info("session closed")
+
+	err = writeMessage(conn, message{
+		typ:   msgInitializeResponse,
+		param: protocolVersion<<16 | uint32(sess.id),
+	})
+	if err != nil {
+		log.Warn("write to client failed", "error", err)
+		return
+	}
+
+	var buf []byte
+	for {
+		m, err := readMessage(conn, maxMessageSize)
+		switch {
+		case errors.Is(err, errTooLarge):
+			buf = nil
+			sendError(conn, errMessageTooLarge, "message exceeds maximum size")
+			continue
+		case errors.Is(err, errBadHeader):
+			fatal(conn, fatalBadHeader, err.Error())
+			return
+		case err != nil:
+			if !errors.Is(err, io.EOF) && !s.group.Closed() {
+				log.Warn("read from client failed", "error", err)
+			}
+			return
+		}
+		if !s.asyncReady(sess) {
+			fatal(conn, fatalChannelsNotReady, "asynchronous channel not initialized")
+			return
+		}
+
+		switch m.typ {
+		case msgData, msgDataEnd:
+			if len(buf)+len(m.payload) > maxBuffered {
+				buf = nil
+				sendError(conn, errMessageTooLarge, "message exceeds maximum size")
+				continue
+			}
+			buf = append(buf, m.payload...)
+			if m.typ == msgData {
+				continue
+			}
+			resp, ok := s.execute(log, sess, buf)
+			buf = nil
+			if ok {
+				if err := s.respond(sess, m.param, resp); err != nil {
+					log.Warn("write to client failed", "error", err)
+					return
+				}
+			}
+		case msgTrigger:
+			if s.waitAccess(sess) {
+				if err := sess.dev.Do((*prologix.Controller).Trigger); err != nil {
+					log.Warn("trigger failed", "error", err)
+				}
+			}
+		case msgDeviceClearComplete:
+			buf = nil
+			if err := sess.dev.Do((*prologix.Controller).ClearDevice); err != nil {
+				log.Warn("device clear failed", "error", err)
+			}
+			if err := writeMessage(conn, message{typ: msgDeviceClearAcknowledge}); err != nil {
+				log.Warn("write to client failed", "error", err)
+				return
+			}
+		default:
+			sendError(conn, errUnrecognizedType, fmt.Sprintf("unexpected message type %d", m.typ))
+		}
+	}
+}
+
+// serveAsync serves the asynchronous channel of the session given by the
+// AsyncInitialize message.
+func (s *Server) serveAsync(log *slog.Logger, conn net.Conn, init message) {
+	sess := s.attachAsync(uint16(init.param), conn)
+	if sess == nil {
+		fatal(conn, fatalInvalidInitialize, "unknown session")
+		return
+	}
+	defer s.closeSession(sess)
+	log = log.With("session", sess.id, "address", sess.dev.Address())
+
+	if err := sess.writeAsync(message{typ: msgAsyncInitializeResponse, param: vendorID}); err != nil {
+		log.Warn("write to client failed", "error", err)
+		return
+	}
+	for {
+		m, err := readMessage(conn, maxMessageSize)
+		switch {
+		case errors.Is(err, errTooLarge):
+			sendError(conn, errMessageTooLarge, "message exceeds maximum size")
+			continue
+		case errors.Is(err, errBadHeader):
+			fatal(conn, fatalBadHeader, err.Error())
+			return
+		case err != nil:
+			if !errors.Is(err, io.EOF) && !s.group.Closed() {
+				log.Warn("read from client failed", "error", err)
+			}
+			return
+		}
+
+		var reply message
+		switch m.typ {
+		case msgAsyncMaximumMessageSize:
+			if len(m.payload) != 8 {
+				fatal(conn, fatalBadHeader, "invalid maximum message size")
+				return
+			}
+			s.mu.Lock()
+			sess.maxSize = max(binary.BigEndian.Uint64(m.payload), 1)
+			s.mu.Unlock()
+			reply = message{
+				typ:     msgAsyncMaximumMessageSizeResponse,
+				payload: binary.BigEndian.AppendUint64(nil, maxMessageSize),
+			}
+		case msgAsyncLock:
+			var code byte
+			if m.control == 0 {
+				code = s.unlock(sess)
+			} else {
+				timeout := time.Duration(m.param) * time.Millisecond
+				code = s.lock(sess, string(m.payload), timeout)
+			}
+			reply = message{typ: msgAsyncLockResponse, control: code}
+		case msgAsyncLockInfo:
+			exclusive, holders := s.lockInfo(sess)
+			reply = message{typ: msgAsyncLockInfoResponse, param: holders}
+			if exclusive {
+				reply.control = 1
+			}
+		case msgAsyncRemoteLocalControl:
+			if err := remoteLocal(sess.dev, m.control); err != nil {
+				log.Warn("remote/local control failed", "error", err)
+			}
+			reply = message{typ: msgAsyncRemoteLocalResponse}
+		case msgAsyncDeviceClear:
+			reply = message{typ: msgAsyncDeviceClearAcknowledge}
+		case msgAsyncStatusQuery:
+			var stb byte
+			err := sess.dev.Do(func(c *prologix.Controller) (err error) {
+				stb, err = c.SerialPoll()
+				return err
+			})
+			if err != nil {
+				log.Warn("serial poll failed", "error", err)
+			}
+			reply = message{typ: msgAsyncStatusResponse, control: stb}
+		default:
+			reply = message{
+				typ:     msgError,
+				control: errUnrecognizedType,
+				payload: []byte(fmt.Sprintf("unexpected message type %d", m.typ)),
+			}
+		}
+		if err := sess.writeAsync(reply); err != nil {
+			log.Warn("write to client failed", "error", err)
+			return
+		}
+	}
+}
+
+// execute sends the message to the instrument and, if the message is a query,
+// returns the response. The second result is false if there is no response.
+func (s *Server) execute(log *slog.Logger, sess *session, msg []byte) (string, bool) {
+	// Only the terminator is removed, since the data of a binary block may end
+	// with CR or LF.
+	cmd := strings.TrimSuffix(string(msg), "\n")
+	cmd = strings.TrimSuffix(cmd, "\r")
+	if cmd == "" || !s.waitAccess(sess) {
+		return "", false
+	}
+	query := scpi.IsQuery(cmd)
+	var resp string
+	err := sess.dev.Do(func(c *prologix.Controller) (err error) {
+		if _, err = c.WriteBinary([]byte(cmd)); err != nil || !query {
+			return err
+		}
+		resp, err = c.ReadResponse()
+		return err
+	})
+	if err != nil {
+		// A HiSLIP instrument that can't answer simply doesn't respond, so the
+		// client times out as it would with a LAN instrument.
+		log.Warn("message failed", "message", cmd, "error", err)
+		return "", false
+	}
+	return resp, query
+}
+
+// respond sends the response using Data messages that fit the maximum message
+// size of the client, the last of which is a DataEnd message.
+func (s *Server) respond(sess *session, messageID uint32, resp string) error {
+	s.mu.Lock()
+	size := sess.maxSize
+	s.mu.Unlock()
+	data := []byte(resp)
+	for {
+		m := message{typ: msgDataEnd, param: messageID, payload: data}
+		if uint64(len(data)) > size {
+			m.typ, m.payload = msgData, data[:size]
+		}
+		if err := writeMessage(sess.syncConn, m); err != nil {
+			return err
+		}
+		if m.typ == msgDataEnd {
+			return nil
+		}
+		data = data[size:]
+	}
+}
+
+// open creates a session for the instrument at the GPIB address.
+func (s *Server) open(addr int, conn net.Conn) (*session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dev, ok := s.devices[addr]
+	if !ok {
+		var err error
+		if dev, err = s.c.Device(addr); err != nil {
+			return nil, err
+		}
+		s.devices[addr] = dev
+	}
+	for {
+		s.lastID++
+		if _, ok := s.sessions[s.lastID]; !ok && s.lastID != 0 {
+			break
+		}
+	}
+	sess := &session{id: s.lastID, dev: dev, syncConn: conn, maxSize: maxMessageSize}
+	s.sessions[sess.id] = sess
+	return sess, nil
+}
+
+// attachAsync attaches the asynchronous channel to the session with the given
+// ID. It returns nil if there is no such session or it already has an
+// asynchronous channel.
+func (s *Server) attachAsync(id uint16, conn net.Conn) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok || sess.asyncConn != nil {
+		return nil
+	}
+	sess.asyncConn = conn
+	return sess
+}
+
+// asyncReady reports whether the asynchronous channel of the session has been
+// initialized.
+func (s *Server) asyncReady(sess *session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sess.asyncConn != nil
+}
+
+// closeSession closes both channels of the session and releases its locks.
+func (s *Server) closeSession(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess.closed {
+		return
+	}
+	sess.closed = true
+	delete(s.sessions, sess.id)
+	if l, ok := s.locks[sess.dev.Address()]; ok {
+		l.release(sess)
+	}
+	s.cond.Broadcast()
+	sess.syncConn.Close()
+	if sess.asyncConn != nil {
+		sess.asyncConn.Close()
+	}
+}
+
+// lock requests a lock on the session's instrument, waiting up to timeout for
+// it to be granted. An empty lock string requests an exclusive lock.
+func (s *Server) lock(sess *session, key string, timeout time.Duration) byte {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.lockState(sess)
+	for !sess.closed {
+		if l.grant(sess, key) {
+			return lockSuccess
+		}
+		if !time.Now().Before(deadline) {
+			break
+		}
+		s.cond.Wait()
+	}
+	return lockFailure
+}
+
+// unlock releases the lock held by the session.
+func (s *Server) unlock(sess *session) byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code := s.lockState(sess).release(sess)
+	s.cond.Broadcast()
+	return code
+}
+
+// lockInfo reports whether the session's instrument is locked exclusively and
+// the number of sessions holding a lock on it.
+func (s *Server) lockInfo(sess *session) (bool, uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.lockState(sess)
+	if l.exclusive != nil {
+		return true, uint32(1 + len(l.sharers))
+	}
+	return false, uint32(len(l.sharers))
+}
+
+// waitAccess waits until the session's instrument isn't locked by another
+// session. It returns false if the session was closed while waiting.
+func (s *Server) waitAccess(sess *session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.lockState(sess)
+	for !sess.closed && !l.allows(sess) {
+		s.cond.Wait()
+	}
+	return !sess.closed
+}
+
+// lockState returns the lock state of the session's instrument. The Server's
+// mutex must be held.
+func (s *Server) lockState(sess *session) *lock {
+	addr := sess.dev.Address()
+	l, ok := s.locks[addr]
+	if !ok {
+		l = &lock{sharers: make(map[*session]bool)}
+		s.locks[addr] = l
+	}
+	return l
+}
+
+// grant grants the lock to the session if possible.
+func (l *lock) grant(sess *session, key string) bool {
+	if l.exclusive != nil && l.exclusive != sess {
+		return false
+	}
+	if key == "" {
+		if len(l.sharers) > 1 || len(l.sharers) == 1 && !l.sharers[sess] {
+			return false
+		}
+		l.exclusive = sess
+		return true
+	}
+	if len(l.sharers) > 0 && l.shared != key {
+		return false
+	}
+	l.shared = key
+	l.sharers[sess] = true
+	return true
+}
+
+// release releases the exclusive lock of the session, or its shared lock if it
+// holds no exclusive lock, and returns the AsyncLockResponse control code.
+func (l *lock) release(sess *session) byte {
+	switch {
+	case l.exclusive == sess:
+		l.exclusive = nil
+		return lockSuccess
+	case l.sharers[sess]:
+		delete(l.sharers, sess)
+		return lockReleasedShared
+	}
+	return lockError
+}
+
+// allows reports whether the session may access the instrument.
+func (l *lock) allows(sess *session) bool {
+	if l.exclusive != nil && l.exclusive != sess {
+		return false
+	}
+	return len(l.sharers) == 0 || l.sharers[sess] || l.exclusive == sess
+}
+
+// writeAsync sends a message on the asynchronous channel of the session.
+func (sess *session) writeAsync(m message) error {
+	sess.asyncMu.Lock()
+	defer sess.asyncMu.Unlock()
+	return writeMessage(sess.asyncConn, m)
+}
+
+// pollSRQ checks the GPIB SRQ line while any session is open until the Server
+// is closed.
+func (s *Server) pollSRQ() {
+	if s.srqPoll <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.srqPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.forwardSRQ()
+	}
+}
+
+// forwardSRQ serial polls the instruments with sessions if the GPIB SRQ line
+// is asserted, and sends an AsyncServiceRequest message to the sessions of the
+// instruments requesting service.
+func (s *Server) forwardSRQ() {
+	s.mu.Lock()
+	sessions := make(map[*prologix.Device][]*session)
+	for _, sess := range s.sessions {
+		if sess.asyncConn != nil && !sess.closed {
+			sessions[sess.dev] = append(sessions[sess.dev], sess)
+		}
+	}
+	s.mu.Unlock()
+
+	var dev *prologix.Device
+	for dev = range sessions {
+		break
+	}
+	if dev == nil {
+		return
+	}
+	var asserted bool
+	err := dev.Do(func(c *prologix.Controller) (err error) {
+		asserted, err = c.ServiceRequest()
+		return err
+	})
+	if err != nil {
+		s.logger.Warn("SRQ check failed", "error", err)
+		return
+	}
+	if !asserted {
+		return
+	}
+	for dev, group := range sessions {
+		var stb byte
+		err := dev.Do(func(c *prologix.Controller) (err error) {
+			stb, err = c.SerialPoll()
+			return err
+		})
+		if err != nil {
+			s.logger.Warn("serial poll failed", "address", dev.Address(), "error", err)
+			continue
+		}
+		if stb&rqs == 0 {
+			continue
+		}
+		for _, sess := range group {
+			err := sess.writeAsync(message{typ: msgAsyncServiceRequest, control: stb})
+			if err != nil {
+				s.logger.Warn("service request not sent", "session", sess.id, "error", err)
+			}
+		}
+	}
+}
+
+// remoteLocal carries out the AsyncRemoteLocalControl request. The Prologix
+// asserts REN and places the instrument in remote when it is addressed, so only
+// the requests for local control and local lockout need any action.
+func remoteLocal(dev *prologix.Device, request byte) error {
+	switch request {
+	case disableRemote, goToLocalDisable, goToLocal:
+		return dev.Do(func(c *prologix.Controller) error { return c.FrontPanel(true) })
+	case enableLocalLockout, goToRemoteLockout:
+		return dev.Do(func(c *prologix.Controller) error { return c.FrontPanel(false) })
+	}
+	return nil
+}
+
+// fatal sends a FatalError message, after which the connection is closed by
+// the caller.
+func fatal(conn net.Conn, code byte, msg string) {
+	_ = writeMessage(conn, message{typ: msgFatalError, control: code, payload: []byte(msg)})
+}
+
+// sendError sends an Error message, after which the connection can be used.
+func sendError(conn net.Conn, code byte, msg string) {
+	_ = writeMessage(conn, message{typ: msgError, control: code, payload: []byte(msg)})
+}
+
+// parseSubAddress parses a sub-address of the form "hislipN" or "gpib0,N" and
+// returns the primary GPIB address N. The default VISA sub-address "hislip0"
+// returns defAddr, since GPIB address 0 is normally the controller itself.
+func parseSubAddress(subAddr string, defAddr int) (int, error) {
+	name := strings.ToLower(strings.TrimSpace(subAddr))
+	var addr string
+	switch {
+	case strings.HasPrefix(name, "hislip"):
+		addr = strings.TrimPrefix(name, "hislip")
+	case strings.HasPrefix(name, "gpib0,"):
+		addr = strings.TrimPrefix(name, "gpib0,")
+	default:
+		return 0, fmt.Errorf("unsupported sub-address %q", subAddr)
+	}
+	n, err := strconv.Atoi(addr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid GPIB address in sub-address %q", subAddr)
+	}
+	if n == 0 && strings.HasPrefix(name, "hislip") {
+		return defAddr, nil
+	}
+	return n, nil
+}
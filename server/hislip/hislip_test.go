@@ -0,0 +1,281 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package hislip
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotmc/prologix/internal/emulatortest"
+)
+
+// meter emulates an instrument that answers `*IDN?` and counts triggers.
+type meter struct {
+	mu       sync.Mutex
+	triggers int
+	cleared  bool
+	messages []string
+}
+
+func (m *meter) Handle(msg []byte) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, string(msg))
+	if string(msg) == "*IDN?" {
+		return "ACME,METER,1,1.0\n"
+	}
+	return ""
+}
+
+func (m *meter) Trigger() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.triggers++
+}
+
+func (m *meter) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cleared = true
+}
+
+func (m *meter) StatusByte() byte { return 0x50 }
+
+// client is a HiSLIP client session.
+type client struct {
+	t           *testing.T
+	sync, async net.Conn
+}
+
+func (c *client) send(conn net.Conn, m message) {
+	c.t.Helper()
+	if err := writeMessage(conn, m); err != nil {
+		c.t.Fatalf("writeMessage error: %s", err)
+	}
+}
+
+func (c *client) receive(conn net.Conn, typ byte) message {
+	c.t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	m, err := readMessage(conn, maxMessageSize)
+	if err != nil {
+		c.t.Fatalf("readMessage error: %s", err)
+	}
+	if m.typ != typ {
+		c.t.Fatalf("message type = %d (%q); want %d", m.typ, m.payload, typ)
+	}
+	return m
+}
+
+// connect opens a session by initializing both channels.
+func connect(t *testing.T, address, subAddr string) *client {
+	t.Helper()
+	c := &client{t: t}
+	for _, conn := range []*net.Conn{&c.sync, &c.async} {
+		var err error
+		if *conn, err = net.Dial("tcp", address); err != nil {
+			t.Fatalf("Dial error: %s", err)
+		}
+		t.Cleanup(func() { (*conn).Close() })
+	}
+	c.send(c.sync, message{typ: msgInitialize, param: 0x0100<<16 | 'G'<<8 | 'O', payload: []byte(subAddr)})
+	resp := c.receive(c.sync, msgInitializeResponse)
+	if version := resp.param >> 16; version != protocolVersion {
+		t.Errorf("protocol version = %#x; want %#x", version, protocolVersion)
+	}
+	c.send(c.async, message{typ: msgAsyncInitialize, param: resp.param & 0xffff})
+	c.receive(c.async, msgAsyncInitializeResponse)
+	return c
+}
+
+func TestQuery(t *testing.T) {
+	gpib, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: &meter{}})
+	s := New(gpib)
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	c := connect(t, address, "hislip5")
+
+	c.send(c.async, message{
+		typ:     msgAsyncMaximumMessageSize,
+		payload: binary.BigEndian.AppendUint64(nil, 8),
+	})
+	c.receive(c.async, msgAsyncMaximumMessageSizeResponse)
+
+	const id = 0xffffff00
+	c.send(c.sync, message{typ: msgData, param: id, payload: []byte("*ID")})
+	c.send(c.sync, message{typ: msgDataEnd, param: id, payload: []byte("N?\n")})
+	var resp []byte
+	for {
+		conn := c.sync
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		m, err := readMessage(conn, maxMessageSize)
+		if err != nil {
+			t.Fatalf("readMessage error: %s", err)
+		}
+		if m.param != id {
+			t.Errorf("message ID = %#x; want %#x", m.param, id)
+		}
+		if len(m.payload) > 8 {
+			t.Errorf("payload length %d exceeds maximum message size", len(m.payload))
+		}
+		resp = append(resp, m.payload...)
+		if m.typ == msgDataEnd {
+			break
+		}
+	}
+	if got, want := string(resp), "ACME,METER,1,1.0\n"; got != want {
+		t.Errorf("response = %q; want %q", got, want)
+	}
+}
+
+func TestBinaryData(t *testing.T) {
+	m := &meter{}
+	gpib, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: m})
+	s := New(gpib)
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	c := connect(t, address, "hislip5")
+
+	// The `?` and the trailing LF bytes of the block are data, so the message
+	// is a command and only its terminator is removed.
+	const data = "DATA #15a?b\r\n"
+	c.send(c.sync, message{typ: msgDataEnd, param: 1, payload: []byte(data + "\n")})
+	c.send(c.sync, message{typ: msgDataEnd, param: 2, payload: []byte("*IDN?\n")})
+	if resp := c.receive(c.sync, msgDataEnd); resp.param != 2 {
+		t.Errorf("response to message %d; want 2", resp.param)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.messages) == 0 || m.messages[0] != data {
+		t.Errorf("messages = %q; want %q first", m.messages, data)
+	}
+}
+
+func TestControl(t *testing.T) {
+	m := &meter{}
+	gpib, adapter := emulatortest.NewController(t, 5, emulatortest.Instruments{5: m})
+	s := New(gpib)
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	c := connect(t, address, "gpib0,5")
+
+	c.send(c.async, message{typ: msgAsyncStatusQuery})
+	if stb := c.receive(c.async, msgAsyncStatusResponse).control; stb != 0x50 {
+		t.Errorf("status byte = %#x; want 0x50", stb)
+	}
+
+	c.send(c.sync, message{typ: msgTrigger})
+	c.send(c.async, message{typ: msgAsyncDeviceClear})
+	c.receive(c.async, msgAsyncDeviceClearAcknowledge)
+	c.send(c.sync, message{typ: msgDeviceClearComplete})
+	c.receive(c.sync, msgDeviceClearAcknowledge)
+	m.mu.Lock()
+	if m.triggers != 1 || !m.cleared {
+		t.Errorf("triggers = %d, cleared = %t; want 1, true", m.triggers, m.cleared)
+	}
+	m.mu.Unlock()
+
+	c.send(c.async, message{typ: msgAsyncRemoteLocalControl, control: goToLocal})
+	c.receive(c.async, msgAsyncRemoteLocalResponse)
+	if !adapter.Local(5) {
+		t.Error("instrument not returned to local control")
+	}
+}
+
+func TestLock(t *testing.T) {
+	gpib, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: &meter{}})
+	s := New(gpib)
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	a, b := connect(t, address, "hislip5"), connect(t, address, "hislip5")
+
+	a.send(a.async, message{typ: msgAsyncLock, control: 1, param: 0})
+	if code := a.receive(a.async, msgAsyncLockResponse).control; code != lockSuccess {
+		t.Fatalf("exclusive lock = %d; want %d", code, lockSuccess)
+	}
+	b.send(b.async, message{typ: msgAsyncLock, control: 1, param: 50, payload: []byte("key")})
+	if code := b.receive(b.async, msgAsyncLockResponse).control; code != lockFailure {
+		t.Errorf("shared lock while locked = %d; want %d", code, lockFailure)
+	}
+	b.send(b.async, message{typ: msgAsyncLockInfo})
+	if info := b.receive(b.async, msgAsyncLockInfoResponse); info.control != 1 || info.param != 1 {
+		t.Errorf("lock info = %d, %d; want 1, 1", info.control, info.param)
+	}
+
+	// The query of the other session waits for the lock to be released.
+	b.send(b.sync, message{typ: msgDataEnd, param: 1, payload: []byte("*IDN?\n")})
+	time.Sleep(50 * time.Millisecond)
+	a.send(a.async, message{typ: msgAsyncLock, control: 0})
+	if code := a.receive(a.async, msgAsyncLockResponse).control; code != lockSuccess {
+		t.Errorf("release = %d; want %d", code, lockSuccess)
+	}
+	if resp := b.receive(b.sync, msgDataEnd); string(resp.payload) != "ACME,METER,1,1.0\n" {
+		t.Errorf("response = %q", resp.payload)
+	}
+	a.send(a.async, message{typ: msgAsyncLock, control: 0})
+	if code := a.receive(a.async, msgAsyncLockResponse).control; code != lockError {
+		t.Errorf("release without lock = %d; want %d", code, lockError)
+	}
+}
+
+func TestServiceRequest(t *testing.T) {
+	gpib, adapter := emulatortest.NewController(t, 5, emulatortest.Instruments{5: &meter{}})
+	s := New(gpib, WithSRQPollInterval(5*time.Millisecond))
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	c := connect(t, address, "hislip5")
+	adapter.SetSRQ(true)
+	if stb := c.receive(c.async, msgAsyncServiceRequest).control; stb != 0x50 {
+		t.Errorf("status byte = %#x; want 0x50", stb)
+	}
+	adapter.SetSRQ(false)
+}
+
+func TestDefaultSubAddress(t *testing.T) {
+	// The Controller of the server is at GPIB address 5.
+	gpib, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: &meter{}})
+	s := New(gpib)
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	c := connect(t, address, "hislip0")
+	c.send(c.sync, message{typ: msgDataEnd, param: 1, payload: []byte("*IDN?\n")})
+	if got, want := string(c.receive(c.sync, msgDataEnd).payload), "ACME,METER,1,1.0\n"; got != want {
+		t.Errorf("response = %q; want %q", got, want)
+	}
+}
+
+func TestParseSubAddress(t *testing.T) {
+	tests := []struct {
+		subAddr string
+		want    int
+	}{
+		{"hislip0", 7},
+		{"HISLIP5", 5},
+		{"gpib0,0", 0},
+		{"gpib0,12", 12},
+	}
+	for _, tc := range tests {
+		got, err := parseSubAddress(tc.subAddr, 7)
+		if err != nil || got != tc.want {
+			t.Errorf("parseSubAddress(%q) = %d, %v; want %d", tc.subAddr, got, err, tc.want)
+		}
+	}
+}
+
+func TestInvalidSubAddress(t *testing.T) {
+	gpib, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: &meter{}})
+	s := New(gpib)
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	for _, subAddr := range []string{"inst0", "hislip31", "gpib1,5"} {
+		c := &client{t: t}
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("Dial error: %s", err)
+		}
+		c.send(conn, message{typ: msgInitialize, payload: []byte(subAddr)})
+		if code := c.receive(conn, msgFatalError).control; code != fatalInvalidInitialize {
+			t.Errorf("%q: fatal error %d; want %d", subAddr, code, fatalInvalidInitialize)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package hislip
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Message types from IVI-6.1.
+const (
+	msgInitialize                      = 0
+	msgInitializeResponse              = 1
+	msgFatalError                      = 2
+	msgError                           = 3
+	msgAsyncLock                       = 4
+	msgAsyncLockResponse               = 5
+	msgData                            = 6
+	msgDataEnd                         = 7
+	msgDeviceClearComplete             = 8
+	msgDeviceClearAcknowledge          = 9
+	msgAsyncRemoteLocalControl         = 10
+	msgAsyncRemoteLocalResponse        = 11
+	msgTrigger                         = 12
+	msgInterrupted                     = 13
+	msgAsyncInterrupted                = 14
+	msgAsyncMaximumMessageSize         = 15
+	msgAsyncMaximumMessageSizeResponse = 16
+	msgAsyncInitialize                 = 17
+	msgAsyncInitializeResponse         = 18
+	msgAsyncDeviceClear                = 19
+	msgAsyncServiceRequest             = 20
+	msgAsyncStatusQuery                = 21
+	msgAsyncStatusResponse             = 22
+	msgAsyncDeviceClearAcknowledge     = 23
+	msgAsyncLockInfo                   = 24
+	msgAsyncLockInfoResponse           = 25
+)
+
+// Fatal error codes sent with a FatalError message, after which the
+// connection is closed.
+const (
+	fatalBadHeader         = 1
+	fatalChannelsNotReady  = 2
+	fatalInvalidInitialize = 3
+)
+
+// Error codes sent with an Error message.
+const (
+	errUnrecognizedType = 1
+	errMessageTooLarge  = 4
+)
+
+// headerSize is the size of the message header, which consists of the "HS"
+// prologue, the message type, the control code, the message parameter, and the
+// payload length.
+const headerSize = 16
+
+var (
+	errBadHeader  = errors.New("hislip: invalid message header")
+	errTooLarge   = errors.New("hislip: message payload too large")
+	prologue      = [2]byte{'H', 'S'}
+	maxPayloadLen = uint64(1<<63 - 1)
+)
+
+// message is a HiSLIP message.
+type message struct {
+	typ     byte
+	control byte
+	param   uint32
+	payload []byte
+}
+
+// readMessage reads a message from r. A payload longer than max is discarded
+// and errTooLarge returned with the message header, so that the connection
+// can still be used.
+func readMessage(r io.Reader, max uint64) (message, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return message{}, err
+	}
+	if hdr[0] != prologue[0] || hdr[1] != prologue[1] {
+		return message{}, errBadHeader
+	}
+	m := message{
+		typ:     hdr[2],
+		control: hdr[3],
+		param:   binary.BigEndian.Uint32(hdr[4:]),
+	}
+	n := binary.BigEndian.Uint64(hdr[8:])
+	if n > max {
+		if n > maxPayloadLen {
+			return m, errBadHeader
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+			return m, err
+		}
+		return m, errTooLarge
+	}
+	m.payload = make([]byte, n)
+	if _, err := io.ReadFull(r, m.payload); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// writeMessage writes the message to w using a single write.
+func writeMessage(w io.Writer, m message) error {
+	buf := make([]byte, headerSize, headerSize+len(m.payload))
+	copy(buf, prologue[:])
+	buf[2], buf[3] = m.typ, m.control
+	binary.BigEndian.PutUint32(buf[4:], m.param)
+	binary.BigEndian.PutUint64(buf[8:], uint64(len(m.payload)))
+	_, err := w.Write(append(buf, m.payload...))
+	return err
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package scpiraw exposes the GPIB instruments of a prologix.Controller as
+SCPI-RAW TCP instruments, like the port 5025 socket of LAN instruments.
+
+Each GPIB address is served on its own TCP port. Every newline terminated
+message received from a client is sent to the instrument, escaped so that
+IEEE 488.2 definite length blocks containing newlines pass through intact.
+Messages containing a `?` outside of quoted strings and blocks are treated as
+queries, and the whole response, which may be a binary block, is written back
+to the client terminated by a newline. Access to the GPIB bus is serialized using a
+prologix.Device per address, so any number of clients can be connected to any
+number of ports.
+*/
+package scpiraw
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/netserver"
+	"github.com/gotmc/prologix/scpi"
+)
+
+// maxMessage limits the length of a message received from a client.
+const maxMessage = 1 << 20
+
+var errTooLong = errors.New("scpiraw: message too long")
+
+// Server proxies SCPI-RAW connections to the instruments of a Controller.
+type Server struct {
+	c       *prologix.Controller
+	logger  *slog.Logger
+	mu      sync.Mutex
+	devices map[int]*prologix.Device
+	group   netserver.Group
+}
+
+// Option applies an option to the Server.
+type Option func(*Server)
+
+// WithLogger sets the logger used to log connections and errors, which are
+// discarded by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) { s.logger = logger }
+}
+
+// New creates a Server for the instruments of the given Controller. While the
+// Server is in use, the Controller must not be used directly.
+func New(c *prologix.Controller, opts ...Option) *Server {
+	s := Server{
+		c:       c,
+		logger:  netserver.DiscardLogger(),
+		devices: make(map[int]*prologix.Device),
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return &s
+}
+
+// ListenAndServe listens on the TCP network address, such as ":5025", and
+// serves the instrument at the given GPIB address. It blocks until the Server
+// is closed.
+func (s *Server) ListenAndServe(gpibAddr int, address string) error {
+	if _, err := s.device(gpibAddr); err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(gpibAddr, l)
+}
+
+// Serve accepts connections on the listener and serves the instrument at the
+// given GPIB address. It blocks until the Server is closed, after which it
+// returns nil. The listener is closed when Serve returns.
+func (s *Server) Serve(gpibAddr int, l net.Listener) error {
+	dev, err := s.device(gpibAddr)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	s.logger.Info("serving instrument", "address", gpibAddr, "listen", l.Addr().String())
+	return s.group.Serve(l, func(conn net.Conn) { s.serveConn(dev, conn) })
+}
+
+// Close stops all listeners, closes all client connections, and waits for the
+// connections to finish.
+func (s *Server) Close() error {
+	return s.group.Close()
+}
+
+// serveConn proxies the messages of one client to the instrument.
+func (s *Server) serveConn(dev *prologix.Device, conn net.Conn) {
+	log := s.logger.With("address", dev.Address(), "client", conn.RemoteAddr().String())
+	log.Info("client connected")
+	defer log.Info("client disconnected")
+
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readMessage(r)
+		if err != nil {
+			if err != io.EOF && !s.group.Closed() {
+				log.Warn("read from client failed", "error", err)
+			}
+			return
+		}
+		// Only the terminator is removed, since the data of a binary block may
+		// end with CR or LF.
+		cmd := strings.TrimSuffix(string(msg), "\r")
+		if strings.TrimSpace(cmd) == "" {
+			continue
+		}
+		query := scpi.IsQuery(cmd)
+		var resp string
+		err = dev.Do(func(c *prologix.Controller) (err error) {
+			if _, err = c.WriteBinary([]byte(cmd)); err != nil || !query {
+				return err
+			}
+			resp, err = c.ReadResponse()
+			return err
+		})
+		if err != nil {
+			// A SCPI-RAW instrument that can't answer simply doesn't respond, so
+			// the client times out as it would with a LAN instrument.
+			log.Warn("message failed", "message", cmd, "error", err)
+			continue
+		}
+		if !query {
+			continue
+		}
+		if !strings.HasSuffix(resp, "\n") {
+			resp += "\n"
+		}
+		if _, err := io.WriteString(conn, resp); err != nil {
+			log.Warn("write to client failed", "error", err)
+			return
+		}
+	}
+}
+
+// readMessage reads a newline terminated message from the client and returns
+// it without the newline. IEEE 488.2 definite length blocks, such as
+// `#15a\nb\r\n`, are read using their length, since their data may contain
+// newlines.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	var msg []byte
+	var quote byte
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF && len(msg) > 0 {
+			return msg, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b == '\n' {
+			return msg, nil
+		}
+		if len(msg) >= maxMessage {
+			return nil, errTooLong
+		}
+		msg = append(msg, b)
+		switch {
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == '#':
+			n, ok := blockLength(r)
+			if !ok {
+				continue
+			}
+			if len(msg)+n > maxMessage {
+				return nil, errTooLong
+			}
+			block := make([]byte, n)
+			if _, err := io.ReadFull(r, block); err != nil {
+				return nil, err
+			}
+			msg = append(msg, block...)
+		}
+	}
+}
+
+// blockLength peeks at the header of a definite length block following a `#`
+// and returns the length of the header digits plus the data. Indefinite length
+// blocks, `#0`, and non-decimal numbers, such as `#H1F`, aren't read as
+// blocks.
+func blockLength(r *bufio.Reader) (int, bool) {
+	p, err := r.Peek(1)
+	if err != nil || p[0] < '1' || p[0] > '9' {
+		return 0, false
+	}
+	digits := int(p[0] - '0')
+	p, err = r.Peek(1 + digits)
+	if err != nil {
+		return 0, false
+	}
+	n := 0
+	for _, d := range p[1:] {
+		if d < '0' || d > '9' {
+			return 0, false
+		}
+		n = 10*n + int(d-'0')
+	}
+	return 1 + digits + n, true
+}
+
+// device returns the Device for the GPIB address, creating it if needed.
+func (s *Server) device(gpibAddr int) (*prologix.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dev, ok := s.devices[gpibAddr]; ok {
+		return dev, nil
+	}
+	dev, err := s.c.Device(gpibAddr)
+	if err != nil {
+		return nil, err
+	}
+	s.devices[gpibAddr] = dev
+	return dev, nil
+}
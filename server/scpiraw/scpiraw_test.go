@@ -0,0 +1,144 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package scpiraw
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotmc/prologix/internal/emulatortest"
+)
+
+// register emulates an instrument that remembers the last `VAL` setting and
+// the last `DATA` binary block.
+type register struct {
+	mu   sync.Mutex
+	val  string
+	data string
+}
+
+func (r *register) Handle(msg []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cmd := string(msg)
+	switch {
+	case strings.HasPrefix(cmd, "VAL "):
+		r.val = strings.TrimPrefix(cmd, "VAL ")
+	case cmd == "VAL?":
+		return r.val + "\n"
+	case strings.HasPrefix(cmd, "DATA "):
+		r.data = strings.TrimPrefix(cmd, "DATA ")
+	case cmd == "DATA?":
+		return r.data + "\n"
+	}
+	return ""
+}
+
+func (r *register) value() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.val
+}
+
+func TestServerProxiesMessages(t *testing.T) {
+	regs := map[int]*register{5: {}, 10: {}}
+	gpib, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: regs[5], 10: regs[10]})
+	s := New(gpib)
+	ports := make(map[int]string)
+	for addr := range regs {
+		addr := addr
+		ports[addr] = emulatortest.Serve(t, func(l net.Listener) error { return s.Serve(addr, l) }, s.Close)
+	}
+	var wg sync.WaitGroup
+	for addr, port := range ports {
+		wg.Add(1)
+		go func(addr int, port string) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", port)
+			if err != nil {
+				t.Errorf("Dial error: %s", err)
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			r := bufio.NewReader(conn)
+			for i := 0; i < 10; i++ {
+				want := fmt.Sprintf("%d-%d", addr, i)
+				fmt.Fprintf(conn, "VAL %s\n\nVAL?\n", want)
+				got, err := r.ReadString('\n')
+				if err != nil {
+					t.Errorf("address %d read error: %s", addr, err)
+					return
+				}
+				if got != want+"\n" {
+					t.Errorf("address %d got %q; want %q", addr, got, want+"\n")
+				}
+			}
+		}(addr, port)
+	}
+	wg.Wait()
+	if got := regs[10].value(); got != "10-9" {
+		t.Errorf("address 10 value %q; want 10-9", got)
+	}
+}
+
+func TestServerBinaryBlocks(t *testing.T) {
+	gpib, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: &register{}})
+	s := New(gpib)
+	address := emulatortest.Serve(t, func(l net.Listener) error { return s.Serve(5, l) }, s.Close)
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The block contains LF and CR, and a '?' that isn't a query.
+	const block = "#16a\n?\r\nb"
+	fmt.Fprintf(conn, "DATA %s\nDATA?\nVAL 7\nVAL?\n", block)
+	want := block + "\n7\n"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read error: %s", err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	gpib, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: &register{}})
+	s := New(gpib)
+	address := emulatortest.Serve(t, func(l net.Listener) error { return s.Serve(5, l) }, s.Close)
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	defer conn.Close()
+	done := make(chan error)
+	go func() { done <- s.Close() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't return with a client connected")
+	}
+	if _, err := net.Dial("tcp", address); err == nil {
+		t.Error("Dial succeeded after Close")
+	}
+}
+
+func TestServeInvalidAddress(t *testing.T) {
+	gpib, _ := emulatortest.NewController(t, 5, nil)
+	if err := New(gpib).ListenAndServe(31, "127.0.0.1:0"); err == nil {
+		t.Error("ListenAndServe succeeded for GPIB address 31")
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package vxi11
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// ONC RPC constants from RFC 5531.
+const (
+	rpcVersion = 2
+	msgCall    = 0
+	msgReply   = 1
+
+	msgAccepted = 0
+	msgDenied   = 1
+	rpcMismatch = 0
+
+	success      = 0
+	progUnavail  = 1
+	progMismatch = 2
+	procUnavail  = 3
+	garbageArgs  = 4
+)
+
+// lastFragment marks the last fragment of a record sent over TCP.
+const lastFragment = 1 << 31
+
+// maxRecord limits the size of a record received from a client.
+const maxRecord = 4 << 20
+
+var errGarbageArgs = errors.New("vxi11: cannot decode arguments")
+
+// procedure decodes the arguments from r and encodes the results to w. An
+// error decoding the arguments causes a GARBAGE_ARGS reply.
+type procedure func(r *xdrReader, w *xdrWriter) error
+
+// program is an ONC RPC program.
+type program struct {
+	prog, vers uint32
+	procs      map[uint32]procedure
+}
+
+// readRecord reads a record, which consists of one or more fragments, using
+// the record marking of RFC 5531.
+func readRecord(r io.Reader) ([]byte, error) {
+	var record []byte
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, err
+		}
+		h := binary.BigEndian.Uint32(hdr[:])
+		n := int(h &^ lastFragment)
+		if len(record)+n > maxRecord {
+			return nil, fmt.Errorf("vxi11: record exceeds %d bytes", maxRecord)
+		}
+		start := len(record)
+		record = append(record, make([]byte, n)...)
+		if _, err := io.ReadFull(r, record[start:]); err != nil {
+			return nil, err
+		}
+		if h&lastFragment != 0 {
+			return record, nil
+		}
+	}
+}
+
+// writeRecord writes the record as a single fragment.
+func writeRecord(w io.Writer, record []byte) error {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(record))|lastFragment)
+	_, err := w.Write(append(buf, record...))
+	return err
+}
+
+// serveRPC serves calls to the programs received over a TCP connection.
+func serveRPC(conn net.Conn, programs ...program) error {
+	for {
+		call, err := readRecord(conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		reply, ok := dispatch(call, programs)
+		if !ok {
+			continue
+		}
+		if err := writeRecord(conn, reply); err != nil {
+			return err
+		}
+	}
+}
+
+// dispatch decodes the call, calls the procedure, and returns the reply. It
+// returns false if the message isn't a call, which isn't replied to.
+func dispatch(call []byte, programs []program) ([]byte, bool) {
+	r := &xdrReader{b: call}
+	xid := r.uint32()
+	if r.uint32() != msgCall || r.err != nil {
+		return nil, false
+	}
+	w := &xdrWriter{}
+	w.uint32(xid)
+	w.uint32(msgReply)
+	if r.uint32() != rpcVersion {
+		w.uint32(msgDenied)
+		w.uint32(rpcMismatch)
+		w.uint32(rpcVersion)
+		w.uint32(rpcVersion)
+		return w.b, true
+	}
+	prog, vers, proc := r.uint32(), r.uint32(), r.uint32()
+	// Skip the credentials and verifier, since authentication isn't used.
+	for i := 0; i < 2; i++ {
+		r.uint32()
+		r.opaque()
+	}
+	w.uint32(msgAccepted)
+	w.uint32(0) // AUTH_NONE verifier.
+	w.uint32(0)
+	if r.err != nil {
+		w.uint32(garbageArgs)
+		return w.b, true
+	}
+	var p *program
+	low, high := ^uint32(0), uint32(0)
+	for i := range programs {
+		if programs[i].prog != prog {
+			continue
+		}
+		low, high = min(low, programs[i].vers), max(high, programs[i].vers)
+		if programs[i].vers == vers {
+			p = &programs[i]
+		}
+	}
+	switch {
+	case high == 0:
+		w.uint32(progUnavail)
+		return w.b, true
+	case p == nil:
+		w.uint32(progMismatch)
+		w.uint32(low)
+		w.uint32(high)
+		return w.b, true
+	}
+	fn, ok := p.procs[proc]
+	if !ok {
+		w.uint32(procUnavail)
+		return w.b, true
+	}
+	res := &xdrWriter{}
+	if err := fn(r, res); err != nil || r.err != nil {
+		w.uint32(garbageArgs)
+		return w.b, true
+	}
+	w.uint32(success)
+	return append(w.b, res.b...), true
+}
+
+// xdrReader decodes XDR data as defined by RFC 4506. The first error is kept
+// and all subsequent reads return zero values.
+type xdrReader struct {
+	b   []byte
+	err error
+}
+
+func (r *xdrReader) uint32() uint32 {
+	if r.err != nil || len(r.b) < 4 {
+		r.err = errGarbageArgs
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *xdrReader) int32() int32 {
+	return int32(r.uint32())
+}
+
+func (r *xdrReader) bool() bool {
+	return r.uint32() != 0
+}
+
+// opaque reads variable length opaque data, which is padded to a multiple of
+// four bytes.
+func (r *xdrReader) opaque() []byte {
+	n := int(r.uint32())
+	padded := (n + 3) &^ 3
+	if r.err != nil || n < 0 || padded > len(r.b) {
+		r.err = errGarbageArgs
+		return nil
+	}
+	data := r.b[:n]
+	r.b = r.b[padded:]
+	return data
+}
+
+func (r *xdrReader) string() string {
+	return string(r.opaque())
+}
+
+// xdrWriter encodes XDR data as defined by RFC 4506.
+type xdrWriter struct {
+	b []byte
+}
+
+func (w *xdrWriter) uint32(v uint32) {
+	w.b = binary.BigEndian.AppendUint32(w.b, v)
+}
+
+func (w *xdrWriter) int32(v int32) {
+	w.uint32(uint32(v))
+}
+
+func (w *xdrWriter) opaque(data []byte) {
+	w.uint32(uint32(len(data)))
+	w.b = append(w.b, data...)
+	for len(w.b)%4 != 0 {
+		w.b = append(w.b, 0)
+	}
+}
+
+// Portmapper program from RFC 1833.
+const (
+	portmapperProg = 100000
+	portmapperVers = 2
+	// PortmapperPort is the well known port of the portmapper.
+	PortmapperPort = 111
+
+	pmapProcNull    = 0
+	pmapProcGetPort = 3
+
+	ipprotoTCP = 6
+)
+
+// portmapper returns the portmapper program, which maps the VXI-11 core
+// channel to the given TCP port.
+func portmapper(corePort uint32) program {
+	return program{
+		prog: portmapperProg,
+		vers: portmapperVers,
+		procs: map[uint32]procedure{
+			pmapProcNull: func(*xdrReader, *xdrWriter) error { return nil },
+			pmapProcGetPort: func(r *xdrReader, w *xdrWriter) error {
+				prog, vers, prot := r.uint32(), r.uint32(), r.uint32()
+				r.uint32() // Port, which is ignored.
+				var port uint32
+				if prog == coreProg && vers == coreVers && prot == ipprotoTCP {
+					port = corePort
+				}
+				w.uint32(port)
+				return nil
+			},
+		},
+	}
+}
@@ -0,0 +1,670 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+/*
+Package vxi11 exposes the GPIB instruments of a prologix.Controller as VXI-11
+instruments, so that VISA and LXI software can use them as TCPIP::host::gpib0,N
+resources without custom drivers.
+
+The Server implements the VXI-11 core channel, which is ONC RPC program 0x0607AF
+over TCP. Links are created using device names of the form "gpib0,N", where N
+is the primary GPIB address of the instrument. The operations of each link are
+mapped onto the Controller as follows:
+
+  - device_write sends the data using Controller.WriteBinary once the END flag
+    is received, without its trailing newline, which the Prologix replaces
+    with the GPIB termination and EOI.
+  - device_read reads the response using Controller.ReadResponseWithTimeout,
+    waiting up to the io_timeout of the client.
+  - device_readstb serial polls the instrument using `++spoll`.
+  - device_trigger sends Group Execute Trigger using `++trg`.
+  - device_clear sends Selected Device Clear using `++clr`.
+  - device_local returns the instrument to local control using `++loc`.
+  - device_remote succeeds without action, since addressing the instrument
+    places it in remote.
+  - device_lock and device_unlock lock the instrument for exclusive use by a
+    link.
+
+The device_readstb, device_trigger, device_clear, device_local, and
+device_remote operations return an I/O timeout if they don't complete within
+the io_timeout of the client, such as while another link holds the bus.
+
+The abort channel, interrupt channel, service requests, and device_docmd are
+not supported. VXI-11 clients locate the core channel using the portmapper,
+which is served by ServePortmapper and ServePortmapperUDP.
+*/
+package vxi11
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/netserver"
+)
+
+// VXI-11 core channel program.
+const (
+	coreProg = 0x0607AF
+	coreVers = 1
+)
+
+// Core channel procedures.
+const (
+	createLink      = 10
+	deviceWrite     = 11
+	deviceRead      = 12
+	deviceReadSTB   = 13
+	deviceTrigger   = 14
+	deviceClear     = 15
+	deviceRemote    = 16
+	deviceLocal     = 17
+	deviceLock      = 18
+	deviceUnlock    = 19
+	deviceEnableSRQ = 20
+	deviceDoCmd     = 22
+	destroyLink     = 23
+	createIntrChan  = 25
+	destroyIntrChan = 26
+)
+
+// Device_ErrorCode values.
+const (
+	errNone            = 0
+	errInvalidLink     = 4
+	errChannelNotSetUp = 6
+	errNotSupported    = 8
+	errOutOfResources  = 9
+	errLocked          = 11
+	errNoLock          = 12
+	errIOTimeout       = 15
+	errIO              = 17
+	errInvalidAddress  = 21
+)
+
+// Device_Flags bits.
+const (
+	flagWaitLock   = 1
+	flagEnd        = 8
+	flagTermChrSet = 128
+)
+
+// device_read reasons.
+const (
+	reasonReqCnt = 1
+	reasonChr    = 2
+	reasonEnd    = 4
+)
+
+// maxRecvSize is the largest device_write data accepted from a client. Longer
+// messages are sent by clients in several writes.
+const maxRecvSize = 1 << 20
+
+// maxMessage limits the length of a message buffered from several writes.
+const maxMessage = 16 << 20
+
+// lockPoll is the interval at which a lock held by another link is checked
+// while waiting for it to be released.
+const lockPoll = 10 * time.Millisecond
+
+// Server serves the VXI-11 core channel for the instruments of a Controller.
+type Server struct {
+	c       *prologix.Controller
+	logger  *slog.Logger
+	mu      sync.Mutex
+	devices map[int]*prologix.Device
+	links   map[uint32]*link
+	locks   map[int]uint32 // GPIB address to the ID of the link holding its lock.
+	lastID  uint32
+	group   netserver.Group
+	packets map[net.PacketConn]struct{}
+}
+
+// link is a VXI-11 link to an instrument created by a client.
+type link struct {
+	id      uint32
+	dev     *prologix.Device
+	written []byte // Data of a message without the END flag yet.
+	pending []byte // Response data not yet read by the client.
+}
+
+// Option applies an option to the Server.
+type Option func(*Server)
+
+// WithLogger sets the logger used to log connections and errors, which are
+// discarded by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) { s.logger = logger }
+}
+
+// New creates a Server for the instruments of the given Controller. While the
+// Server is in use, the Controller must not be used directly.
+func New(c *prologix.Controller, opts ...Option) *Server {
+	s := Server{
+		c:       c,
+		logger:  netserver.DiscardLogger(),
+		devices: make(map[int]*prologix.Device),
+		links:   make(map[uint32]*link),
+		locks:   make(map[int]uint32),
+		packets: make(map[net.PacketConn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return &s
+}
+
+// ListenAndServe listens on the TCP network address and serves the VXI-11 core
+// channel. It blocks until the Server is closed.
+func (s *Server) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the listener and serves the VXI-11 core channel.
+// It blocks until the Server is closed, after which it returns nil. The
+// listener is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.logger.Info("serving VXI-11 core channel", "listen", l.Addr().String())
+	return s.group.Serve(l, s.serveConn)
+}
+
+// ServePortmapper accepts connections on the listener and answers portmapper
+// GETPORT requests for the VXI-11 core channel with corePort. The portmapper
+// normally listens on PortmapperPort. It blocks until the Server is closed.
+func (s *Server) ServePortmapper(l net.Listener, corePort int) error {
+	pmap := portmapper(uint32(corePort))
+	return s.group.Serve(l, func(conn net.Conn) {
+		if err := serveRPC(conn, pmap); err != nil && !s.group.Closed() {
+			s.logger.Warn("portmapper connection failed", "error", err)
+		}
+	})
+}
+
+// ServePortmapperUDP answers portmapper GETPORT requests received on the
+// packet connection like ServePortmapper. It blocks until the Server is
+// closed, after which it returns nil. The packet connection is closed when
+// ServePortmapperUDP returns.
+func (s *Server) ServePortmapperUDP(pc net.PacketConn, corePort int) error {
+	defer pc.Close()
+	s.mu.Lock()
+	if s.group.Closed() {
+		s.mu.Unlock()
+		return nil
+	}
+	s.packets[pc] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.packets, pc)
+		s.mu.Unlock()
+	}()
+
+	pmap := []program{portmapper(uint32(corePort))}
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.group.Closed() {
+				return nil
+			}
+			return err
+		}
+		reply, ok := dispatch(buf[:n], pmap)
+		if !ok {
+			continue
+		}
+		if _, err := pc.WriteTo(reply, addr); err != nil {
+			s.logger.Warn("portmapper reply failed", "client", addr.String(), "error", err)
+		}
+	}
+}
+
+// Close stops all listeners, closes all client connections, and waits for the
+// connections to finish.
+func (s *Server) Close() error {
+	err := s.group.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for pc := range s.packets {
+		pc.Close()
+	}
+	return err
+}
+
+// serveConn serves the core channel calls of one client. The links created by
+// the client are destroyed when it disconnects.
+func (s *Server) serveConn(conn net.Conn) {
+	log := s.logger.With("client", conn.RemoteAddr().String())
+	log.Info("client connected")
+	defer log.Info("client disconnected")
+
+	owned := make(map[uint32]bool)
+	defer func() {
+		for id := range owned {
+			s.destroy(id)
+		}
+	}()
+	if err := serveRPC(conn, s.core(log, owned)); err != nil && !s.group.Closed() {
+		log.Warn("connection failed", "error", err)
+	}
+}
+
+// core returns the core channel program for a client connection, which owns
+// the given links.
+func (s *Server) core(log *slog.Logger, owned map[uint32]bool) program {
+	// deviceError returns a procedure that decodes the link ID and any
+	// other arguments, calls fn, and encodes the Device_Error result.
+	deviceError := func(fn func(r *xdrReader, lid uint32) int32) procedure {
+		return func(r *xdrReader, w *xdrWriter) error {
+			lid := r.uint32()
+			code := fn(r, lid)
+			if r.err != nil {
+				return r.err
+			}
+			w.int32(code)
+			return nil
+		}
+	}
+	// generic decodes Device_GenericParms and calls fn with the link while
+	// holding its lock, waiting up to io_timeout for fn to complete.
+	generic := func(fn func(l *link) error) procedure {
+		return deviceError(func(r *xdrReader, lid uint32) int32 {
+			flags, lockTimeout, ioTimeout := r.uint32(), r.uint32(), r.uint32()
+			if r.err != nil {
+				return errNone
+			}
+			l, code := s.acquire(lid, flags, lockTimeout)
+			if code != errNone {
+				return code
+			}
+			return s.errorCode(log, l, timed(ioTimeout, func() error { return fn(l) }))
+		})
+	}
+
+	return program{
+		prog: coreProg,
+		vers: coreVers,
+		procs: map[uint32]procedure{
+			createLink: func(r *xdrReader, w *xdrWriter) error {
+				r.int32() // clientId
+				lockDevice, lockTimeout, device := r.bool(), r.uint32(), r.string()
+				if r.err != nil {
+					return r.err
+				}
+				id, code := s.create(device)
+				if code == errNone && lockDevice {
+					if code = s.lock(id, flagWaitLock, lockTimeout); code != errNone {
+						s.destroy(id)
+					}
+				}
+				if code == errNone {
+					owned[id] = true
+					log.Info("link created", "link", id, "device", device)
+				} else {
+					id = 0
+				}
+				w.int32(code)
+				w.uint32(id)
+				w.uint32(0) // abortPort
+				w.uint32(maxRecvSize)
+				return nil
+			},
+			deviceWrite: func(r *xdrReader, w *xdrWriter) error {
+				lid := r.uint32()
+				r.uint32() // io_timeout
+				lockTimeout, flags, data := r.uint32(), r.uint32(), r.opaque()
+				if r.err != nil {
+					return r.err
+				}
+				code := s.write(log, lid, flags, lockTimeout, data)
+				w.int32(code)
+				if code != errNone {
+					data = nil
+				}
+				w.uint32(uint32(len(data)))
+				return nil
+			},
+			deviceRead: func(r *xdrReader, w *xdrWriter) error {
+				lid, requestSize, ioTimeout := r.uint32(), r.uint32(), r.uint32()
+				lockTimeout, flags, termChar := r.uint32(), r.uint32(), r.uint32()
+				if r.err != nil {
+					return r.err
+				}
+				code, reason, data := s.read(log, lid, requestSize, flags, lockTimeout, ioTimeout, byte(termChar))
+				w.int32(code)
+				w.uint32(reason)
+				w.opaque(data)
+				return nil
+			},
+			deviceReadSTB: func(r *xdrReader, w *xdrWriter) error {
+				lid, flags, lockTimeout, ioTimeout := r.uint32(), r.uint32(), r.uint32(), r.uint32()
+				if r.err != nil {
+					return r.err
+				}
+				var stb byte
+				l, code := s.acquire(lid, flags, lockTimeout)
+				if code == errNone {
+					code = s.errorCode(log, l, timed(ioTimeout, func() error {
+						return l.dev.Do(func(c *prologix.Controller) (err error) {
+							stb, err = c.SerialPoll()
+							return err
+						})
+					}))
+				}
+				w.int32(code)
+				w.uint32(uint32(stb))
+				return nil
+			},
+			deviceTrigger: generic(func(l *link) error {
+				return l.dev.Do((*prologix.Controller).Trigger)
+			}),
+			deviceClear: generic(func(l *link) error {
+				s.mu.Lock()
+				l.written, l.pending = nil, nil
+				s.mu.Unlock()
+				return l.dev.Do((*prologix.Controller).ClearDevice)
+			}),
+			deviceRemote: generic(func(*link) error { return nil }),
+			deviceLocal: generic(func(l *link) error {
+				return l.dev.Do(func(c *prologix.Controller) error { return c.FrontPanel(true) })
+			}),
+			deviceLock: deviceError(func(r *xdrReader, lid uint32) int32 {
+				flags, lockTimeout := r.uint32(), r.uint32()
+				return s.lock(lid, flags, lockTimeout)
+			}),
+			deviceUnlock: deviceError(func(_ *xdrReader, lid uint32) int32 {
+				return s.unlock(lid)
+			}),
+			deviceEnableSRQ: deviceError(func(r *xdrReader, _ uint32) int32 {
+				r.bool()   // enable
+				r.opaque() // handle
+				return errNotSupported
+			}),
+			deviceDoCmd: func(r *xdrReader, w *xdrWriter) error {
+				w.int32(errNotSupported)
+				w.opaque(nil)
+				return nil
+			},
+			destroyLink: deviceError(func(_ *xdrReader, lid uint32) int32 {
+				if !owned[lid] {
+					return errInvalidLink
+				}
+				delete(owned, lid)
+				s.destroy(lid)
+				log.Info("link destroyed", "link", lid)
+				return errNone
+			}),
+			createIntrChan: func(_ *xdrReader, w *xdrWriter) error {
+				w.int32(errNotSupported)
+				return nil
+			},
+			destroyIntrChan: func(_ *xdrReader, w *xdrWriter) error {
+				w.int32(errChannelNotSetUp)
+				return nil
+			},
+		},
+	}
+}
+
+// create creates a link to the instrument with the given device name.
+func (s *Server) create(device string) (uint32, int32) {
+	addr, err := parseDevice(device)
+	if err != nil {
+		s.logger.Warn("invalid device name", "device", device, "error", err)
+		return 0, errInvalidAddress
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dev, ok := s.devices[addr]
+	if !ok {
+		if dev, err = s.c.Device(addr); err != nil {
+			return 0, errInvalidAddress
+		}
+		s.devices[addr] = dev
+	}
+	s.lastID++
+	if s.lastID == 0 {
+		s.lastID++
+	}
+	s.links[s.lastID] = &link{id: s.lastID, dev: dev}
+	return s.lastID, errNone
+}
+
+// destroy destroys the link, releasing its lock.
+func (s *Server) destroy(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[id]
+	if !ok {
+		return
+	}
+	if s.locks[l.dev.Address()] == id {
+		delete(s.locks, l.dev.Address())
+	}
+	delete(s.links, id)
+}
+
+// acquire returns the link once the instrument isn't locked by another link.
+// If the waitlock flag is set, it waits up to lockTimeout milliseconds for the
+// lock to be released.
+func (s *Server) acquire(id, flags, lockTimeout uint32) (*link, int32) {
+	deadline := time.Now().Add(time.Duration(lockTimeout) * time.Millisecond)
+	for {
+		s.mu.Lock()
+		l, ok := s.links[id]
+		holder, locked := s.locks[l.address()]
+		s.mu.Unlock()
+		switch {
+		case !ok:
+			return nil, errInvalidLink
+		case !locked || holder == id:
+			return l, errNone
+		case flags&flagWaitLock == 0 || time.Now().After(deadline):
+			return nil, errLocked
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// lock locks the instrument of the link for its exclusive use.
+func (s *Server) lock(id, flags, lockTimeout uint32) int32 {
+	deadline := time.Now().Add(time.Duration(lockTimeout) * time.Millisecond)
+	for {
+		s.mu.Lock()
+		l, ok := s.links[id]
+		if !ok {
+			s.mu.Unlock()
+			return errInvalidLink
+		}
+		holder, locked := s.locks[l.address()]
+		if !locked {
+			s.locks[l.address()] = id
+		}
+		s.mu.Unlock()
+		switch {
+		case !locked:
+			return errNone
+		case holder == id, flags&flagWaitLock == 0, time.Now().After(deadline):
+			return errLocked
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// unlock releases the lock held by the link.
+func (s *Server) unlock(id uint32) int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[id]
+	if !ok {
+		return errInvalidLink
+	}
+	if s.locks[l.address()] != id {
+		return errNoLock
+	}
+	delete(s.locks, l.address())
+	return errNone
+}
+
+// write buffers the data until the END flag is received and then sends the
+// message to the instrument.
+func (s *Server) write(log *slog.Logger, id, flags, lockTimeout uint32, data []byte) int32 {
+	l, code := s.acquire(id, flags, lockTimeout)
+	if code != errNone {
+		return code
+	}
+	s.mu.Lock()
+	if len(l.written)+len(data) > maxMessage {
+		l.written = nil
+		s.mu.Unlock()
+		return errOutOfResources
+	}
+	l.written = append(l.written, data...)
+	msg := l.written
+	if flags&flagEnd != 0 {
+		l.written = nil
+		l.pending = nil
+	}
+	s.mu.Unlock()
+	if flags&flagEnd == 0 {
+		return errNone
+	}
+	msg = bytes.TrimSuffix(msg, []byte("\n"))
+	msg = bytes.TrimSuffix(msg, []byte("\r"))
+	_, err := l.dev.WriteBinary(msg)
+	return s.errorCode(log, l, err)
+}
+
+// read returns up to requestSize bytes of the response of the instrument,
+// reading a new response if none is pending. The response is awaited for up
+// to ioTimeout milliseconds, sending `++read eoi` again whenever the Prologix
+// read timeout expires; an ioTimeout of zero waits only for the Prologix read
+// timeout. If the termchrset flag is set, the data ends after the first
+// termChar.
+func (s *Server) read(
+	log *slog.Logger,
+	id, requestSize, flags, lockTimeout, ioTimeout uint32,
+	termChar byte,
+) (code int32, reason uint32, data []byte) {
+	l, code := s.acquire(id, flags, lockTimeout)
+	if code != errNone {
+		return code, 0, nil
+	}
+	s.mu.Lock()
+	pending := l.pending
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		resp, err := readResponse(l.dev, ioTimeout)
+		if err != nil {
+			return s.errorCode(log, l, err), 0, nil
+		}
+		pending = []byte(resp)
+	}
+
+	n := min(len(pending), int(min(requestSize, maxRecvSize)))
+	if flags&flagTermChrSet != 0 {
+		if i := bytes.IndexByte(pending[:n], termChar); i >= 0 {
+			n = i + 1
+			reason |= reasonChr
+		}
+	}
+	switch {
+	case n == len(pending):
+		reason |= reasonEnd
+	case n == int(requestSize):
+		reason |= reasonReqCnt
+	}
+	s.mu.Lock()
+	l.pending = pending[n:]
+	s.mu.Unlock()
+	return errNone, reason, pending[:n]
+}
+
+// readResponse reads the response of the instrument, waiting up to ioTimeout
+// milliseconds if the transport of the Controller supports it.
+func readResponse(dev *prologix.Device, ioTimeout uint32) (string, error) {
+	if ioTimeout > 0 {
+		resp, err := dev.ReadResponseWithTimeout(time.Duration(ioTimeout) * time.Millisecond)
+		if !errors.Is(err, prologix.ErrUnsupported) {
+			return resp, err
+		}
+	}
+	return dev.ReadResponse()
+}
+
+// timed calls fn and waits up to ioTimeout milliseconds for it to complete,
+// such as while another link holds the bus, after which ErrTimeout is returned
+// and fn completes in the background. An ioTimeout of zero waits for fn to
+// complete.
+func timed(ioTimeout uint32, fn func() error) error {
+	if ioTimeout == 0 {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	timer := time.NewTimer(time.Duration(ioTimeout) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%w: operation didn't complete within %d ms", prologix.ErrTimeout, ioTimeout)
+	}
+}
+
+// errorCode logs the error and returns its Device_ErrorCode.
+func (s *Server) errorCode(log *slog.Logger, l *link, err error) int32 {
+	switch {
+	case err == nil:
+		return errNone
+	case errors.Is(err, prologix.ErrTimeout):
+		log.Info("I/O timeout", "address", l.address(), "error", err)
+		return errIOTimeout
+	default:
+		log.Warn("I/O error", "address", l.address(), "error", err)
+		return errIO
+	}
+}
+
+// address returns the GPIB address of the link's instrument, or -1 for a nil
+// link.
+func (l *link) address() int {
+	if l == nil {
+		return -1
+	}
+	return l.dev.Address()
+}
+
+// parseDevice parses a VXI-11 device name of the form "gpib0,N" and returns
+// the primary GPIB address N. The interface name is case insensitive and may
+// also be "hpib", as used by some clients.
+func parseDevice(device string) (int, error) {
+	name, addr, ok := strings.Cut(strings.ToLower(strings.TrimSpace(device)), ",")
+	if !ok {
+		return 0, fmt.Errorf("missing GPIB address in %q", device)
+	}
+	if !strings.HasPrefix(name, "gpib") && !strings.HasPrefix(name, "hpib") {
+		return 0, fmt.Errorf("unsupported interface %q", name)
+	}
+	if name[4:] != "" && name[4:] != "0" {
+		return 0, fmt.Errorf("unsupported interface %q", name)
+	}
+	n, err := strconv.Atoi(addr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid GPIB address %q", addr)
+	}
+	return n, nil
+}
@@ -0,0 +1,344 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package vxi11
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotmc/prologix"
+	"github.com/gotmc/prologix/internal/emulatortest"
+)
+
+// meter emulates an instrument that answers `*IDN?` and counts triggers.
+// `MEAS?` is answered only after a delay, like a slow measurement.
+type meter struct {
+	mu       sync.Mutex
+	triggers int
+	cleared  bool
+	ready    time.Time
+}
+
+// measureDelay is longer than the read timeout of the emulated Prologix.
+const measureDelay = 700 * time.Millisecond
+
+// curve is a binary block response containing LF bytes.
+const curve = "#16\x01\n\x02\n\n\x00\n"
+
+func (m *meter) Handle(msg []byte) string {
+	switch string(msg) {
+	case "*IDN?":
+		return "ACME,METER,1,1.0\n"
+	case "CURV?":
+		return curve
+	case "MEAS?":
+		m.mu.Lock()
+		m.ready = time.Now().Add(measureDelay)
+		m.mu.Unlock()
+	}
+	return ""
+}
+
+func (m *meter) Talk() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ready.IsZero() || time.Now().Before(m.ready) {
+		return ""
+	}
+	m.ready = time.Time{}
+	return "+1.5E+00\n"
+}
+
+func (m *meter) Trigger() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.triggers++
+}
+
+func (m *meter) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cleared = true
+}
+
+func (m *meter) StatusByte() byte { return 0x50 }
+
+// client is a minimal ONC RPC client over TCP.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	xid  uint32
+}
+
+func dial(t *testing.T, address string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Dial error: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn}
+}
+
+// call calls the procedure with the encoded arguments and returns a reader for
+// the results.
+func (c *client) call(prog, vers, proc uint32, args *xdrWriter) *xdrReader {
+	c.t.Helper()
+	c.xid++
+	w := &xdrWriter{}
+	for _, v := range []uint32{c.xid, msgCall, rpcVersion, prog, vers, proc, 0, 0, 0, 0} {
+		w.uint32(v)
+	}
+	w.b = append(w.b, args.b...)
+	if err := writeRecord(c.conn, w.b); err != nil {
+		c.t.Fatalf("writeRecord error: %s", err)
+	}
+	reply, err := readRecord(c.conn)
+	if err != nil {
+		c.t.Fatalf("readRecord error: %s", err)
+	}
+	r := &xdrReader{b: reply}
+	hdr := []uint32{r.uint32(), r.uint32(), r.uint32(), r.uint32(), r.uint32(), r.uint32()}
+	if want := []uint32{c.xid, msgReply, msgAccepted, 0, 0, success}; !equal(hdr, want) {
+		c.t.Fatalf("reply header = %v, want %v", hdr, want)
+	}
+	return r
+}
+
+func (c *client) core(proc uint32, args ...any) *xdrReader {
+	c.t.Helper()
+	w := &xdrWriter{}
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case int:
+			w.uint32(uint32(v))
+		case bool:
+			if v {
+				w.uint32(1)
+			} else {
+				w.uint32(0)
+			}
+		case string:
+			w.opaque([]byte(v))
+		}
+	}
+	return c.call(coreProg, coreVers, proc, w)
+}
+
+func equal(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCoreChannel(t *testing.T) {
+	m := &meter{}
+	gpib, adapter := emulatortest.NewController(t, 5, emulatortest.Instruments{5: m})
+	s := New(gpib)
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	c := dial(t, address)
+
+	r := c.core(createLink, 1, false, 0, "gpib0,5")
+	if code, lid := r.int32(), r.uint32(); code != errNone || lid == 0 {
+		t.Fatalf("create_link = %d, %d", code, lid)
+	}
+	const lid = 1
+
+	r = c.core(deviceWrite, lid, 1000, 0, flagEnd, "*IDN?\n")
+	if code, size := r.int32(), r.uint32(); code != errNone || size != 6 {
+		t.Errorf("device_write = %d, %d; want 0, 6", code, size)
+	}
+	var resp strings.Builder
+	for i := 0; i < 10; i++ {
+		r = c.core(deviceRead, lid, 8, 1000, 0, 0, 0)
+		code, reason, data := r.int32(), r.uint32(), r.string()
+		if code != errNone {
+			t.Fatalf("device_read error %d", code)
+		}
+		resp.WriteString(data)
+		if reason&reasonEnd != 0 {
+			break
+		}
+		if reason != reasonReqCnt {
+			t.Errorf("reason = %d; want %d", reason, reasonReqCnt)
+		}
+	}
+	if got, want := resp.String(), "ACME,METER,1,1.0\n"; got != want {
+		t.Errorf("response = %q; want %q", got, want)
+	}
+
+	r = c.core(deviceReadSTB, lid, 0, 0, 1000)
+	if code, stb := r.int32(), r.uint32(); code != errNone || stb != 0x50 {
+		t.Errorf("device_readstb = %d, %#x; want 0, 0x50", code, stb)
+	}
+	for _, proc := range []uint32{deviceTrigger, deviceClear, deviceRemote, deviceLocal} {
+		if code := c.core(proc, lid, 0, 0, 1000).int32(); code != errNone {
+			t.Errorf("procedure %d error %d", proc, code)
+		}
+	}
+	m.mu.Lock()
+	if m.triggers != 1 || !m.cleared {
+		t.Errorf("triggers = %d, cleared = %t; want 1, true", m.triggers, m.cleared)
+	}
+	m.mu.Unlock()
+	if !adapter.Local(5) {
+		t.Error("instrument not returned to local control")
+	}
+
+	if code := c.core(destroyLink, lid).int32(); code != errNone {
+		t.Errorf("destroy_link error %d", code)
+	}
+	if code := c.core(deviceTrigger, lid, 0, 0, 1000).int32(); code != errInvalidLink {
+		t.Errorf("device_trigger after destroy_link = %d; want %d", code, errInvalidLink)
+	}
+}
+
+func TestReadBinaryResponse(t *testing.T) {
+	gpib, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: &meter{}})
+	s := New(gpib)
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	c := dial(t, address)
+	r := c.core(createLink, 1, false, 0, "gpib0,5")
+	if code, lid := r.int32(), r.uint32(); code != errNone || lid == 0 {
+		t.Fatalf("create_link = %d, %d", code, lid)
+	}
+	const lid = 1
+
+	if code := c.core(deviceWrite, lid, 1000, 0, flagEnd, "CURV?\n").int32(); code != errNone {
+		t.Fatalf("device_write error %d", code)
+	}
+	r = c.core(deviceRead, lid, 1024, 1000, 0, 0, 0)
+	code, reason, data := r.int32(), r.uint32(), r.string()
+	if code != errNone {
+		t.Fatalf("device_read error %d", code)
+	}
+	if data != curve || reason != reasonEnd {
+		t.Errorf("device_read = %q, reason %d; want %q, reason %d", data, reason, curve, reasonEnd)
+	}
+}
+
+func TestReadIOTimeout(t *testing.T) {
+	gpib, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: &meter{}})
+	s := New(gpib)
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	c := dial(t, address)
+	r := c.core(createLink, 1, false, 0, "gpib0,5")
+	if code, lid := r.int32(), r.uint32(); code != errNone || lid == 0 {
+		t.Fatalf("create_link = %d, %d", code, lid)
+	}
+	const lid = 1
+
+	tests := []struct {
+		ioTimeout int
+		code      int32
+		data      string
+	}{
+		{50, errIOTimeout, ""},
+		{5000, errNone, "+1.5E+00\n"},
+	}
+	for _, tc := range tests {
+		if code := c.core(deviceWrite, lid, 1000, 0, flagEnd, "MEAS?\n").int32(); code != errNone {
+			t.Fatalf("device_write error %d", code)
+		}
+		r = c.core(deviceRead, lid, 1024, tc.ioTimeout, 0, 0, 0)
+		code, _, data := r.int32(), r.uint32(), r.string()
+		if code != tc.code || data != tc.data {
+			t.Errorf("io_timeout %d ms: device_read = %d, %q; want %d, %q", tc.ioTimeout, code, data, tc.code, tc.data)
+		}
+	}
+}
+
+func TestTimed(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	start := time.Now()
+	err := timed(50, func() error {
+		<-block
+		return nil
+	})
+	if !errors.Is(err, prologix.ErrTimeout) {
+		t.Errorf("timed error %v; want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timed returned after %s", elapsed)
+	}
+	if err := timed(0, func() error { return nil }); err != nil {
+		t.Errorf("timed with zero io_timeout error %v", err)
+	}
+}
+
+func TestCreateLinkInvalidDevice(t *testing.T) {
+	gpib, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: &meter{}})
+	s := New(gpib)
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	c := dial(t, address)
+	for _, device := range []string{"inst0", "gpib0,31", "gpib1,5", "usb0,5"} {
+		if code := c.core(createLink, 1, false, 0, device).int32(); code != errInvalidAddress {
+			t.Errorf("create_link(%q) = %d; want %d", device, code, errInvalidAddress)
+		}
+	}
+}
+
+func TestLock(t *testing.T) {
+	gpib, _ := emulatortest.NewController(t, 5, emulatortest.Instruments{5: &meter{}})
+	s := New(gpib)
+	address := emulatortest.Serve(t, s.Serve, s.Close)
+	a, b := dial(t, address), dial(t, address)
+	if code := a.core(createLink, 1, true, 0, "gpib0,5").int32(); code != errNone {
+		t.Fatalf("create_link with lock error %d", code)
+	}
+	r := b.core(createLink, 2, false, 0, "gpib0,5")
+	r.int32()
+	lidB := r.uint32()
+
+	if code := b.core(deviceTrigger, int(lidB), flagWaitLock, 50, 1000).int32(); code != errLocked {
+		t.Errorf("device_trigger on locked instrument = %d; want %d", code, errLocked)
+	}
+	if code := b.core(deviceUnlock, int(lidB)).int32(); code != errNoLock {
+		t.Errorf("device_unlock without lock = %d; want %d", code, errNoLock)
+	}
+	// Disconnecting releases the lock of the client's links.
+	a.conn.Close()
+	if code := b.core(deviceLock, int(lidB), flagWaitLock, 2000).int32(); code != errNone {
+		t.Errorf("device_lock after disconnect = %d; want 0", code)
+	}
+}
+
+func TestPortmapper(t *testing.T) {
+	gpib, _ := emulatortest.NewController(t, 5, nil)
+	s := New(gpib)
+	address := emulatortest.Serve(t, func(l net.Listener) error { return s.ServePortmapper(l, 1024) }, s.Close)
+
+	client := dial(t, address)
+	tests := []struct {
+		prog, vers, prot uint32
+		want             uint32
+	}{
+		{coreProg, coreVers, ipprotoTCP, 1024},
+		{coreProg, coreVers, 17, 0},
+		{0x0607B0, 1, ipprotoTCP, 0},
+	}
+	for _, tc := range tests {
+		w := &xdrWriter{}
+		for _, v := range []uint32{tc.prog, tc.vers, tc.prot, 0} {
+			w.uint32(v)
+		}
+		r := client.call(portmapperProg, portmapperVers, pmapProcGetPort, w)
+		if got := r.uint32(); got != tc.want {
+			t.Errorf("GETPORT(%#x, %d, %d) = %d; want %d", tc.prog, tc.vers, tc.prot, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.uber.org/multierr"
+)
+
+// Mode provides the type for the Prologix controller operating modes.
+type Mode int
+
+// Available operating modes for the Prologix controller.
+const (
+	DeviceMode Mode = iota
+	ControllerMode
+)
+
+func (m Mode) String() string {
+	if m == ControllerMode {
+		return "controller mode"
+	}
+	return "device mode"
+}
+
+// Config holds the readable settings of the Prologix controller.
+type Config struct {
+	PrimaryAddr      int
+	HasSecondaryAddr bool
+	SecondaryAddr    int
+	Mode             Mode
+	ReadAfterWrite   bool
+	AssertEOI        bool
+	GPIBTermination  GpibTerm
+	EOTEnable        bool
+	EOTChar          byte
+	ReadTimeout      int // Read timeout in milliseconds.
+	SaveConfig       bool
+}
+
+// Config returns the settings the Controller believes the Prologix controller
+// to have, without querying the Prologix controller. SaveConfig is only true
+// when the SaveConfigEnable policy is in effect.
+func (c *Controller) Config() Config {
+	return Config{
+		PrimaryAddr:      c.primaryAddr,
+		HasSecondaryAddr: c.hasSecondaryAddr,
+		SecondaryAddr:    c.secondaryAddr,
+		Mode:             ControllerMode,
+		ReadAfterWrite:   c.auto,
+		AssertEOI:        c.eoi,
+		GPIBTermination:  c.eos,
+		EOTEnable:        c.eotEnable,
+		EOTChar:          c.eotChar,
+		ReadTimeout:      c.readTimeout,
+		SaveConfig:       c.saveConfig == SaveConfigEnable && !c.ar488,
+	}
+}
+
+// Snapshot queries every readable setting of the Prologix controller. The
+// settings cached in the Controller are not changed. The `savecfg` setting is
+// not queried when using an AR488, since `++savecfg` writes the EEPROM on the
+// AR488.
+func (c *Controller) Snapshot() (Config, error) {
+	var cfg Config
+	s, err := c.QueryController("addr")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.PrimaryAddr, cfg.SecondaryAddr, err = parseAddress(s)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.HasSecondaryAddr = cfg.SecondaryAddr != 0
+	mode, err := c.queryInt("mode")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Mode = Mode(mode)
+	if cfg.ReadAfterWrite, err = c.queryBool("auto"); err != nil {
+		return cfg, err
+	}
+	if cfg.AssertEOI, err = c.queryBool("eoi"); err != nil {
+		return cfg, err
+	}
+	if cfg.GPIBTermination, err = c.GPIBTermination(); err != nil {
+		return cfg, err
+	}
+	if cfg.EOTEnable, err = c.queryBool("eot_enable"); err != nil {
+		return cfg, err
+	}
+	eotChar, err := c.queryInt("eot_char")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.EOTChar = byte(eotChar)
+	if cfg.ReadTimeout, err = c.ReadTimeout(); err != nil {
+		return cfg, err
+	}
+	if !c.ar488 {
+		if cfg.SaveConfig, err = c.queryBool("savecfg"); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// Verify takes a Snapshot of the Prologix controller and compares it against
+// the settings cached in the Controller. A StateMismatchError is returned for
+// each difference, combined using multierr, and the cached setting is updated
+// to the actual value. The `savecfg` setting is only verified when the
+// SaveConfig policy is SaveConfigEnable or SaveConfigDisable.
+func (c *Controller) Verify() error {
+	actual, err := c.Snapshot()
+	if err != nil {
+		return err
+	}
+	mode := ControllerMode
+	errs := []error{
+		reconcile("primary address", &c.primaryAddr, actual.PrimaryAddr),
+		reconcile("secondary address", &c.secondaryAddr, actual.SecondaryAddr),
+		reconcile("mode", &mode, actual.Mode),
+		reconcile("auto", &c.auto, actual.ReadAfterWrite),
+		reconcile("eoi", &c.eoi, actual.AssertEOI),
+		reconcile("eos", &c.eos, actual.GPIBTermination),
+		reconcile("eot_enable", &c.eotEnable, actual.EOTEnable),
+		reconcile("eot_char", &c.eotChar, actual.EOTChar),
+		reconcile("read_tmo_ms", &c.readTimeout, actual.ReadTimeout),
+	}
+	c.hasSecondaryAddr = actual.HasSecondaryAddr
+	if !c.ar488 && c.saveConfig != SaveConfigUnchanged {
+		saveCfg := c.saveConfig == SaveConfigEnable
+		errs = append(errs, reconcile("savecfg", &saveCfg, actual.SaveConfig))
+	}
+	for _, err := range errs {
+		var mismatch *StateMismatchError
+		if errors.As(err, &mismatch) {
+			c.metrics.StateMismatch(mismatch.Setting)
+		}
+	}
+	return multierr.Combine(errs...) // result is nil if errs are all nil
+}
+
+// Apply configures the Prologix controller using the given Config, for
+// instance one returned by Snapshot, and updates the settings cached in the
+// Controller. Saving the configuration in the EEPROM is disabled while
+// applying the settings and then enabled if cfg.SaveConfig is true. The
+// `savecfg` setting is left untouched when using an AR488. Only controller
+// mode is supported.
+func (c *Controller) Apply(cfg Config) error {
+	if cfg.Mode != ControllerMode {
+		return fmt.Errorf("%w: %s", ErrUnsupported, cfg.Mode)
+	}
+	if !isPrimaryAddressValid(cfg.PrimaryAddr) {
+		return fmt.Errorf("%w: primary address %d (must be 0-30)", ErrInvalidAddress, cfg.PrimaryAddr)
+	}
+	if cfg.HasSecondaryAddr && !isSecondaryAddressValid(cfg.SecondaryAddr) {
+		return fmt.Errorf("%w: secondary address %d (must be 96-126)", ErrInvalidAddress, cfg.SecondaryAddr)
+	}
+	if !isReadTimeoutValid(cfg.ReadTimeout) {
+		return fmt.Errorf("read timeout outside 1 to 3000 ms; attempted to set to %d", cfg.ReadTimeout)
+	}
+	if _, ok := gpibTermDesc[cfg.GPIBTermination]; !ok {
+		return fmt.Errorf("invalid GPIB termination %d (must be 0-3)", cfg.GPIBTermination)
+	}
+
+	prev, prevPolicy := c.Config(), c.saveConfig
+	c.setCache(cfg)
+	c.saveConfig = SaveConfigDisable
+	if cfg.SaveConfig {
+		c.saveConfig = SaveConfigEnable
+	}
+	for _, cmd := range c.initCommands() {
+		if err := c.CommandController(cmd); err != nil {
+			// The state of the Prologix controller is unknown, so keep the
+			// previously cached settings, which Verify can later reconcile.
+			c.setCache(prev)
+			c.saveConfig = prevPolicy
+			return err
+		}
+	}
+	return nil
+}
+
+// setCache sets the cached settings, except for the SaveConfig policy, to
+// those in the given Config.
+func (c *Controller) setCache(cfg Config) {
+	c.primaryAddr = cfg.PrimaryAddr
+	c.hasSecondaryAddr = cfg.HasSecondaryAddr
+	c.secondaryAddr = 0
+	if cfg.HasSecondaryAddr {
+		c.secondaryAddr = cfg.SecondaryAddr
+	}
+	c.auto = cfg.ReadAfterWrite
+	c.eoi = cfg.AssertEOI
+	c.eos = cfg.GPIBTermination
+	c.eotEnable = cfg.EOTEnable
+	c.eotChar = cfg.EOTChar
+	c.readTimeout = cfg.ReadTimeout
+}
+
+// reconcile compares the cached value of a setting with the actual value. If
+// they differ, the cached value is updated and a StateMismatchError returned.
+func reconcile[T comparable](setting string, cached *T, actual T) error {
+	if *cached == actual {
+		return nil
+	}
+	err := &StateMismatchError{Setting: setting, Expected: *cached, Actual: actual}
+	*cached = actual
+	return err
+}
+
+// queryBool queries a Prologix setting that is either 0 or 1.
+func (c *Controller) queryBool(setting string) (bool, error) {
+	s, err := c.QueryController(setting)
+	if err != nil {
+		return false, err
+	}
+	switch strings.TrimSpace(s) {
+	case "1":
+		return true, nil
+	case "0":
+		return false, nil
+	}
+	return false, unexpectedResponse(controllerCommand(setting), s, nil)
+}
+
+// queryInt queries a Prologix setting that is an integer.
+func (c *Controller) queryInt(setting string) (int, error) {
+	s, err := c.QueryController(setting)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, unexpectedResponse(controllerCommand(setting), s, err)
+	}
+	return i, nil
+}
+
+// parseAddress parses the response to the Prologix `addr` command, which is
+// the primary address optionally followed by the secondary address. The
+// secondary address is zero if not present.
+func parseAddress(s string) (int, int, error) {
+	raw := s
+	s = strings.TrimSpace(s)
+	idx := 0
+	for i, c := range s {
+		if !unicode.IsNumber(c) {
+			idx = i
+			break
+		}
+	}
+	if idx == 0 {
+		idx = len(s)
+	}
+	pri, err := strconv.ParseInt(s[:idx], 10, 8)
+	if err != nil {
+		return 0, 0, unexpectedResponse("++addr", raw, err)
+	}
+	// secondary address?
+	remain := strings.TrimLeft(s[idx:], " :,\t")
+	var sec int64
+	if len(remain) > 0 {
+		sec, err = strconv.ParseInt(remain, 10, 8)
+		if err != nil {
+			return 0, 0, unexpectedResponse("++addr", raw, err)
+		}
+	}
+	return int(pri), int(sec), nil
+}
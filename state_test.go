@@ -0,0 +1,93 @@
+// Copyright (c) 2020–2024 The prologix developers. All rights reserved.
+// Project site: https://github.com/gotmc/prologix
+// Use of this source code is governed by a MIT-style license that
+// can be found in the LICENSE.txt file for the project.
+
+package prologix
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gotmc/prologix/internal/emulator"
+	"go.uber.org/multierr"
+)
+
+func TestSnapshotMatchesConfig(t *testing.T) {
+	adapter := emulator.New()
+	c, err := NewController(adapter, 9, false, WithReadTimeout(1200), WithGPIBTermination(AppendLF))
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	got, err := c.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot error: %s", err)
+	}
+	if want := c.Config(); got != want {
+		t.Errorf("Snapshot\n\tgot  %+v\n\twant %+v", got, want)
+	}
+	if err := c.Verify(); err != nil {
+		t.Errorf("Verify error: %s", err)
+	}
+}
+
+func TestVerifyDetectsMismatches(t *testing.T) {
+	adapter := emulator.New()
+	c, err := NewController(adapter, 9, false, WithSaveConfig(SaveConfigDisable))
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	want := c.Config()
+
+	// Emulate a power cycle of the Prologix controller.
+	adapter.SetSetting("auto", "1")
+	adapter.SetSetting("read_tmo_ms", "300")
+	adapter.SetSetting("savecfg", "1")
+
+	err = c.Verify()
+	errs := multierr.Errors(err)
+	if len(errs) != 3 {
+		t.Fatalf("got %d errors; want 3: %v", len(errs), err)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, ErrStateMismatch) {
+			t.Errorf("got %v; want ErrStateMismatch", err)
+		}
+	}
+	var mismatch *StateMismatchError
+	if !errors.As(errs[0], &mismatch) || mismatch.Setting != "auto" {
+		t.Errorf("got %v; want auto mismatch", errs[0])
+	}
+	if !c.auto || c.readTimeout != 300 {
+		t.Errorf("cached settings not updated: auto %t, read timeout %d", c.auto, c.readTimeout)
+	}
+
+	// Restore the original configuration.
+	if err := c.Apply(want); err != nil {
+		t.Fatalf("Apply error: %s", err)
+	}
+	if err := c.Verify(); err != nil {
+		t.Errorf("Verify after Apply error: %s", err)
+	}
+	if got := adapter.Setting("savecfg"); got != "0" {
+		t.Errorf("savecfg got %s; want 0", got)
+	}
+}
+
+func TestApplyInvalidConfig(t *testing.T) {
+	adapter := emulator.New()
+	c, err := NewController(adapter, 9, false)
+	if err != nil {
+		t.Fatalf("NewController error: %s", err)
+	}
+	cfg := c.Config()
+	cfg.PrimaryAddr = 31
+	if err := c.Apply(cfg); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("got %v; want ErrInvalidAddress", err)
+	}
+	cfg = c.Config()
+	cfg.Mode = DeviceMode
+	if err := c.Apply(cfg); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v; want ErrUnsupported", err)
+	}
+}